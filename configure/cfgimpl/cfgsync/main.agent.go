@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+	"github.com/meidoworks/nekoq-component/configure/configclient"
+)
+
+const (
+	// writeRetryInterval is the interval to retry writing the files after failed
+	writeRetryInterval = 5 * time.Second
+	// decryptRetryInterval is the interval to retry decrypting the values failed to decrypt
+	decryptRetryInterval = 10 * time.Second
+)
+
+// syncAgent receives the declared configurations via configclient.Client and writes them to the directory.
+// The files are written only after all declared configurations are received, and are always written together.
+type syncAgent struct {
+	spec    *SyncSpec
+	writer  *atomicWriter
+	secrets *secretDecrypter // nil if no secret server configured
+	hook    *reloadHook      // nil if no reload hook configured
+	// debounce is the time to wait for the other changes before writing, so the changes of a response are written
+	// together
+	debounce time.Duration
+	// decryptRetry is the interval to retry decrypting the pending values
+	decryptRetry time.Duration
+
+	lock sync.Mutex
+	// values is the latest received contents by path, written is the last written ones
+	values  map[string]fileContent
+	written map[string]fileContent
+	// pending contains the latest received configurations failed to decrypt by path, which are retried by Run since
+	// the client records the rejected versions as seen and does not deliver them again
+	pending map[string]pendingFile
+	changed chan struct{}
+}
+
+type pendingFile struct {
+	file FileSpec
+	cfg  configapi.Configuration
+}
+
+func newSyncAgent(spec *SyncSpec, writer *atomicWriter, secrets *secretDecrypter, hook *reloadHook, debounce time.Duration) *syncAgent {
+	return &syncAgent{
+		spec:     spec,
+		writer:   writer,
+		secrets:  secrets,
+		hook:     hook,
+		debounce: debounce,
+
+		decryptRetry: decryptRetryInterval,
+
+		values:  map[string]fileContent{},
+		pending: map[string]pendingFile{},
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// Register adds the declared configurations to the client, it should be called before the client starts
+func (a *syncAgent) Register(c *configclient.Client) {
+	for _, f := range a.spec.Files {
+		c.AddConfigurationRequirement(configclient.RequiredConfig{
+			Required: configapi.RequestedConfigurationKey{Group: f.Group, Key: f.Key},
+			ApplyCallback: func(cfg configapi.Configuration) error {
+				return a.apply(f, cfg)
+			},
+			DeleteCallback: func(key configapi.RequestedConfigurationKey) {
+				log.Println("[WARN] configuration deleted on server, keep the last written file:", f.Path, key.Group, key.Key)
+			},
+		})
+	}
+}
+
+// apply decrypts the value and schedules the files to be written. The value failed to decrypt is rejected and kept
+// pending, so the previous file is kept until the decryption succeeds on retry or a newer value arrives.
+func (a *syncAgent) apply(f FileSpec, cfg configapi.Configuration) error {
+	data, err := a.decrypt(f, cfg)
+	a.lock.Lock()
+	if err != nil {
+		a.pending[f.Path] = pendingFile{file: f, cfg: cfg}
+		a.lock.Unlock()
+		return err
+	}
+	delete(a.pending, f.Path)
+	a.values[f.Path] = fileContent{data: data, mode: f.FileMode()}
+	a.lock.Unlock()
+	a.notify()
+	return nil
+}
+
+func (a *syncAgent) decrypt(f FileSpec, cfg configapi.Configuration) ([]byte, error) {
+	if f.SecretKey == "" {
+		return cfg.Value, nil
+	}
+	if a.secrets == nil {
+		return nil, ErrNoSecretServer
+	}
+	return a.secrets.Decrypt(f.SecretKey, cfg.Value)
+}
+
+// retryPending decrypts the pending values again. The value is applied only if no newer one arrives in between.
+func (a *syncAgent) retryPending() {
+	a.lock.Lock()
+	pending := maps.Clone(a.pending)
+	a.lock.Unlock()
+	for path, p := range pending {
+		data, err := a.decrypt(p.file, p.cfg)
+		if err != nil {
+			log.Println("[ERROR] decrypt value failed, retry later:", path, p.cfg.Version, err)
+			continue
+		}
+		a.lock.Lock()
+		if cur, ok := a.pending[path]; ok && cur.cfg.Version == p.cfg.Version {
+			delete(a.pending, path)
+			a.values[path] = fileContent{data: data, mode: p.file.FileMode()}
+		}
+		a.lock.Unlock()
+		a.notify()
+	}
+}
+
+func (a *syncAgent) notify() {
+	select {
+	case a.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Sync writes the files if all declared configurations are received and any of them changes since the last write.
+// It returns whether the files are written.
+func (a *syncAgent) Sync() (bool, error) {
+	a.lock.Lock()
+	if len(a.values) < len(a.spec.Files) || sameContents(a.values, a.written) {
+		a.lock.Unlock()
+		return false, nil
+	}
+	files := maps.Clone(a.values)
+	a.lock.Unlock()
+
+	if err := a.writer.Write(files); err != nil {
+		return false, err
+	}
+	a.lock.Lock()
+	a.written = files
+	a.lock.Unlock()
+	return true, nil
+}
+
+func sameContents(a, b map[string]fileContent) bool {
+	return maps.EqualFunc(a, b, func(x, y fileContent) bool {
+		return x.mode == y.mode && bytes.Equal(x.data, y.data)
+	})
+}
+
+// Run writes the files on changes until ctx is done. The reload hook runs after each write except the first one,
+// since the application reads the files on startup. With once, Run returns after the first write, which suits the
+// init containers.
+func (a *syncAgent) Run(ctx context.Context, once bool) error {
+	retry := time.NewTicker(a.decryptRetry)
+	defer retry.Stop()
+	first := true
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-retry.C:
+			a.retryPending()
+			continue
+		case <-a.changed:
+		}
+		// wait for the other changes of the same round
+		timer := time.NewTimer(a.debounce)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		written, err := a.Sync()
+		if err != nil {
+			log.Println("[ERROR] write files failed:", err)
+			time.AfterFunc(writeRetryInterval, a.notify)
+			continue
+		}
+		if !written {
+			continue
+		}
+		if first {
+			first = false
+			log.Println("files synchronized to", a.writer.dir)
+			if once {
+				return nil
+			}
+			continue
+		}
+		log.Println("files updated in", a.writer.dir)
+		if a.hook != nil && a.hook.Enabled() {
+			if err := a.hook.Run(); err != nil {
+				log.Println("[ERROR] reload hook failed:", err)
+			}
+		}
+	}
+}
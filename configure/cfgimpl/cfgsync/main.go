@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"crypto/cipher"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+	"github.com/meidoworks/nekoq-component/configure/configclient"
+	"github.com/meidoworks/nekoq-component/configure/generalclient"
+)
+
+const usage = `cfgsync - synchronize configurations to the files of a directory for the applications reading files
+
+Usage:
+  cfgsync -servers http://cfg:8080 -sel env=prod,dc=dc1 -spec spec.json -dir /etc/app [options]
+
+The files are replaced atomically via the ..data symlink like the Kubernetes ConfigMap volumes.
+Run 'cfgsync -h' for the options.
+`
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("cfgsync", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	servers := fs.String("servers", "http://127.0.0.1:8080", "comma separated addresses of the read api")
+	token := fs.String("token", "", "bearer token of the tenant, required if tenants are declared on the server")
+	sel := fs.String("sel", "", "selectors, e.g. env=prod,dc=dc1 (required)")
+	optSel := fs.String("opt-sel", "", "optional selectors")
+	hierarchical := fs.Bool("hierarchical", false, "match the configurations under the selector hierarchy of the server")
+	specFile := fs.String("spec", "", "json file declaring the files: {\"files\": [{\"group\", \"key\", \"path\", \"mode\", \"secret_key\"}]} (required)")
+	dir := fs.String("dir", "", "directory to write the files (required)")
+	secretAddrs := fs.String("secret-addrs", "", "comma separated addresses of the secret server decrypting the files with secret_key")
+	secretToken := fs.String("secret-token", "", "bearer token of the secret server")
+	reloadPid := fs.Int("reload-pid", 0, "process to signal after the files are updated")
+	reloadPidFile := fs.String("reload-pidfile", "", "file containing the process to signal after the files are updated")
+	reloadSignal := fs.String("reload-signal", "HUP", "signal sent to the process: HUP, INT, QUIT, TERM or the signal number")
+	reloadUrl := fs.String("reload-url", "", "http endpoint requested with POST after the files are updated")
+	debounce := fs.Duration("debounce", 200*time.Millisecond, "time to wait for the other changes before writing")
+	once := fs.Bool("once", false, "exit after the files are written for the first time, e.g. in an init container")
+	timeout := fs.Duration("timeout", time.Minute, "max time to wait for the first write with -once")
+	_ = fs.Parse(args)
+
+	if strings.TrimSpace(*sel) == "" || *specFile == "" || *dir == "" {
+		return errors.New("-sel, -spec and -dir are required")
+	}
+	spec, err := loadSyncSpec(*specFile)
+	if err != nil {
+		return err
+	}
+	var s, o configapi.Selectors
+	if err := s.Fill(*sel); err != nil {
+		return err
+	}
+	if err := o.Fill(*optSel); err != nil {
+		return err
+	}
+
+	var secrets *secretDecrypter
+	if *secretAddrs != "" {
+		clientOpt := &generalclient.ClientOptions{}
+		for _, v := range strings.Split(*secretAddrs, ",") {
+			clientOpt.Secret.AddrList = append(clientOpt.Secret.AddrList, strings.TrimSpace(v))
+		}
+		clientOpt.Secret.Token = *secretToken
+		secretClient, err := generalclient.NewGeneralClient(clientOpt)
+		if err != nil {
+			return err
+		}
+		secrets = newSecretDecrypter(func(name string) (cipher.Block, error) {
+			r, err := secretClient.GetKeyByName(name)
+			if err != nil {
+				return nil, err
+			}
+			return r.AesCipher()
+		})
+	}
+	hook := &reloadHook{pid: *reloadPid, pidFile: *reloadPidFile, url: *reloadUrl}
+	if hook.signal, err = parseSignal(*reloadSignal); err != nil {
+		return err
+	}
+
+	var serverList []string
+	for _, v := range strings.Split(*servers, ",") {
+		serverList = append(serverList, strings.TrimSuffix(strings.TrimSpace(v), "/"))
+	}
+	client := configclient.NewClient(serverList, configclient.ClientOptions{
+		OverrideSelectors:         &s,
+		OverrideOptionalSelectors: &o,
+		Auth:                      *token,
+		Hierarchical:              *hierarchical,
+	})
+	agent := newSyncAgent(spec, newAtomicWriter(*dir), secrets, hook, *debounce)
+	agent.Register(client)
+	if err := client.StartClient(); err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.StopClient()
+	}()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if *once {
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	log.Printf("synchronizing %d files to %s\n", len(spec.Files), *dir)
+	err = agent.Run(ctx, *once)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
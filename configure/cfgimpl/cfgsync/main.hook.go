@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// reloadHook notifies the application after the files are updated, by a signal to the process and/or a request to
+// the http endpoint
+type reloadHook struct {
+	// pid is the process to signal, pidFile is read on each reload instead if set since the process may restart
+	pid     int
+	pidFile string
+	signal  os.Signal
+	// url is requested with POST, 2xx is expected
+	url    string
+	client *http.Client
+}
+
+// parseSignal parses the signal name, e.g. HUP or SIGHUP, or the signal number for the others, e.g. 10 for SIGUSR1
+// on linux
+func parseSignal(name string) (os.Signal, error) {
+	switch strings.TrimPrefix(strings.ToUpper(name), "SIG") {
+	case "HUP":
+		return syscall.SIGHUP, nil
+	case "INT":
+		return syscall.SIGINT, nil
+	case "QUIT":
+		return syscall.SIGQUIT, nil
+	case "TERM":
+		return syscall.SIGTERM, nil
+	}
+	n, err := strconv.Atoi(name)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("unknown signal: %s", name)
+	}
+	return syscall.Signal(n), nil
+}
+
+func (h *reloadHook) Enabled() bool {
+	return h.pid > 0 || h.pidFile != "" || h.url != ""
+}
+
+// Run runs all configured actions and returns the joined errors
+func (h *reloadHook) Run() error {
+	var errs []error
+	if h.pid > 0 || h.pidFile != "" {
+		errs = append(errs, h.signalProcess())
+	}
+	if h.url != "" {
+		errs = append(errs, h.request())
+	}
+	return errors.Join(errs...)
+}
+
+func (h *reloadHook) signalProcess() error {
+	pid := h.pid
+	if h.pidFile != "" {
+		data, err := os.ReadFile(h.pidFile)
+		if err != nil {
+			return err
+		}
+		if pid, err = strconv.Atoi(strings.TrimSpace(string(data))); err != nil {
+			return fmt.Errorf("invalid pid file %s: %w", h.pidFile, err)
+		}
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(h.signal)
+}
+
+func (h *reloadHook) request() error {
+	client := h.client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Post(h.url, "", nil)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("reload endpoint responded with status code %d", resp.StatusCode)
+	}
+	return nil
+}
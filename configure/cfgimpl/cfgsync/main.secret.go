@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrNoSecretServer    = errors.New("no secret server configured for the encrypted value")
+	ErrInvalidCiphertext = errors.New("invalid encrypted value")
+)
+
+// secretDecrypter decrypts the values of the secret files. The value is the standard base64 encoded AES-GCM nonce
+// followed by the ciphertext, encrypted by the AES key of the name on the secret server.
+type secretDecrypter struct {
+	// keys fetches the AES key of the name, see generalclient.GetKeyResult.AesCipher
+	keys func(name string) (cipher.Block, error)
+
+	lock  sync.Mutex
+	cache map[string]cipher.AEAD
+}
+
+func newSecretDecrypter(keys func(name string) (cipher.Block, error)) *secretDecrypter {
+	return &secretDecrypter{
+		keys:  keys,
+		cache: map[string]cipher.AEAD{},
+	}
+}
+
+// Decrypt decrypts the value by the cached key, and fetches the key again once if failed since it may be rotated
+func (s *secretDecrypter) Decrypt(name string, value []byte) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(string(value))
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	for _, refresh := range []bool{false, true} {
+		var aead cipher.AEAD
+		aead, err = s.aead(name, refresh)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < aead.NonceSize() {
+			return nil, ErrInvalidCiphertext
+		}
+		var plaintext []byte
+		plaintext, err = aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+		if err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, err
+}
+
+func (s *secretDecrypter) aead(name string, refresh bool) (cipher.AEAD, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if aead, ok := s.cache[name]; ok && !refresh {
+		return aead, nil
+	}
+	block, err := s.keys(name)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	s.cache[name] = aead
+	return aead, nil
+}
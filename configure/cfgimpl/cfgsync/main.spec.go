@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultFileMode   = 0644
+	defaultSecretMode = 0600
+)
+
+var (
+	ErrEmptySpec     = errors.New("no file declared")
+	ErrInvalidPath   = errors.New("path should be a file name not starting with '.'")
+	ErrInvalidMode   = errors.New("mode should be octal permission bits, e.g. 0640")
+	ErrDuplicatePath = errors.New("duplicate path")
+	ErrDuplicateKey  = errors.New("duplicate group and key")
+)
+
+// SyncSpec declares the configurations synchronized to the files, loaded from the json file of -spec
+type SyncSpec struct {
+	Files []FileSpec `json:"files"`
+}
+
+type FileSpec struct {
+	Group string `json:"group"`
+	Key   string `json:"key"`
+	// Path is the file name in the target directory
+	Path string `json:"path"`
+	// Mode is the octal permission of the file, 0644 by default or 0600 for the secrets
+	Mode string `json:"mode,omitempty"`
+	// SecretKey is the name of the AES key on the secret server to decrypt the value, empty if the value is plain
+	SecretKey string `json:"secret_key,omitempty"`
+}
+
+// FileMode returns the permission of the file, Verify should be called before
+func (f *FileSpec) FileMode() os.FileMode {
+	if f.Mode == "" {
+		if f.SecretKey != "" {
+			return defaultSecretMode
+		}
+		return defaultFileMode
+	}
+	mode, _ := strconv.ParseUint(f.Mode, 8, 32)
+	return os.FileMode(mode)
+}
+
+func (s *SyncSpec) Verify() error {
+	if len(s.Files) == 0 {
+		return ErrEmptySpec
+	}
+	paths := map[string]struct{}{}
+	keys := map[string]struct{}{}
+	for _, f := range s.Files {
+		if f.Group == "" || f.Key == "" {
+			return fmt.Errorf("empty group or key of file: %s", f.Path)
+		}
+		// the names starting with '.' are reserved for the data directories
+		if f.Path == "" || filepath.Base(f.Path) != f.Path || strings.HasPrefix(f.Path, ".") {
+			return fmt.Errorf("%w: %s", ErrInvalidPath, f.Path)
+		}
+		if f.Mode != "" {
+			if mode, err := strconv.ParseUint(f.Mode, 8, 32); err != nil || mode > 0777 {
+				return fmt.Errorf("%w: %s", ErrInvalidMode, f.Mode)
+			}
+		}
+		if _, ok := paths[f.Path]; ok {
+			return fmt.Errorf("%w: %s", ErrDuplicatePath, f.Path)
+		}
+		paths[f.Path] = struct{}{}
+		if _, ok := keys[f.Group+"/"+f.Key]; ok {
+			return fmt.Errorf("%w: %s/%s", ErrDuplicateKey, f.Group, f.Key)
+		}
+		keys[f.Group+"/"+f.Key] = struct{}{}
+	}
+	return nil
+}
+
+func loadSyncSpec(file string) (*SyncSpec, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	spec := new(SyncSpec)
+	if err := json.Unmarshal(data, spec); err != nil {
+		return nil, err
+	}
+	if err := spec.Verify(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// dataDirName is the symlink to the directory of the current files, the same layout as the volumes of the
+	// Kubernetes ConfigMaps and Secrets
+	dataDirName    = "..data"
+	newDataDirName = "..data_tmp"
+)
+
+type fileContent struct {
+	data []byte
+	mode os.FileMode
+}
+
+// atomicWriter writes the files to dir, where each file is a symlink to ..data/<name> and ..data is a symlink to a
+// timestamped directory. All files are written to a new directory and ..data is switched to it by renaming, so the
+// readers always see a complete set of files, either all old or all new.
+type atomicWriter struct {
+	dir string
+}
+
+func newAtomicWriter(dir string) *atomicWriter {
+	return &atomicWriter{dir: dir}
+}
+
+func (w *atomicWriter) Write(files map[string]fileContent) error {
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return err
+	}
+	dataLink := filepath.Join(w.dir, dataDirName)
+	oldDataDir, err := os.Readlink(dataLink)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	// write the files to a new directory
+	newDataDir, err := os.MkdirTemp(w.dir, time.Now().Format("..2006_01_02_15_04_05."))
+	if err != nil {
+		return err
+	}
+	if err := w.writeDataDir(newDataDir, files); err != nil {
+		_ = os.RemoveAll(newDataDir)
+		return err
+	}
+
+	// switch ..data to the new directory
+	tmpLink := filepath.Join(w.dir, newDataDirName)
+	if err := os.Remove(tmpLink); err != nil && !errors.Is(err, os.ErrNotExist) {
+		_ = os.RemoveAll(newDataDir)
+		return err
+	}
+	if err := os.Symlink(filepath.Base(newDataDir), tmpLink); err != nil {
+		_ = os.RemoveAll(newDataDir)
+		return err
+	}
+	if err := os.Rename(tmpLink, dataLink); err != nil {
+		_ = os.Remove(tmpLink)
+		_ = os.RemoveAll(newDataDir)
+		return err
+	}
+
+	// link the files to ..data and remove the ones no longer declared
+	for name := range files {
+		if err := w.linkFile(name); err != nil {
+			return err
+		}
+	}
+	if oldDataDir != "" {
+		w.removeStaleLinks(oldDataDir, files)
+		if err := os.RemoveAll(filepath.Join(w.dir, oldDataDir)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *atomicWriter) writeDataDir(dir string, files map[string]fileContent) error {
+	// MkdirTemp creates the directory with 0700, the files are readable by the other users depending on their modes
+	if err := os.Chmod(dir, 0755); err != nil {
+		return err
+	}
+	for name, f := range files {
+		file, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, f.mode)
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(f.data)
+		if err == nil {
+			err = file.Sync()
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		// the mode is masked by umask while creating
+		if err := os.Chmod(filepath.Join(dir, name), f.mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkFile creates the symlink <name> => ..data/<name> if not exist, replacing by renaming so the file never
+// disappears for the readers
+func (w *atomicWriter) linkFile(name string) error {
+	link := filepath.Join(w.dir, name)
+	target := filepath.Join(dataDirName, name)
+	if cur, err := os.Readlink(link); err == nil && cur == target {
+		return nil
+	}
+	tmpLink := filepath.Join(w.dir, ".."+name+".tmp")
+	_ = os.Remove(tmpLink)
+	if err := os.Symlink(target, tmpLink); err != nil {
+		return err
+	}
+	return os.Rename(tmpLink, link)
+}
+
+// removeStaleLinks removes the symlinks of the files in the old data directory which are not written this time
+func (w *atomicWriter) removeStaleLinks(oldDataDir string, files map[string]fileContent) {
+	entries, err := os.ReadDir(filepath.Join(w.dir, oldDataDir))
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if _, ok := files[e.Name()]; ok || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		link := filepath.Join(w.dir, e.Name())
+		if cur, err := os.Readlink(link); err == nil && cur == filepath.Join(dataDirName, e.Name()) {
+			_ = os.Remove(link)
+		}
+	}
+}
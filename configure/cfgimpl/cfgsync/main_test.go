@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+	"github.com/meidoworks/nekoq-component/configure/configclient"
+)
+
+// fakeRetrievingServer serves the long polling api used by configclient.Client from the memory
+type fakeRetrievingServer struct {
+	lock sync.Mutex
+	// group/key => configuration
+	data map[string]*configapi.Configuration
+}
+
+func (f *fakeRetrievingServer) put(group, key, version string, value []byte) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.data[group+"/"+key] = &configapi.Configuration{Group: group, Key: key, Version: version, Value: value}
+}
+
+func (f *fakeRetrievingServer) updates(req *configapi.AcquireConfigurationReq) *configapi.AcquireConfigurationRes {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	res := &configapi.AcquireConfigurationRes{}
+	for _, v := range req.Requested {
+		if cfg := f.data[v.Group+"/"+v.Key]; cfg != nil && (cfg.Version != v.Version || v.Deleted) {
+			res.Requested = append(res.Requested, *cfg)
+		}
+	}
+	return res
+}
+
+func (f *fakeRetrievingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+	req := new(configapi.AcquireConfigurationReq)
+	if err := cbor.Unmarshal(data, req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for deadline := time.Now().Add(200 * time.Millisecond); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if res := f.updates(req); len(res.Requested) > 0 {
+			data, _ := cbor.Marshal(res)
+			w.Header().Set("Content-Type", "application/cbor")
+			_, _ = w.Write(data)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
+
+func encryptSecret(t *testing.T, key, plaintext []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, _ = rand.Read(nonce)
+	return []byte(base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, nil)))
+}
+
+func readFile(t *testing.T, dir, name string) (string, os.FileMode) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", 0
+	}
+	info, err := os.Stat(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), info.Mode().Perm()
+}
+
+func TestSyncSpec_Verify(t *testing.T) {
+	valid := FileSpec{Group: "app", Key: "db", Path: "db.yaml"}
+	for _, c := range []struct {
+		files []FileSpec
+		err   error
+	}{
+		{nil, ErrEmptySpec},
+		{[]FileSpec{{Group: "app", Key: "db", Path: "../db.yaml"}}, ErrInvalidPath},
+		{[]FileSpec{{Group: "app", Key: "db", Path: "..data"}}, ErrInvalidPath},
+		{[]FileSpec{{Group: "app", Key: "db", Path: "db.yaml", Mode: "0999"}}, ErrInvalidMode},
+		{[]FileSpec{valid, {Group: "app", Key: "url", Path: "db.yaml"}}, ErrDuplicatePath},
+		{[]FileSpec{valid, {Group: "app", Key: "db", Path: "db2.yaml"}}, ErrDuplicateKey},
+	} {
+		spec := &SyncSpec{Files: c.files}
+		if err := spec.Verify(); !errors.Is(err, c.err) {
+			t.Fatal("unexpected error:", c.files, err)
+		}
+	}
+	secret := FileSpec{Group: "app", Key: "pass", Path: "pass", SecretKey: "db"}
+	if valid.FileMode() != 0644 || secret.FileMode() != 0600 || (&FileSpec{Mode: "0640"}).FileMode() != 0640 {
+		t.Fatal("unexpected file modes")
+	}
+}
+
+func TestAtomicWriter(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "conf")
+	w := newAtomicWriter(dir)
+	if err := w.Write(map[string]fileContent{
+		"a.yaml": {data: []byte("a1"), mode: 0644},
+		"b.pem":  {data: []byte("b1"), mode: 0600},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if v, mode := readFile(t, dir, "a.yaml"); v != "a1" || mode != 0644 {
+		t.Fatal("unexpected file:", v, mode)
+	}
+	if v, mode := readFile(t, dir, "b.pem"); v != "b1" || mode != 0600 {
+		t.Fatal("unexpected file:", v, mode)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "a.yaml")); err != nil || target != filepath.Join(dataDirName, "a.yaml") {
+		t.Fatal("file should be linked to ..data:", target, err)
+	}
+	oldDataDir, _ := os.Readlink(filepath.Join(dir, dataDirName))
+
+	// b.pem is no longer declared
+	if err := w.Write(map[string]fileContent{"a.yaml": {data: []byte("a2"), mode: 0640}}); err != nil {
+		t.Fatal(err)
+	}
+	if v, mode := readFile(t, dir, "a.yaml"); v != "a2" || mode != 0640 {
+		t.Fatal("unexpected file:", v, mode)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "b.pem")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("stale link should be removed:", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, oldDataDir)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("old data directory should be removed:", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Fatal("unexpected entries of the directory:", entries)
+	}
+}
+
+func TestSecretDecrypter(t *testing.T) {
+	key1, key2 := make([]byte, 16), make([]byte, 16)
+	_, _ = rand.Read(key1)
+	_, _ = rand.Read(key2)
+	var current atomic.Value
+	current.Store(key1)
+	var fetched atomic.Int32
+	d := newSecretDecrypter(func(name string) (cipher.Block, error) {
+		fetched.Add(1)
+		return aes.NewCipher(current.Load().([]byte))
+	})
+	if v, err := d.Decrypt("db", encryptSecret(t, key1, []byte("p1"))); err != nil || string(v) != "p1" {
+		t.Fatal("unexpected decryption:", string(v), err)
+	}
+	// the rotated key is fetched again
+	current.Store(key2)
+	if v, err := d.Decrypt("db", encryptSecret(t, key2, []byte("p2"))); err != nil || string(v) != "p2" || fetched.Load() != 2 {
+		t.Fatal("unexpected decryption after rotated:", string(v), err, fetched.Load())
+	}
+	if _, err := d.Decrypt("db", []byte("not base64!")); err != ErrInvalidCiphertext {
+		t.Fatal("invalid value should be rejected:", err)
+	}
+}
+
+func TestReloadHook(t *testing.T) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+	var requested atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			requested.Add(1)
+		}
+	}))
+	defer srv.Close()
+
+	pidFile := filepath.Join(t.TempDir(), "app.pid")
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sig, err := parseSignal("SIGHUP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook := &reloadHook{pidFile: pidFile, signal: sig, url: srv.URL}
+	if err := hook.Run(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sigCh:
+	case <-time.After(time.Second):
+		t.Fatal("signal should be received")
+	}
+	if requested.Load() != 1 {
+		t.Fatal("endpoint should be requested")
+	}
+	if _, err := parseSignal("USR3"); err == nil {
+		t.Fatal("unknown signal should be rejected")
+	}
+}
+
+func TestSyncAgent(t *testing.T) {
+	fake := &fakeRetrievingServer{data: map[string]*configapi.Configuration{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	var reloaded atomic.Int32
+	hookSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reloaded.Add(1)
+	}))
+	defer hookSrv.Close()
+
+	key := make([]byte, 16)
+	_, _ = rand.Read(key)
+	spec := &SyncSpec{Files: []FileSpec{
+		{Group: "app", Key: "db", Path: "db.yaml"},
+		{Group: "app", Key: "pass", Path: "db.pass", SecretKey: "db"},
+	}}
+	if err := spec.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	var keyUnavailable atomic.Bool
+	secrets := newSecretDecrypter(func(name string) (cipher.Block, error) {
+		if keyUnavailable.Load() {
+			return nil, errors.New("secret server unavailable")
+		}
+		return aes.NewCipher(key)
+	})
+	agent := newSyncAgent(spec, newAtomicWriter(dir), secrets, &reloadHook{url: hookSrv.URL}, 50*time.Millisecond)
+	agent.decryptRetry = 50 * time.Millisecond
+	client := configclient.NewClient([]string{srv.URL}, configclient.ClientOptions{SelectorDatacenter: "dc1"})
+	agent.Register(client)
+	if err := client.StartClient(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.StopClient()
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = agent.Run(ctx, false)
+	}()
+
+	waitFile := func(name, expected string) os.FileMode {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if v, mode := readFile(t, dir, name); v == expected {
+				return mode
+			}
+		}
+		t.Fatal("file not synchronized:", name, expected)
+		return 0
+	}
+
+	// not written until all declared configurations are received
+	fake.put("app", "db", "v1", []byte("host: db1"))
+	time.Sleep(300 * time.Millisecond)
+	if _, err := os.Lstat(filepath.Join(dir, "db.yaml")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("files should not be written partially:", err)
+	}
+	fake.put("app", "pass", "v1", encryptSecret(t, key, []byte("secret1")))
+	if mode := waitFile("db.pass", "secret1"); mode != 0600 {
+		t.Fatal("secret file should be private:", mode)
+	}
+	waitFile("db.yaml", "host: db1")
+	if reloaded.Load() != 0 {
+		t.Fatal("hook should not run on the first write")
+	}
+
+	fake.put("app", "db", "v2", []byte("host: db2"))
+	waitFile("db.yaml", "host: db2")
+	for deadline := time.Now().Add(time.Second); reloaded.Load() != 1; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("hook should run after the files are updated")
+		}
+	}
+
+	// the update failed to decrypt is retried by the agent rather than delivered again by the client
+	key2 := make([]byte, 16)
+	_, _ = rand.Read(key2)
+	keyUnavailable.Store(true)
+	fake.put("app", "pass", "v2", encryptSecret(t, key2, []byte("secret2")))
+	time.Sleep(300 * time.Millisecond)
+	if v, _ := readFile(t, dir, "db.pass"); v != "secret1" {
+		t.Fatal("previous file should be kept:", v)
+	}
+	key = key2
+	keyUnavailable.Store(false)
+	waitFile("db.pass", "secret2")
+}
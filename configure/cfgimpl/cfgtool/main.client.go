@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+const adminPageSize = 1000
+
+var (
+	ErrSelectorsNotFound     = errors.New("selector combination not found")
+	ErrConfigurationNotFound = errors.New("configuration not found")
+	ErrVersionConflict       = errors.New("version not increased")
+)
+
+// apiClient accesses the write(including admin) api of the configure server
+type apiClient struct {
+	writeAddr string
+	// token is the bearer token of the tenant, optional
+	token  string
+	client *http.Client
+}
+
+func newApiClient(writeAddr string) *apiClient {
+	return &apiClient{
+		writeAddr: strings.TrimSuffix(writeAddr, "/"),
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+func (a *apiClient) do(method, url string, headers map[string]string, body []byte) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Accept", "application/cbor")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/cbor")
+	}
+	for k, v := range headers {
+		if v != "" {
+			req.Header.Set(k, v)
+		}
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, data, nil
+}
+
+func selectorsHeaders(sel, optSel string) map[string]string {
+	return map[string]string{
+		"X-Configuration-Sel":     sel,
+		"X-Configuration-Opt-Sel": optSel,
+	}
+}
+
+// ListGroups returns all groups under the exact selector combination
+func (a *apiClient) ListGroups(sel, optSel string) ([]configapi.AdminGroupItem, error) {
+	var items []configapi.AdminGroupItem
+	for offset := 0; ; offset += adminPageSize {
+		u := fmt.Sprintf("%s/admin/groups?offset=%d&limit=%d", a.writeAddr, offset, adminPageSize)
+		status, data, err := a.do(http.MethodGet, u, selectorsHeaders(sel, optSel), nil)
+		if err != nil {
+			return nil, err
+		}
+		if status == http.StatusNotFound {
+			return nil, ErrSelectorsNotFound
+		} else if status != http.StatusOK {
+			return nil, fmt.Errorf("list groups: unexpected status %d", status)
+		}
+		res := new(configapi.AdminGroupsRes)
+		if err := cbor.Unmarshal(data, res); err != nil {
+			return nil, err
+		}
+		items = append(items, res.Items...)
+		if len(res.Items) == 0 || len(items) >= res.Total {
+			return items, nil
+		}
+	}
+}
+
+// ListKeys returns all keys of the group under the exact selector combination
+func (a *apiClient) ListKeys(sel, optSel, group string) ([]configapi.AdminKeyItem, error) {
+	var items []configapi.AdminKeyItem
+	for offset := 0; ; offset += adminPageSize {
+		u := fmt.Sprintf("%s/admin/groups/%s/keys?offset=%d&limit=%d", a.writeAddr, url.PathEscape(group), offset, adminPageSize)
+		status, data, err := a.do(http.MethodGet, u, selectorsHeaders(sel, optSel), nil)
+		if err != nil {
+			return nil, err
+		}
+		if status == http.StatusNotFound {
+			return nil, ErrSelectorsNotFound
+		} else if status != http.StatusOK {
+			return nil, fmt.Errorf("list keys: unexpected status %d", status)
+		}
+		res := new(configapi.AdminKeysRes)
+		if err := cbor.Unmarshal(data, res); err != nil {
+			return nil, err
+		}
+		items = append(items, res.Items...)
+		if len(res.Items) == 0 || len(items) >= res.Total {
+			return items, nil
+		}
+	}
+}
+
+// GetConfiguration returns the configuration as published via the admin api, so that the templates are exported and
+// compared without rendering
+func (a *apiClient) GetConfiguration(group, key, sel, optSel string) (*configapi.Configuration, error) {
+	u := fmt.Sprintf("%s/admin/groups/%s/keys/%s", a.writeAddr, url.PathEscape(group), url.PathEscape(key))
+	status, data, err := a.do(http.MethodGet, u, selectorsHeaders(sel, optSel), nil)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, ErrConfigurationNotFound
+	} else if status != http.StatusOK {
+		return nil, fmt.Errorf("get configuration: unexpected status %d", status)
+	}
+	res := new(configapi.GetConfigurationRes)
+	if err := cbor.Unmarshal(data, res); err != nil {
+		return nil, err
+	}
+	return &res.Configuration, nil
+}
+
+func (a *apiClient) Publish(req *configapi.PublishReq) error {
+	return a.publish("/configure", req)
+}
+
+// PublishBatch publishes the configurations atomically
+func (a *apiClient) PublishBatch(req *configapi.PublishBatchReq) error {
+	return a.publish("/configure/batch", req)
+}
+
+func (a *apiClient) publish(path string, req any) error {
+	body, err := cbor.Marshal(req)
+	if err != nil {
+		return err
+	}
+	status, data, err := a.do(http.MethodPost, a.writeAddr+path, nil, body)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case http.StatusOK:
+		return nil
+	case http.StatusConflict:
+		return ErrVersionConflict
+	case http.StatusBadRequest:
+		res := new(configapi.PublishRes)
+		if err := cbor.Unmarshal(data, res); err != nil {
+			return fmt.Errorf("publish: invalid configuration")
+		}
+		var msgs []string
+		for _, e := range res.Errors {
+			msgs = append(msgs, e.Field+": "+e.Message)
+		}
+		return fmt.Errorf("publish: invalid configuration: %s", strings.Join(msgs, "; "))
+	default:
+		return errors.New("publish: unexpected status " + strconv.Itoa(status))
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDiffLines limits the lines of values to diff since the lcs costs O(n*m)
+const maxDiffLines = 2000
+
+// diffValues returns the line based diff of the values with '-' and '+' prefixes.
+// Binary or large values are summarized by size.
+func diffValues(oldValue, newValue []byte) string {
+	if !isText(oldValue) || !isText(newValue) {
+		return fmt.Sprintf("    binary value changed: %d bytes -> %d bytes\n", len(oldValue), len(newValue))
+	}
+	a := strings.Split(string(oldValue), "\n")
+	b := strings.Split(string(newValue), "\n")
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		return fmt.Sprintf("    value changed: %d lines -> %d lines\n", len(a), len(b))
+	}
+
+	// lcs table of suffixes
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	buf := new(strings.Builder)
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			buf.WriteString("    - " + a[i] + "\n")
+			i++
+		default:
+			buf.WriteString("    + " + b[j] + "\n")
+			j++
+		}
+	}
+	return buf.String()
+}
+
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) == -1
+}
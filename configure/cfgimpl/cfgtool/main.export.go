@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+const (
+	exportFormatVersion = 1
+
+	FileFormatJson = "json"
+	FileFormatCbor = "cbor"
+)
+
+// ExportFile is the portable file of exported configurations
+type ExportFile struct {
+	FormatVersion int `json:"format_version" cbor:"format_version,"`
+	// ExportedAt is the unix timestamp in second
+	ExportedAt int64 `json:"exported_at" cbor:"exported_at,"`
+	// Selectors and OptionalSelectors are the exported selector combination
+	Selectors         string         `json:"selectors" cbor:"selectors,"`
+	OptionalSelectors string         `json:"opt_selectors" cbor:"opt_selectors,"`
+	Items             []ExportedItem `json:"items" cbor:"items,"`
+	// Signature is the hex encoded HMAC-SHA256 of the file with the key shared by export and import, empty if unsigned.
+	// It covers all the fields above so that the modified files are rejected while importing.
+	Signature string `json:"signature,omitempty" cbor:"signature,omitempty"`
+}
+
+type ExportedItem struct {
+	Group   string `json:"group" cbor:"group,"`
+	Key     string `json:"key" cbor:"key,"`
+	Version string `json:"version" cbor:"version,"`
+	// Value is base64 encoded in json format
+	Value []byte `json:"value" cbor:"value,"`
+	// Checksum is Configuration.Signature, the sha256 of the value, and is verified while importing. It only detects
+	// the corruption of the item rather than the modification, which is detected by ExportFile.Signature.
+	Checksum  string `json:"checksum" cbor:"checksum,"`
+	Timestamp int64  `json:"timestamp" cbor:"timestamp,"`
+	// Template is the same to Configuration.Template
+	Template bool `json:"template,omitempty" cbor:"template,omitempty"`
+}
+
+func (e *ExportedItem) toConfiguration(sel, optSel string) (*configapi.Configuration, error) {
+	cfg := &configapi.Configuration{
+		Group:     e.Group,
+		Key:       e.Key,
+		Version:   e.Version,
+		Value:     e.Value,
+		Signature: e.Checksum,
+		Timestamp: e.Timestamp,
+		Template:  e.Template,
+	}
+	if err := cfg.Selectors.Fill(sel); err != nil {
+		return nil, err
+	}
+	if err := cfg.OptionalSelectors.Fill(optSel); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// computeSignature returns the HMAC of the file encoded in cbor without the signature
+func (f *ExportFile) computeSignature(key []byte) (string, error) {
+	unsigned := *f
+	unsigned.Signature = ""
+	data, err := cbor.Marshal(&unsigned)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Sign sets the signature of the file with the key
+func (f *ExportFile) Sign(key []byte) error {
+	signature, err := f.computeSignature(key)
+	if err != nil {
+		return err
+	}
+	f.Signature = signature
+	return nil
+}
+
+// Verify checks the file version, the signature with the key and the checksums of all items.
+// The signed file requires the key, and the unsigned file is rejected if the key is provided.
+func (f *ExportFile) Verify(key []byte) error {
+	if f.FormatVersion != exportFormatVersion {
+		return fmt.Errorf("unsupported file format version: %d", f.FormatVersion)
+	}
+	switch {
+	case len(key) > 0 && f.Signature == "":
+		return errors.New("file is not signed")
+	case len(key) == 0 && f.Signature != "":
+		return errors.New("file is signed, the key is required")
+	case len(key) > 0:
+		expected, err := f.computeSignature(key)
+		if err != nil {
+			return err
+		}
+		if !hmac.Equal([]byte(expected), []byte(f.Signature)) {
+			return errors.New("signature mismatch")
+		}
+	}
+	for _, item := range f.Items {
+		cfg, err := item.toConfiguration(f.Selectors, f.OptionalSelectors)
+		if err != nil {
+			return err
+		}
+		if !cfg.ValidateSignature() {
+			return fmt.Errorf("checksum mismatch: %s/%s", item.Group, item.Key)
+		}
+	}
+	return nil
+}
+
+func encodeExportFile(f *ExportFile, format string) ([]byte, error) {
+	switch format {
+	case FileFormatJson:
+		return json.MarshalIndent(f, "", "  ")
+	case FileFormatCbor:
+		return cbor.Marshal(f)
+	default:
+		return nil, errors.New("unknown file format: " + format)
+	}
+}
+
+// decodeExportFile detects the format by the content: json starts with '{', otherwise cbor
+func decodeExportFile(data []byte) (*ExportFile, error) {
+	f := new(ExportFile)
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, f); err != nil {
+			return nil, err
+		}
+	} else if err := cbor.Unmarshal(data, f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	writeAddr := fs.String("write-addr", "http://127.0.0.1:8081", "address of the write api serving admin apis")
+	token := fs.String("token", "", "bearer token of the tenant, required if tenants are declared on the server")
+	sel := fs.String("sel", "", "selectors to export, e.g. env=staging,dc=dc1 (required)")
+	optSel := fs.String("opt-sel", "", "optional selectors to export")
+	group := fs.String("group", "", "export the group only, all groups if empty")
+	format := fs.String("format", FileFormatJson, "file format: json or cbor")
+	out := fs.String("out", "-", "output file, '-' for stdout")
+	key := fs.String("key", "", "key of the HMAC signature of the file verified while importing, unsigned if empty")
+	_ = fs.Parse(args)
+
+	if strings.TrimSpace(*sel) == "" {
+		return errors.New("-sel is required")
+	}
+	// normalize selectors
+	var s, o configapi.Selectors
+	if err := s.Fill(*sel); err != nil {
+		return err
+	}
+	if err := o.Fill(*optSel); err != nil {
+		return err
+	}
+
+	client := newApiClient(*writeAddr)
+	client.token = *token
+	f, err := exportConfigurations(client, configapi.SelectorsHelperCacheValue(&s), configapi.SelectorsHelperCacheValue(&o), *group)
+	if err != nil {
+		return err
+	}
+	if *key != "" {
+		if err := f.Sign([]byte(*key)); err != nil {
+			return err
+		}
+	}
+	data, err := encodeExportFile(f, *format)
+	if err != nil {
+		return err
+	}
+	if *out == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		return err
+	}
+	log.Printf("exported %d configurations to %s\n", len(f.Items), *out)
+	return nil
+}
+
+func exportConfigurations(client *apiClient, sel, optSel, group string) (*ExportFile, error) {
+	var groups []string
+	if group != "" {
+		groups = []string{group}
+	} else {
+		items, err := client.ListGroups(sel, optSel)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			groups = append(groups, item.Group)
+		}
+	}
+
+	f := &ExportFile{
+		FormatVersion:     exportFormatVersion,
+		ExportedAt:        time.Now().Unix(),
+		Selectors:         sel,
+		OptionalSelectors: optSel,
+	}
+	for _, g := range groups {
+		keys, err := client.ListKeys(sel, optSel, g)
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %w", g, err)
+		}
+		for _, k := range keys {
+			cfg, err := client.GetConfiguration(g, k.Key, sel, optSel)
+			if err != nil {
+				return nil, fmt.Errorf("configuration %s/%s: %w", g, k.Key, err)
+			}
+			if !cfg.ValidateSignature() {
+				return nil, fmt.Errorf("configuration %s/%s: checksum mismatch", g, k.Key)
+			}
+			f.Items = append(f.Items, ExportedItem{
+				Group:     cfg.Group,
+				Key:       cfg.Key,
+				Version:   cfg.Version,
+				Value:     cfg.Value,
+				Checksum:  cfg.Signature,
+				Timestamp: cfg.Timestamp,
+				Template:  cfg.Template,
+			})
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+const (
+	VersionModeKeep     = "keep"
+	VersionModeOverride = "override"
+)
+
+type ImportAction string
+
+const (
+	ImportActionCreate    ImportAction = "create"
+	ImportActionUpdate    ImportAction = "update"
+	ImportActionUnchanged ImportAction = "unchanged"
+	// ImportActionConflict means the value differs but the version is the same to the target
+	ImportActionConflict ImportAction = "conflict"
+)
+
+// selectorRewrite replaces the selector pair From with To, e.g. env=staging to env=prod
+type selectorRewrite struct {
+	FromKey, FromValue string
+	ToKey, ToValue     string
+}
+
+// parseSelectorRewrite parses the rule in the format of 'key=value:key=value'
+func parseSelectorRewrite(rule string) (selectorRewrite, error) {
+	from, to, ok := strings.Cut(rule, ":")
+	if !ok {
+		return selectorRewrite{}, errors.New("invalid rewrite rule, 'key=value:key=value' expected: " + rule)
+	}
+	fk, fv, ok1 := strings.Cut(strings.TrimSpace(from), "=")
+	tk, tv, ok2 := strings.Cut(strings.TrimSpace(to), "=")
+	if !ok1 || !ok2 || fk == "" || tk == "" {
+		return selectorRewrite{}, errors.New("invalid rewrite rule, 'key=value:key=value' expected: " + rule)
+	}
+	return selectorRewrite{FromKey: fk, FromValue: fv, ToKey: tk, ToValue: tv}, nil
+}
+
+// rewriteSelectors applies the rules to the selectors string and returns the normalized result
+func rewriteSelectors(sel string, rules []selectorRewrite) (string, error) {
+	s := new(configapi.Selectors)
+	if err := s.Fill(sel); err != nil {
+		return "", err
+	}
+	for _, rule := range rules {
+		if v, ok := s.Data[rule.FromKey]; ok && v == rule.FromValue {
+			delete(s.Data, rule.FromKey)
+			s.Data[rule.ToKey] = rule.ToValue
+		}
+	}
+	return configapi.SelectorsHelperCacheValue(s), nil
+}
+
+type importPlanItem struct {
+	Action  ImportAction
+	Item    ExportedItem
+	Current *configapi.Configuration
+}
+
+type importPlan struct {
+	Selectors         string
+	OptionalSelectors string
+	Items             []importPlanItem
+}
+
+func (p *importPlan) Count(action ImportAction) int {
+	cnt := 0
+	for _, item := range p.Items {
+		if item.Action == action {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// Print writes the plan. The value diff of updated items is included if showDiff is true.
+func (p *importPlan) Print(w io.Writer, showDiff bool) {
+	_, _ = fmt.Fprintf(w, "target selectors: [%s] optional selectors: [%s]\n", p.Selectors, p.OptionalSelectors)
+	for _, item := range p.Items {
+		switch item.Action {
+		case ImportActionCreate:
+			_, _ = fmt.Fprintf(w, "+ %s/%s version=%s\n", item.Item.Group, item.Item.Key, item.Item.Version)
+		case ImportActionUpdate, ImportActionConflict:
+			mark := "~"
+			if item.Action == ImportActionConflict {
+				mark = "!"
+			}
+			_, _ = fmt.Fprintf(w, "%s %s/%s version=%s -> %s\n", mark, item.Item.Group, item.Item.Key, item.Current.Version, item.Item.Version)
+			if showDiff {
+				_, _ = io.WriteString(w, diffValues(item.Current.Value, item.Item.Value))
+			}
+		case ImportActionUnchanged:
+			_, _ = fmt.Fprintf(w, "= %s/%s version=%s\n", item.Item.Group, item.Item.Key, item.Current.Version)
+		}
+	}
+	_, _ = fmt.Fprintf(w, "create: %d, update: %d, unchanged: %d, conflict: %d\n",
+		p.Count(ImportActionCreate), p.Count(ImportActionUpdate), p.Count(ImportActionUnchanged), p.Count(ImportActionConflict))
+}
+
+type importOptions struct {
+	Rewrites    []selectorRewrite
+	VersionMode string
+	Version     string
+	// Force publishes the unchanged items as well
+	Force bool
+}
+
+func buildImportPlan(client *apiClient, f *ExportFile, opt importOptions) (*importPlan, error) {
+	sel, err := rewriteSelectors(f.Selectors, opt.Rewrites)
+	if err != nil {
+		return nil, err
+	}
+	optSel, err := rewriteSelectors(f.OptionalSelectors, opt.Rewrites)
+	if err != nil {
+		return nil, err
+	}
+	plan := &importPlan{
+		Selectors:         sel,
+		OptionalSelectors: optSel,
+	}
+
+	// versions of existing keys per group
+	existing := map[string]map[string]string{}
+	for _, item := range f.Items {
+		if opt.VersionMode == VersionModeOverride {
+			item.Version = opt.Version
+		}
+		versions, ok := existing[item.Group]
+		if !ok {
+			keys, err := client.ListKeys(sel, optSel, item.Group)
+			if err != nil && !errors.Is(err, ErrSelectorsNotFound) {
+				return nil, err
+			}
+			versions = map[string]string{}
+			for _, k := range keys {
+				versions[k.Key] = k.Version
+			}
+			existing[item.Group] = versions
+		}
+
+		planItem := importPlanItem{Item: item, Action: ImportActionCreate}
+		if _, ok := versions[item.Key]; ok {
+			cur, err := client.GetConfiguration(item.Group, item.Key, sel, optSel)
+			if err != nil {
+				return nil, fmt.Errorf("configuration %s/%s: %w", item.Group, item.Key, err)
+			}
+			planItem.Current = cur
+			switch {
+			case cur.Signature == item.Checksum && !opt.Force:
+				planItem.Action = ImportActionUnchanged
+			case cur.Version == item.Version:
+				planItem.Action = ImportActionConflict
+			default:
+				planItem.Action = ImportActionUpdate
+			}
+		}
+		plan.Items = append(plan.Items, planItem)
+	}
+	return plan, nil
+}
+
+// applyImportPlanAtomic publishes all the changed items in one batch
+func applyImportPlanAtomic(client *apiClient, plan *importPlan, operator, description string) error {
+	req := &configapi.PublishBatchReq{
+		Operator:    operator,
+		Description: description,
+	}
+	for _, item := range plan.Items {
+		if item.Action != ImportActionCreate && item.Action != ImportActionUpdate {
+			continue
+		}
+		req.Configurations = append(req.Configurations, configapi.RawConfiguration{
+			Group:    item.Item.Group,
+			Key:      item.Item.Key,
+			Version:  item.Item.Version,
+			Value:    item.Item.Value,
+			Template: item.Item.Template,
+		})
+	}
+	if len(req.Configurations) == 0 {
+		return nil
+	}
+	if err := req.Selectors.Fill(plan.Selectors); err != nil {
+		return err
+	}
+	if err := req.OptionalSelectors.Fill(plan.OptionalSelectors); err != nil {
+		return err
+	}
+	if err := client.PublishBatch(req); err != nil {
+		return err
+	}
+	log.Printf("published %d configurations in one batch\n", len(req.Configurations))
+	return nil
+}
+
+func applyImportPlan(client *apiClient, plan *importPlan, operator, description string) error {
+	var errs []error
+	for _, item := range plan.Items {
+		if item.Action != ImportActionCreate && item.Action != ImportActionUpdate {
+			continue
+		}
+		req := &configapi.PublishReq{
+			Configuration: configapi.RawConfiguration{
+				Group:    item.Item.Group,
+				Key:      item.Item.Key,
+				Version:  item.Item.Version,
+				Value:    item.Item.Value,
+				Template: item.Item.Template,
+			},
+			Operator:    operator,
+			Description: description,
+		}
+		if err := req.Selectors.Fill(plan.Selectors); err != nil {
+			return err
+		}
+		if err := req.OptionalSelectors.Fill(plan.OptionalSelectors); err != nil {
+			return err
+		}
+		if err := client.Publish(req); err != nil {
+			errs = append(errs, fmt.Errorf("%s/%s: %w", item.Item.Group, item.Item.Key, err))
+			continue
+		}
+		log.Printf("published %s/%s version=%s\n", item.Item.Group, item.Item.Key, item.Item.Version)
+	}
+	return errors.Join(errs...)
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	writeAddr := fs.String("write-addr", "http://127.0.0.1:8081", "address of the write api")
+	token := fs.String("token", "", "bearer token of the tenant, required if tenants are declared on the server")
+	in := fs.String("in", "-", "input file in json or cbor format, '-' for stdin")
+	key := fs.String("key", "", "key of the HMAC signature of the file, required if the file is signed")
+	var rewrites multiFlag
+	fs.Var(&rewrites, "rewrite", "rewrite selector pair, e.g. env=staging:env=prod (repeatable)")
+	versionMode := fs.String("version-mode", VersionModeKeep, "version handling: keep(exported versions, rejected if not greater than the target ones) or override(use -version)")
+	version := fs.String("version", "", "version for all configurations when -version-mode=override")
+	dryRun := fs.Bool("dry-run", false, "print the plan and the value diff without publishing")
+	showDiff := fs.Bool("diff", false, "print the value diff of updated configurations")
+	force := fs.Bool("force", false, "publish the configurations even if the values are unchanged")
+	atomic := fs.Bool("atomic", false, "publish all the changed configurations in one batch, at most 100")
+	operator := fs.String("operator", "", "operator recorded in audit records")
+	description := fs.String("description", "", "description recorded in audit records, default 'import from <file>'")
+	_ = fs.Parse(args)
+
+	opt := importOptions{
+		VersionMode: *versionMode,
+		Version:     *version,
+		Force:       *force,
+	}
+	switch opt.VersionMode {
+	case VersionModeKeep:
+	case VersionModeOverride:
+		if opt.Version == "" {
+			return errors.New("-version is required when -version-mode=override")
+		}
+	default:
+		return errors.New("unknown version mode: " + opt.VersionMode)
+	}
+	for _, r := range rewrites {
+		rule, err := parseSelectorRewrite(r)
+		if err != nil {
+			return err
+		}
+		opt.Rewrites = append(opt.Rewrites, rule)
+	}
+
+	var data []byte
+	var err error
+	if *in == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*in)
+	}
+	if err != nil {
+		return err
+	}
+	f, err := decodeExportFile(data)
+	if err != nil {
+		return err
+	}
+	if err := f.Verify([]byte(*key)); err != nil {
+		return err
+	}
+
+	client := newApiClient(*writeAddr)
+	client.token = *token
+	plan, err := buildImportPlan(client, f, opt)
+	if err != nil {
+		return err
+	}
+	plan.Print(os.Stdout, *dryRun || *showDiff)
+	if *dryRun {
+		return nil
+	}
+	if cnt := plan.Count(ImportActionConflict); cnt > 0 {
+		return fmt.Errorf("%d configurations have the same version but different values, use -version-mode=override", cnt)
+	}
+	if *description == "" {
+		*description = "import from " + *in
+	}
+	if *atomic {
+		err = applyImportPlanAtomic(client, plan, *operator, *description)
+	} else {
+		err = applyImportPlan(client, plan, *operator, *description)
+	}
+	if errors.Is(err, ErrVersionConflict) && opt.VersionMode == VersionModeKeep {
+		// the exported versions are not generated again since the comparator of the target server is unknown
+		return fmt.Errorf("%w, the target has equal or newer versions, use -version-mode=override with a greater -version", err)
+	}
+	return err
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-chi/chi/v5"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+// fakeConfigureServer serves the read, admin and publish apis used by the tool from the memory
+type fakeConfigureServer struct {
+	lock sync.Mutex
+	// selectors|optSelectors => group => key => configuration
+	data map[string]map[string]map[string]*configapi.Configuration
+	// publishes and batches count the requests of the single and the batch publish apis
+	publishes, batches int
+}
+
+func (f *fakeConfigureServer) put(cfg *configapi.Configuration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	sel := configapi.SelectorsHelperCacheValue(&cfg.Selectors) + "|" + configapi.SelectorsHelperCacheValue(&cfg.OptionalSelectors)
+	if f.data[sel] == nil {
+		f.data[sel] = map[string]map[string]*configapi.Configuration{}
+	}
+	if f.data[sel][cfg.Group] == nil {
+		f.data[sel][cfg.Group] = map[string]*configapi.Configuration{}
+	}
+	f.data[sel][cfg.Group][cfg.Key] = cfg
+}
+
+// get returns the configuration of the same selectors, group and key
+func (f *fakeConfigureServer) get(cfg *configapi.Configuration) *configapi.Configuration {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	sel := configapi.SelectorsHelperCacheValue(&cfg.Selectors) + "|" + configapi.SelectorsHelperCacheValue(&cfg.OptionalSelectors)
+	return f.data[sel][cfg.Group][cfg.Key]
+}
+
+func (f *fakeConfigureServer) groups(r *http.Request) map[string]map[string]*configapi.Configuration {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.data[r.Header.Get("X-Configuration-Sel")+"|"+r.Header.Get("X-Configuration-Opt-Sel")]
+}
+
+func (f *fakeConfigureServer) handler() http.Handler {
+	r := chi.NewRouter()
+	write := func(w http.ResponseWriter, obj any) {
+		data, _ := cbor.Marshal(obj)
+		_, _ = w.Write(data)
+	}
+	r.Get("/admin/groups", func(w http.ResponseWriter, r *http.Request) {
+		groups := f.groups(r)
+		if groups == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		res := &configapi.AdminGroupsRes{Code: "200", Message: "success", Total: len(groups)}
+		for g, keys := range groups {
+			res.Items = append(res.Items, configapi.AdminGroupItem{Group: g, KeyCount: len(keys)})
+		}
+		write(w, res)
+	})
+	r.Get("/admin/groups/{group}/keys", func(w http.ResponseWriter, r *http.Request) {
+		groups := f.groups(r)
+		if groups == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		keys := groups[chi.URLParam(r, "group")]
+		res := &configapi.AdminKeysRes{Code: "200", Message: "success", Total: len(keys)}
+		for _, cfg := range keys {
+			res.Items = append(res.Items, configapi.AdminKeyItem{Group: cfg.Group, Key: cfg.Key, Version: cfg.Version})
+		}
+		write(w, res)
+	})
+	r.Get("/admin/groups/{group}/keys/{key}", func(w http.ResponseWriter, r *http.Request) {
+		cfg := f.groups(r)[chi.URLParam(r, "group")][chi.URLParam(r, "key")]
+		if cfg == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		write(w, &configapi.GetConfigurationRes{Code: "200", Message: "success", Configuration: *cfg})
+	})
+	r.Post("/configure", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		req := new(configapi.PublishReq)
+		if err := cbor.Unmarshal(data, req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.lock.Lock()
+		f.publishes++
+		f.lock.Unlock()
+		f.put(req.ToConfiguration(1))
+	})
+	r.Post("/configure/batch", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		req := new(configapi.PublishBatchReq)
+		if err := cbor.Unmarshal(data, req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.lock.Lock()
+		f.batches++
+		f.lock.Unlock()
+		cfgs := make([]*configapi.Configuration, 0, len(req.Configurations))
+		for _, item := range req.Items() {
+			cfg := item.ToConfiguration(1)
+			// all or nothing
+			if cur := f.get(cfg); cur != nil && cur.Version >= cfg.Version {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			cfgs = append(cfgs, cfg)
+		}
+		for _, cfg := range cfgs {
+			f.put(cfg)
+		}
+	})
+	return r
+}
+
+func newTestConfiguration(group, key, version, value, sel string) *configapi.Configuration {
+	cfg := &configapi.Configuration{
+		Group:   group,
+		Key:     key,
+		Version: version,
+		Value:   []byte(value),
+	}
+	_ = cfg.Selectors.Fill(sel)
+	cfg.Signature = cfg.GenerateSignature()
+	return cfg
+}
+
+func TestExportImport_Promotion(t *testing.T) {
+	fake := &fakeConfigureServer{data: map[string]map[string]map[string]*configapi.Configuration{}}
+	fake.put(newTestConfiguration("app", "db", "v2", "host=staging\nport=5432", "dc=dc1,env=staging"))
+	fake.put(newTestConfiguration("app", "cache", "v1", "size=10", "dc=dc1,env=staging"))
+	fake.put(newTestConfiguration("web", "port", "v1", "8080", "dc=dc1,env=staging"))
+	fake.put(newTestConfiguration("app", "db", "v1", "host=prod\nport=5432", "dc=dc1,env=prod"))
+	fake.put(newTestConfiguration("app", "cache", "v1", "size=10", "dc=dc1,env=prod"))
+	srv := httptest.NewServer(fake.handler())
+	defer srv.Close()
+	client := newApiClient(srv.URL)
+
+	// export and file roundtrip
+	f, err := exportConfigurations(client, "dc=dc1,env=staging", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Items) != 3 {
+		t.Fatal("unexpected exported items:", len(f.Items))
+	}
+	for _, format := range []string{FileFormatJson, FileFormatCbor} {
+		data, err := encodeExportFile(f, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := decodeExportFile(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.Verify(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tampered := *f
+	tampered.Items = append([]ExportedItem(nil), f.Items...)
+	tampered.Items[0].Value = []byte("tampered")
+	if err := tampered.Verify(nil); err == nil {
+		t.Fatal("tampered file should fail")
+	}
+
+	// signed file
+	key := []byte("promotion-key")
+	signed := *f
+	if err := signed.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{FileFormatJson, FileFormatCbor} {
+		data, err := encodeExportFile(&signed, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := decodeExportFile(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.Verify(key); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Verify([]byte("wrong-key")) == nil || decoded.Verify(nil) == nil {
+			t.Fatal("signed file should require the key")
+		}
+	}
+	if f.Verify(key) == nil {
+		t.Fatal("unsigned file should fail with the key")
+	}
+	// modified value with the recomputed checksum
+	modified := signed
+	modified.Items = append([]ExportedItem(nil), signed.Items...)
+	cfg, err := modified.Items[0].toConfiguration(modified.Selectors, modified.OptionalSelectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Value = []byte("modified")
+	modified.Items[0].Value, modified.Items[0].Checksum = cfg.Value, cfg.GenerateSignature()
+	if err := modified.Verify(key); err == nil {
+		t.Fatal("modified file should fail")
+	}
+
+	// dry run plan
+	rule, err := parseSelectorRewrite("env=staging:env=prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := buildImportPlan(client, f, importOptions{Rewrites: []selectorRewrite{rule}, VersionMode: VersionModeKeep})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Selectors != "dc=dc1,env=prod" {
+		t.Fatal("unexpected rewritten selectors:", plan.Selectors)
+	}
+	if plan.Count(ImportActionCreate) != 1 || plan.Count(ImportActionUpdate) != 1 || plan.Count(ImportActionUnchanged) != 1 {
+		t.Fatal("unexpected plan:", plan.Items)
+	}
+	buf := new(bytes.Buffer)
+	plan.Print(buf, true)
+	if !strings.Contains(buf.String(), "    - host=prod\n    + host=staging\n") {
+		t.Fatal("unexpected diff:", buf.String())
+	}
+
+	// apply
+	if err := applyImportPlanAtomic(client, plan, "tester", "promotion"); err != nil {
+		t.Fatal(err)
+	}
+	plan, err = buildImportPlan(client, f, importOptions{Rewrites: []selectorRewrite{rule}, VersionMode: VersionModeKeep})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Count(ImportActionUnchanged) != 3 {
+		t.Fatal("all items should be unchanged after import:", plan.Items)
+	}
+}
+
+func TestImport_Atomic(t *testing.T) {
+	fake := &fakeConfigureServer{data: map[string]map[string]map[string]*configapi.Configuration{}}
+	fake.put(newTestConfiguration("app", "db", "v1", "host=prod", "dc=dc1,env=prod"))
+	fake.put(newTestConfiguration("app", "cache", "v3", "size=20", "dc=dc1,env=prod"))
+	srv := httptest.NewServer(fake.handler())
+	defer srv.Close()
+
+	f := &ExportFile{FormatVersion: exportFormatVersion, Selectors: "dc=dc1,env=staging"}
+	for _, cfg := range []*configapi.Configuration{
+		newTestConfiguration("app", "db", "v2", "host=staging", ""),
+		newTestConfiguration("app", "cache", "v2", "size=10", ""),
+		newTestConfiguration("web", "port", "v1", "8080", ""),
+	} {
+		f.Items = append(f.Items, ExportedItem{Group: cfg.Group, Key: cfg.Key, Version: cfg.Version, Value: cfg.Value, Checksum: cfg.Signature})
+	}
+	data, err := encodeExportFile(f, FileFormatJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := filepath.Join(t.TempDir(), "staging.json")
+	if err := os.WriteFile(in, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	importArgs := func(args ...string) []string {
+		return append([]string{"-write-addr", srv.URL, "-in", in, "-rewrite", "env=staging:env=prod", "-atomic"}, args...)
+	}
+	prod := func(group, key string) *configapi.Configuration {
+		cfg := &configapi.Configuration{Group: group, Key: key}
+		_ = cfg.Selectors.Fill("dc=dc1,env=prod")
+		return fake.get(cfg)
+	}
+
+	// cache has a newer version on the target, so the whole batch is rejected
+	if err := runImport(importArgs()); !errors.Is(err, ErrVersionConflict) || !strings.Contains(err.Error(), "-version-mode=override") {
+		t.Fatal("version conflict expected:", err)
+	}
+	if fake.batches != 1 || fake.publishes != 0 || string(prod("app", "db").Value) != "host=prod" || prod("web", "port") != nil {
+		t.Fatal("nothing should be published:", fake.batches, fake.publishes)
+	}
+
+	if err := runImport(importArgs("-version-mode", "override", "-version", "v4")); err != nil {
+		t.Fatal(err)
+	}
+	if fake.batches != 2 || fake.publishes != 0 {
+		t.Fatal("all items should be published in one batch:", fake.batches, fake.publishes)
+	}
+	for _, v := range []struct{ group, key, value string }{{"app", "db", "host=staging"}, {"app", "cache", "size=10"}, {"web", "port", "8080"}} {
+		if cfg := prod(v.group, v.key); cfg == nil || cfg.Version != "v4" || string(cfg.Value) != v.value {
+			t.Fatal("unexpected configuration:", v.group, v.key, cfg)
+		}
+	}
+}
+
+func TestParseSelectorRewrite(t *testing.T) {
+	for _, rule := range []string{"env=staging", "env:env=prod", "=staging:env=prod"} {
+		if _, err := parseSelectorRewrite(rule); err == nil {
+			t.Fatal("invalid rule should fail:", rule)
+		}
+	}
+	rule, err := parseSelectorRewrite("zone=a:region=b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sel, err := rewriteSelectors("zone=a,env=prod", []selectorRewrite{rule})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sel != "env=prod,region=b" {
+		t.Fatal("unexpected selectors:", sel)
+	}
+}
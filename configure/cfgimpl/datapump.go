@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"math"
+	"math/rand"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -16,8 +17,17 @@ import (
 
 const (
 	maxRowPerQuery = 100
+
+	minRetryBackoff = 500 * time.Millisecond
+	maxRetryBackoff = 30 * time.Second
 )
 
+// retryBackoff returns the bounded exponential backoff with jitter by the number of consecutive failures
+func retryBackoff(failures int) time.Duration {
+	d := min(minRetryBackoff<<min(max(failures-1, 0), 10), maxRetryBackoff)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
 type DatabaseDataPump struct {
 	connString string
 
@@ -28,14 +38,38 @@ type DatabaseDataPump struct {
 	startOnce    sync.Once
 	updateScanId atomic.Int64
 	closeCh      chan struct{}
+	stopOnce     sync.Once
+	// ctx is cancelled on stop to interrupt the database operations
+	ctx    context.Context
+	cancel context.CancelFunc
+	// loops tracks the goroutines sending events, the event channel is closed after all of them exit
+	loops sync.WaitGroup
+
+	healthLock sync.Mutex
+	health     configapi.PumpHealth // access should be protected by healthLock
 }
 
 func NewDatabaseDataPump(connString string) *DatabaseDataPump {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &DatabaseDataPump{
 		connString:       connString,
 		closeCh:          make(chan struct{}),
 		startScan:        make(chan struct{}),
 		eventPumpChannel: make(chan configapi.Event),
+		ctx:              ctx,
+		cancel:           cancel,
+	}
+}
+
+// sleep waits for the duration and returns false if the pump is stopped
+func (d *DatabaseDataPump) sleep(duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-d.closeCh:
+		return false
+	case <-timer.C:
+		return true
 	}
 }
 
@@ -47,6 +81,34 @@ func (d *DatabaseDataPump) logError(msg string, err error) {
 	}
 }
 
+func (d *DatabaseDataPump) Health() configapi.PumpHealth {
+	d.healthLock.Lock()
+	defer d.healthLock.Unlock()
+	return d.health
+}
+
+func (d *DatabaseDataPump) markSuccess() {
+	d.healthLock.Lock()
+	defer d.healthLock.Unlock()
+	if d.health.Failures > 0 {
+		log.Println("[INFO] data pump recovered after failures:", d.health.Failures)
+	}
+	d.health = configapi.PumpHealth{
+		Healthy:     true,
+		LastSuccess: time.Now(),
+	}
+}
+
+// markFailure records the failure and returns the backoff before retrying
+func (d *DatabaseDataPump) markFailure(err error) time.Duration {
+	d.healthLock.Lock()
+	defer d.healthLock.Unlock()
+	d.health.Healthy = false
+	d.health.Failures++
+	d.health.LastError = err
+	return retryBackoff(d.health.Failures)
+}
+
 func (d *DatabaseDataPump) Startup() error {
 	c, err := pgxpool.ParseConfig(d.connString)
 	if err != nil {
@@ -57,14 +119,23 @@ func (d *DatabaseDataPump) Startup() error {
 		return err
 	}
 	d.p = p
+	d.loops.Add(1)
 	go d.scanLoop()
 	return nil
 }
 
+// Stop stops the scan and the dump in order: signal the loops, wait for them to exit, then release the database pool
+// and close the event channel, so no event is sent to the closed channel.
 func (d *DatabaseDataPump) Stop() error {
-	d.p.Close()
-	close(d.closeCh)
-	close(d.eventPumpChannel)
+	d.stopOnce.Do(func() {
+		close(d.closeCh)
+		d.cancel()
+		d.loops.Wait()
+		if d.p != nil {
+			d.p.Close()
+		}
+		close(d.eventPumpChannel)
+	})
 	return nil
 }
 
@@ -74,61 +145,33 @@ func (d *DatabaseDataPump) EventChannel() <-chan configapi.Event {
 
 func (d *DatabaseDataPump) TriggerDumpToChannel() <-chan configapi.Event {
 	ch := make(chan configapi.Event, 1024)
+	d.loops.Add(1)
 	go func() {
+		defer d.loops.Done()
 		var lastUpdateScanId int64
-		// dump db and
-		dumpTask := func() {
-			// acquire database connections
-			var c *pgxpool.Conn
-			for {
-				var err error
-				c, err = d.p.Acquire(context.Background())
-				if err != nil {
-					d.logError("acquire database connection error", err)
-					time.Sleep(1 * time.Second) // retry
-					continue
-				}
-				break
-			}
-			defer c.Release()
-			// do data retrieving
+		// dump db and retry from the beginning with a new connection on errors
+		// Note: configurations sent before the error will be sent again, which is idempotent to the receiver.
+		dumpTask := func() bool {
 			for {
-				time.Sleep(1 * time.Second) // retry
-				maxId, err := d.queryMaxSequence(c)
-				if err != nil {
-					d.logError("query max sequence error", err)
-					continue
+				maxId, err := d.dump(ch)
+				if err == nil {
+					d.markSuccess()
+					lastUpdateScanId = maxId
+					return true
 				}
-				lastUpdateScanId = maxId
-				var start int64 = 0
-				for {
-					list, err := d.queryConfigurations(start, lastUpdateScanId, c)
-					if err != nil {
-						d.logError("query configurations error", err)
-						time.Sleep(1 * time.Second) // wait for next trial
-						continue
-					}
-					if len(list) == 0 {
-						break // finish all records
-					}
-					start = list[len(list)-1].Seq
-					// send configurations
-					for _, v := range list {
-						ch <- struct {
-							Configuration *configapi.Configuration
-							Created       bool
-							Modified      bool
-							Deleted       bool
-						}{Configuration: v.Configuration, Created: v.Status == 0, Modified: false, Deleted: v.Status == 1}
-					}
+				d.logError("dump configurations error", err)
+				if !d.sleep(d.markFailure(err)) {
+					return false
 				}
-				break
 			}
 		}
-		dumpTask()
+		completed := dumpTask()
 
 		// finally allow event scan start
 		close(ch)
+		if !completed {
+			return
+		}
 		d.updateScanId.Store(lastUpdateScanId)
 		d.startOnce.Do(func() {
 			close(d.startScan)
@@ -137,9 +180,46 @@ func (d *DatabaseDataPump) TriggerDumpToChannel() <-chan configapi.Event {
 	return ch
 }
 
+// dump sends all the configurations up to the max sequence to the channel and returns the max sequence
+func (d *DatabaseDataPump) dump(ch chan<- configapi.Event) (int64, error) {
+	c, err := d.p.Acquire(d.ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Release()
+	maxId, err := d.queryMaxSequence(c)
+	if err != nil {
+		return 0, err
+	}
+	var start int64 = 0
+	for {
+		list, err := d.queryConfigurations(start, maxId, c)
+		if err != nil {
+			return 0, err
+		}
+		if len(list) == 0 {
+			return maxId, nil // finish all records
+		}
+		start = list[len(list)-1].Seq
+		// send configurations
+		for _, v := range list {
+			select {
+			case ch <- configapi.Event{Configuration: v.Configuration, Created: v.Status == 0, Modified: false, Deleted: v.Status == 1}:
+			case <-d.closeCh:
+				return 0, context.Canceled
+			}
+		}
+	}
+}
+
 func (d *DatabaseDataPump) scanLoop() {
+	defer d.loops.Done()
 	// wait for start
-	<-d.startScan
+	select {
+	case <-d.startScan:
+	case <-d.closeCh:
+		return
+	}
 	log.Println("full dump triggered, start scanLoop...")
 	// loop scan
 Overall:
@@ -155,29 +235,35 @@ Overall:
 		// query updates
 		lastUpdateScanId := d.updateScanId.Load()
 		retrieveFn := func() bool {
-			c, err := d.p.Acquire(context.Background())
+			c, err := d.p.Acquire(d.ctx)
 			if err != nil {
 				d.logError("scanLoop acquire database connection error", err)
-				time.Sleep(1 * time.Second)
+				d.sleep(d.markFailure(err))
 				return true
 			}
 			defer c.Release()
 			data, err := d.queryConfigurations(lastUpdateScanId, math.MaxInt64, c)
 			if err != nil {
 				d.logError("scanLoop query configurations error", err)
-				time.Sleep(1 * time.Second)
+				d.sleep(d.markFailure(err))
 				return true
 			}
+			d.markSuccess()
 			if len(data) == 0 {
 				// no more data, continue with short rest
 				return false
 			}
 			for _, v := range data {
-				d.eventPumpChannel <- configapi.Event{
+				select {
+				case d.eventPumpChannel <- configapi.Event{
 					Configuration: v.Configuration,
 					Created:       v.Status == 0,
 					Modified:      false,
 					Deleted:       v.Status == 1,
+				}:
+				case <-d.closeCh:
+					// the rest will be scanned again after restart
+					return false
 				}
 			}
 			// update seq number to mark as read
@@ -185,18 +271,17 @@ Overall:
 			return true
 		}
 		if retrieveFn() {
-			// has more updates or error occurs, continue immediately
-			//FIXME need to figure out error and wait some time in order to avoid use up all the resources
+			// has more updates or backoff after error, continue immediately
 			continue
 		} else {
 			// wait short period for next check
-			time.Sleep(200 * time.Millisecond)
+			d.sleep(200 * time.Millisecond)
 		}
 	}
 }
 
 func (d *DatabaseDataPump) queryMaxSequence(c *pgxpool.Conn) (int64, error) {
-	rows, err := c.Query(context.Background(), "select max(sequence) from configuration")
+	rows, err := c.Query(d.ctx, "select max(sequence) from configuration")
 	if err != nil {
 		return 0, err
 	}
@@ -220,7 +305,7 @@ func (d *DatabaseDataPump) queryConfigurations(startExcluded int64, maxIdInclude
 	Status        int
 	Configuration *configapi.Configuration
 }, err error) {
-	rows, err := c.Query(context.Background(), "select raw_cfg_value, sequence, cfg_status from configuration where sequence > $1 and sequence <= $2 order by sequence asc limit $3",
+	rows, err := c.Query(d.ctx, "select raw_cfg_value, sequence, cfg_status from configuration where sequence > $1 and sequence <= $2 order by sequence asc limit $3",
 		startExcluded, maxIdIncluded, maxRowPerQuery)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,86 @@
+package cfgimpl
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+func newAuditRecord(operation, tenant, selStr, optSelStr, group, key string, audit configapi.AuditInfo) *configapi.AuditRecord {
+	return &configapi.AuditRecord{
+		Tenant:            tenant,
+		Operation:         operation,
+		Group:             group,
+		Key:               key,
+		Selectors:         selStr,
+		OptionalSelectors: optSelStr,
+		Operator:          audit.Operator,
+		SourceIP:          audit.SourceIP,
+		RequestId:         audit.RequestId,
+		Description:       audit.Description,
+	}
+}
+
+// insertAuditRecord appends the audit record in the same transaction of the configuration change
+func (d *DatabaseDataWriter) insertAuditRecord(tx pgx.Tx, record *configapi.AuditRecord) error {
+	record.Timestamp = time.Now().UnixMilli()
+	_, err := tx.Exec(context.Background(),
+		`insert into configuration_audit (tenant, operation, selectors, optional_selectors, cfg_group, cfg_key, operator, source_ip, request_id, old_version, new_version, value_diff_hash, description, time_created) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		record.Tenant, record.Operation, record.Selectors, record.OptionalSelectors, record.Group, record.Key, record.Operator, record.SourceIP, record.RequestId,
+		record.OldVersion, record.NewVersion, record.ValueDiffHash, record.Description, record.Timestamp)
+	return err
+}
+
+func (d *DatabaseDataWriter) QueryAuditRecords(query configapi.AuditQuery) ([]configapi.AuditRecord, error) {
+	var conditions []string
+	var args []any
+	addCondition := func(cond string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, cond+" $"+strconv.Itoa(len(args)))
+	}
+	addCondition("tenant =", query.Tenant)
+	if query.Group != "" {
+		addCondition("cfg_group =", query.Group)
+	}
+	if query.Key != "" {
+		addCondition("cfg_key =", query.Key)
+	}
+	if query.Operator != "" {
+		addCondition("operator =", query.Operator)
+	}
+	if query.BeforeId > 0 {
+		addCondition("audit_id <", query.BeforeId)
+	}
+	sql := "select audit_id, tenant, operation, selectors, optional_selectors, cfg_group, cfg_key, operator, source_ip, request_id, old_version, new_version, value_diff_hash, description, time_created from configuration_audit"
+	if len(conditions) > 0 {
+		sql += " where " + strings.Join(conditions, " and ")
+	}
+	args = append(args, query.Limit)
+	sql += " order by audit_id desc limit $" + strconv.Itoa(len(args))
+
+	c, err := d.p.Acquire(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer c.Release()
+	rows, err := c.Query(context.Background(), sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []configapi.AuditRecord
+	for rows.Next() {
+		var r configapi.AuditRecord
+		if err := rows.Scan(&r.Id, &r.Tenant, &r.Operation, &r.Selectors, &r.OptionalSelectors, &r.Group, &r.Key, &r.Operator, &r.SourceIP, &r.RequestId,
+			&r.OldVersion, &r.NewVersion, &r.ValueDiffHash, &r.Description, &r.Timestamp); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
@@ -43,16 +43,15 @@ func NewDatabaseDataWriter(connString string) *DatabaseDataWriter {
 	}
 }
 
-func (d *DatabaseDataWriter) SaveConfiguration(cfg configapi.Configuration) error {
-	if !cfg.ValidateSignature() {
-		return errors.New("invalid signature")
-	}
+func (d *DatabaseDataWriter) SaveConfiguration(cfg configapi.Configuration, opt configapi.SaveOptions) error {
+	return d.SaveConfigurations([]configapi.Configuration{cfg}, opt)
+}
 
-	selStr := configapi.SelectorsHelperCacheValue(&cfg.Selectors)
-	optSelStr := configapi.SelectorsHelperCacheValue(&cfg.OptionalSelectors)
-	data, err := cbor.Marshal(cfg)
-	if err != nil {
-		return err
+func (d *DatabaseDataWriter) SaveConfigurations(cfgs []configapi.Configuration, opt configapi.SaveOptions) error {
+	for i := range cfgs {
+		if !cfgs[i].ValidateSignature() {
+			return errors.New("invalid signature")
+		}
 	}
 
 	c, err := d.p.Acquire(context.Background())
@@ -75,39 +74,12 @@ func (d *DatabaseDataWriter) SaveConfiguration(cfg configapi.Configuration) erro
 				}
 			}
 		}()
-		cfgId, err := d.getExistingConfiguration(tx, selStr, optSelStr, cfg.Group, cfg.Key)
-		if err != nil {
-			return err
-		}
-		if cfgId <= 0 {
-			// non-exist
-			newCfgId, err := d.insertConfiguration(tx, selStr, optSelStr, &cfg, data)
-			if err != nil {
+		for i := range cfgs {
+			if err := d.saveConfiguration(tx, cfgs[i], opt); err != nil {
 				return err
 			}
-			cfgId = newCfgId
 		}
-
-		const NRetry = 10
-		// retry N times to simulate CAS update
-		for i := 0; i < NRetry; i++ {
-			if cfgId > 0 {
-				// exists
-				if updated, err := d.updateConfiguration(tx, &cfg, data, cfgId); err != nil {
-					return err
-				} else if updated {
-					return nil
-				}
-			} else {
-				// non-exist
-				if updated, err := d.updateConfigurationSequence(tx, cfgId); err != nil {
-					return err
-				} else if updated {
-					return nil
-				}
-			}
-		}
-		return errors.New("too many configuration update retries")
+		return nil
 	}
 
 	err = f()
@@ -122,29 +94,109 @@ func (d *DatabaseDataWriter) SaveConfiguration(cfg configapi.Configuration) erro
 	}
 }
 
-func (d *DatabaseDataWriter) getExistingConfiguration(tx pgx.Tx, selStr, optSelStr, group, key string) (int64, error) {
-	rows, err := tx.Query(context.Background(), "select cfg_id from configuration where selectors = $1 and optional_selectors = $2 and cfg_group = $3 and cfg_key = $4", selStr, optSelStr, group, key)
+// saveConfiguration saves the configuration and the audit record in the transaction
+func (d *DatabaseDataWriter) saveConfiguration(tx pgx.Tx, cfg configapi.Configuration, opt configapi.SaveOptions) error {
+	selStr := configapi.SelectorsHelperCacheValue(&cfg.Selectors)
+	optSelStr := configapi.SelectorsHelperCacheValue(&cfg.OptionalSelectors)
+	cfgId, existing, err := d.getExistingConfiguration(tx, cfg.Tenant, selStr, optSelStr, cfg.Group, cfg.Key)
 	if err != nil {
-		return 0, err
+		return err
+	}
+	if existing != nil && opt.VersionValidator != nil {
+		// the version generated by the server may be the same to the one published concurrently
+		if generator, ok := opt.VersionValidator.(configapi.VersionGenerator); ok {
+			configapi.GenerateVersions(generator, &cfg, existing.LatestVersion())
+		}
+		if opt.VersionValidator.Compare(cfg.Version, existing.LatestVersion()) <= 0 {
+			return fmt.Errorf("%w: %s/%s current=%s, new=%s", configapi.ErrVersionNotIncreased, cfg.Group, cfg.Key, existing.LatestVersion(), cfg.Version)
+		}
+	}
+	// scheduled configuration keeps the currently visible one as the fallback
+	if now := time.Now().Unix(); cfg.Timestamp > now || cfg.ExpiryTime > 0 {
+		if existing != nil {
+			cfg.Fallback = existing.EffectiveAt(now)
+		}
+		if cfg.ExpiryTime > 0 && cfg.Fallback == nil {
+			return fmt.Errorf("%w: %s/%s no configuration to revert to after expiry", configapi.ErrInvalidSchedule, cfg.Group, cfg.Key)
+		}
+	}
+	data, err := cbor.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	record := newAuditRecord(configapi.AuditOperationPublish, cfg.Tenant, selStr, optSelStr, cfg.Group, cfg.Key, opt.Audit)
+	record.NewVersion = cfg.Version
+	if existing != nil {
+		record.OldVersion = existing.Version
+		record.ValueDiffHash = configapi.ValueDiffHash(existing.Value, cfg.Value)
+	} else {
+		record.ValueDiffHash = configapi.ValueDiffHash(nil, cfg.Value)
+	}
+	if cfgId <= 0 {
+		// non-exist
+		newCfgId, err := d.insertConfiguration(tx, selStr, optSelStr, &cfg, data)
+		if err != nil {
+			return err
+		}
+		cfgId = newCfgId
+	}
+
+	const NRetry = 10
+	// retry N times to simulate CAS update
+	for i := 0; i < NRetry; i++ {
+		if cfgId > 0 {
+			// exists
+			if updated, err := d.updateConfiguration(tx, &cfg, data, cfgId); err != nil {
+				return err
+			} else if updated {
+				return d.insertAuditRecord(tx, record)
+			}
+		} else {
+			// non-exist
+			if updated, err := d.updateConfigurationSequence(tx, cfgId); err != nil {
+				return err
+			} else if updated {
+				return d.insertAuditRecord(tx, record)
+			}
+		}
+	}
+	return errors.New("too many configuration update retries")
+}
+
+// getExistingConfiguration returns the id of the configuration record and the configuration if it is not deleted.
+// The record is locked until the end of the transaction, so the version check is not raced by concurrent publishing.
+func (d *DatabaseDataWriter) getExistingConfiguration(tx pgx.Tx, tenant, selStr, optSelStr, group, key string) (int64, *configapi.Configuration, error) {
+	rows, err := tx.Query(context.Background(), "select cfg_id, cfg_status, raw_cfg_value from configuration where tenant = $1 and selectors = $2 and optional_selectors = $3 and cfg_group = $4 and cfg_key = $5 for update", tenant, selStr, optSelStr, group, key)
+	if err != nil {
+		return 0, nil, err
 	}
 	defer rows.Close()
 	if rows.Next() {
 		var cfgId int64
-		err = rows.Scan(&cfgId)
+		var status int
+		var data []byte
+		err = rows.Scan(&cfgId, &status, &data)
 		if err != nil {
-			return 0, err
+			return 0, nil, err
 		}
-		return cfgId, nil
+		if status != 0 {
+			return cfgId, nil, nil
+		}
+		cfg := new(configapi.Configuration)
+		if err := cbor.Unmarshal(data, cfg); err != nil {
+			return 0, nil, err
+		}
+		return cfgId, cfg, nil
 	} else {
-		return 0, nil
+		return 0, nil, nil
 	}
 }
 
 func (d *DatabaseDataWriter) insertConfiguration(tx pgx.Tx, selStr, optSelStr string, cfg *configapi.Configuration, data []byte) (int64, error) {
 	now := time.Now().UnixMilli()
 	rows, err := tx.Query(context.Background(),
-		`insert into configuration (selectors, optional_selectors, cfg_group, cfg_key, cfg_version, cfg_status, raw_cfg_value, time_created, time_updated, sequence) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning cfg_id`,
-		selStr, optSelStr, cfg.Group, cfg.Key, cfg.Version, 0, data, now, now, 0)
+		`insert into configuration (tenant, selectors, optional_selectors, cfg_group, cfg_key, cfg_version, cfg_status, raw_cfg_value, time_created, time_updated, sequence) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning cfg_id`,
+		cfg.Tenant, selStr, optSelStr, cfg.Group, cfg.Key, cfg.Version, 0, data, now, now, 0)
 	if err != nil {
 		return 0, err
 	}
@@ -191,23 +243,48 @@ func (d *DatabaseDataWriter) updateConfiguration(tx pgx.Tx, cfg *configapi.Confi
 	}
 }
 
-func (d *DatabaseDataWriter) DeleteConfiguration(group, key, sel, optSel string) (bool, error) {
+func (d *DatabaseDataWriter) DeleteConfiguration(tenant, group, key, sel, optSel string, audit configapi.AuditInfo) (bool, error) {
 	now := time.Now().UnixMilli()
 	c, err := d.p.Acquire(context.Background())
 	if err != nil {
 		return false, err
 	}
 	defer c.Release()
+	tx, err := c.BeginTx(context.Background(), pgx.TxOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		// no-op if the transaction has been committed
+		_ = tx.Rollback(context.Background())
+	}()
+
+	_, existing, err := d.getExistingConfiguration(tx, tenant, sel, optSel, group, key)
+	if err != nil {
+		return false, err
+	}
+	if existing == nil {
+		return false, nil
+	}
 
-	tag, err := c.Exec(context.Background(),
-		"update configuration set cfg_status = 1, time_updated = $1, sequence = nextval('cfg_seq') where selectors = $2 and optional_selectors = $3 and cfg_group = $4 and cfg_key = $5 and pg_try_advisory_xact_lock(-1000)",
-		now, sel, optSel, group, key)
+	tag, err := tx.Exec(context.Background(),
+		"update configuration set cfg_status = 1, time_updated = $1, sequence = nextval('cfg_seq') where tenant = $2 and selectors = $3 and optional_selectors = $4 and cfg_group = $5 and cfg_key = $6 and pg_try_advisory_xact_lock(-1000)",
+		now, tenant, sel, optSel, group, key)
 	if err != nil {
 		return false, err
 	}
 	if tag.RowsAffected() == 0 {
 		return false, nil
-	} else {
-		return true, nil
 	}
+
+	record := newAuditRecord(configapi.AuditOperationDelete, tenant, sel, optSel, group, key, audit)
+	record.OldVersion = existing.Version
+	record.ValueDiffHash = configapi.ValueDiffHash(existing.Value, nil)
+	if err := d.insertAuditRecord(tx, record); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(context.Background()); err != nil {
+		return false, err
+	}
+	return true, nil
 }
@@ -1,6 +1,7 @@
 create table configuration
 (
     cfg_id             bigserial     not null,
+    tenant             varchar(200)  not null default '',
     selectors          varchar(1000) not null,
     optional_selectors varchar(1000) not null,
     cfg_group          varchar(200)  not null,
@@ -14,18 +15,52 @@ create table configuration
     primary key (cfg_id)
 );
 
-create unique index on configuration (selectors, optional_selectors, cfg_group, cfg_key);
+create unique index on configuration (tenant, selectors, optional_selectors, cfg_group, cfg_key);
 
 create unique index on configuration (sequence asc);
 
 create index on configuration (cfg_group);
 
+comment on column configuration.tenant is 'empty for the default tenant';
+
 comment on column configuration.cfg_status is '0-valid, 1-deleted';
 
 comment on column configuration.sequence is 'values generated by cfg_seq on every data change to support incremental queries';
 
 create sequence cfg_seq increment by 16 minvalue 1 maxvalue 9223372036854775807 start 1 cache 1 no cycle;
 
+create table configuration_audit
+(
+    audit_id           bigserial     not null,
+    tenant             varchar(200)  not null default '',
+    operation          varchar(20)   not null,
+    selectors          varchar(1000) not null,
+    optional_selectors varchar(1000) not null,
+    cfg_group          varchar(200)  not null,
+    cfg_key            varchar(200)  not null,
+    operator           varchar(200)  not null,
+    source_ip          varchar(100)  not null,
+    request_id         varchar(200)  not null,
+    old_version        varchar(200)  not null,
+    new_version        varchar(200)  not null,
+    value_diff_hash    varchar(100)  not null,
+    description        varchar(2000) not null,
+    time_created       bigint        not null,
+    primary key (audit_id)
+);
+
+create index on configuration_audit (tenant, cfg_group, cfg_key);
+
+create index on configuration_audit (operator);
+
+comment on table configuration_audit is 'append-only audit trail of configuration changes, records should never be updated or deleted';
+
+comment on column configuration_audit.operation is 'publish or delete';
+
+-- Migration of the tables created without tenant:
+--   alter table configuration add column tenant varchar(200) not null default '';
+--   alter table configuration_audit add column tenant varchar(200) not null default '';
+--   then recreate the unique index of configuration and the (cfg_group, cfg_key) index of configuration_audit as above
 -- add more tables to support selector hierarchy
 -- Note1: 'sequence' field will not guarantee strict order, which means there may be event loss from data pump if the field is used for retrieving updates when high concurrent writes happen.
 -- Note2: In order to avoid the issue in Note1 and also guarantee the sequence order, the following is a possible solution regardless of the performance
@@ -1,5 +1,9 @@
 package configapi
 
+import (
+	"time"
+)
+
 type Event struct {
 	Configuration *Configuration
 
@@ -13,4 +17,17 @@ type DataPump interface {
 	Stop() error
 	EventChannel() <-chan Event
 	TriggerDumpToChannel() <-chan Event
+	// Health returns the health status of the connection to the data source
+	Health() PumpHealth
+}
+
+// PumpHealth is the health status of the data pump
+type PumpHealth struct {
+	// Healthy is false before the first success or if the latest operation on the data source failed
+	Healthy bool
+	// LastSuccess is the time of the latest successful operation on the data source
+	LastSuccess time.Time
+	// Failures is the number of consecutive failures
+	Failures  int
+	LastError error
 }
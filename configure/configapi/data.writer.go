@@ -1,8 +1,20 @@
 package configapi
 
+type SaveOptions struct {
+	Audit AuditInfo
+	// VersionValidator is used to check the version to be saved is strictly greater than the current one if it is not nil.
+	// ErrVersionNotIncreased should be returned if the check fails.
+	// The versions should be generated again with the current one first if it is also a VersionGenerator.
+	VersionValidator VersionValidator
+}
+
 type DataWriter interface {
 	Startup() error
 	Stop() error
-	SaveConfiguration(cfg Configuration) error
-	DeleteConfiguration(group, key, sel, optSel string) (bool, error)
+	SaveConfiguration(cfg Configuration, opt SaveOptions) error
+	// SaveConfigurations saves all the configurations in one transaction: either all or none of them are saved.
+	// The configurations should carry the same Configuration.Batch so that they are delivered together.
+	SaveConfigurations(cfgs []Configuration, opt SaveOptions) error
+	DeleteConfiguration(tenant, group, key, sel, optSel string, audit AuditInfo) (bool, error)
+	QueryAuditRecords(query AuditQuery) ([]AuditRecord, error)
 }
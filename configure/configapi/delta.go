@@ -0,0 +1,145 @@
+package configapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+var (
+	ErrInvalidDelta = errors.New("invalid delta")
+)
+
+const (
+	deltaBlockSize = 16
+	deltaHashBase  = 1099511628211
+	// maxDeltaTargetSize is the max size of the value restored from the delta
+	maxDeltaTargetSize = 256 * 1024 * 1024
+
+	deltaOpInsert = 0
+	deltaOpCopy   = 1
+)
+
+// EncodeDelta generates the binary delta which restores target from base via ApplyDelta.
+//
+// Format: uvarint(len(target)) + crc32(target) + ops
+//   - insert: 0 + uvarint(n) + n bytes
+//   - copy: 1 + uvarint(offset of base) + uvarint(n)
+func EncodeDelta(base, target []byte) []byte {
+	buf := binary.AppendUvarint(nil, uint64(len(target)))
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(target))
+
+	// index the aligned blocks of base
+	index := make(map[uint64]int, len(base)/deltaBlockSize)
+	for off := 0; off+deltaBlockSize <= len(base); off += deltaBlockSize {
+		h := deltaBlockHash(base[off : off+deltaBlockSize])
+		if _, ok := index[h]; !ok {
+			index[h] = off
+		}
+	}
+
+	// pow = base^(blockSize-1) for rolling out the first byte of the window
+	var pow uint64 = 1
+	for i := 0; i < deltaBlockSize-1; i++ {
+		pow *= deltaHashBase
+	}
+
+	literal := 0
+	i := 0
+	var h uint64
+	if len(target) >= deltaBlockSize {
+		h = deltaBlockHash(target[:deltaBlockSize])
+	}
+	for i+deltaBlockSize <= len(target) {
+		if off, ok := index[h]; ok && bytes.Equal(base[off:off+deltaBlockSize], target[i:i+deltaBlockSize]) {
+			// extend the match backward into the pending literal and forward
+			start, baseStart := i, off
+			for start > literal && baseStart > 0 && target[start-1] == base[baseStart-1] {
+				start--
+				baseStart--
+			}
+			end, baseEnd := i+deltaBlockSize, off+deltaBlockSize
+			for end < len(target) && baseEnd < len(base) && target[end] == base[baseEnd] {
+				end++
+				baseEnd++
+			}
+			buf = appendDeltaInsert(buf, target[literal:start])
+			buf = append(buf, deltaOpCopy)
+			buf = binary.AppendUvarint(buf, uint64(baseStart))
+			buf = binary.AppendUvarint(buf, uint64(end-start))
+			i, literal = end, end
+			if i+deltaBlockSize <= len(target) {
+				h = deltaBlockHash(target[i : i+deltaBlockSize])
+			}
+			continue
+		}
+		if i+deltaBlockSize < len(target) {
+			h = (h-uint64(target[i])*pow)*deltaHashBase + uint64(target[i+deltaBlockSize])
+		}
+		i++
+	}
+	return appendDeltaInsert(buf, target[literal:])
+}
+
+func appendDeltaInsert(buf, data []byte) []byte {
+	if len(data) == 0 {
+		return buf
+	}
+	buf = append(buf, deltaOpInsert)
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+func deltaBlockHash(block []byte) uint64 {
+	var h uint64
+	for _, b := range block {
+		h = h*deltaHashBase + uint64(b)
+	}
+	return h
+}
+
+// ApplyDelta restores the target from base and the delta generated by EncodeDelta
+func ApplyDelta(base, delta []byte) ([]byte, error) {
+	size, n := binary.Uvarint(delta)
+	if n <= 0 || size > maxDeltaTargetSize || len(delta) < n+4 {
+		return nil, ErrInvalidDelta
+	}
+	checksum := binary.BigEndian.Uint32(delta[n:])
+	delta = delta[n+4:]
+
+	target := make([]byte, 0, size)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch op {
+		case deltaOpInsert:
+			l, n := binary.Uvarint(delta)
+			if n <= 0 || l > uint64(len(delta)-n) {
+				return nil, ErrInvalidDelta
+			}
+			target = append(target, delta[n:n+int(l)]...)
+			delta = delta[n+int(l):]
+		case deltaOpCopy:
+			off, n1 := binary.Uvarint(delta)
+			if n1 <= 0 {
+				return nil, ErrInvalidDelta
+			}
+			l, n2 := binary.Uvarint(delta[n1:])
+			if n2 <= 0 || off > uint64(len(base)) || l > uint64(len(base))-off {
+				return nil, ErrInvalidDelta
+			}
+			target = append(target, base[off:off+l]...)
+			delta = delta[n1+n2:]
+		default:
+			return nil, ErrInvalidDelta
+		}
+		if uint64(len(target)) > size {
+			return nil, ErrInvalidDelta
+		}
+	}
+	if uint64(len(target)) != size || crc32.ChecksumIEEE(target) != checksum {
+		return nil, ErrInvalidDelta
+	}
+	return target, nil
+}
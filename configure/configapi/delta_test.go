@@ -0,0 +1,60 @@
+package configapi
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestDelta_RoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	base := make([]byte, 1024*1024)
+	r.Read(base)
+
+	target := append([]byte(nil), base[:300000]...)
+	target = append(target, []byte("inserted routing entry")...)
+	target = append(target, base[300100:800000]...)
+	target = append(target, base[900000:]...)
+	target[10] ^= 0xff
+
+	delta := EncodeDelta(base, target)
+	if len(delta) > 1024 {
+		t.Fatal("delta should be small:", len(delta))
+	}
+	restored, err := ApplyDelta(base, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored, target) {
+		t.Fatal("restored value mismatch")
+	}
+
+	for _, c := range []struct{ base, target []byte }{
+		{nil, nil},
+		{nil, []byte("new value")},
+		{[]byte("old value"), nil},
+		{[]byte("short"), []byte("shorter")},
+	} {
+		restored, err := ApplyDelta(c.base, EncodeDelta(c.base, c.target))
+		if err != nil || !bytes.Equal(restored, c.target) {
+			t.Fatal("unexpected result:", string(c.target), err)
+		}
+	}
+}
+
+func TestDelta_Invalid(t *testing.T) {
+	base := bytes.Repeat([]byte("0123456789abcdef"), 100)
+	target := append(bytes.Repeat([]byte("0123456789abcdef"), 50), []byte("tail")...)
+	delta := EncodeDelta(base, target)
+	if _, err := ApplyDelta(base[:100], delta); !errors.Is(err, ErrInvalidDelta) {
+		t.Fatal("copy out of base should fail:", err)
+	}
+	if _, err := ApplyDelta(base, delta[:len(delta)-1]); !errors.Is(err, ErrInvalidDelta) {
+		t.Fatal("truncated delta should fail:", err)
+	}
+	other := bytes.Repeat([]byte("fedcba9876543210"), 100)
+	if _, err := ApplyDelta(other, delta); !errors.Is(err, ErrInvalidDelta) {
+		t.Fatal("wrong base should fail the checksum:", err)
+	}
+}
@@ -1,23 +1,32 @@
 package configapi
 
 type AcquireConfigurationReq struct {
-	Requested         []RequestedConfigurationKey `cbor:"req,"`
-	Selectors         Selectors                   `cbor:"selectors,"`
-	OptionalSelectors Selectors                   `cbor:"opt_selectors,"`
+	Requested         []RequestedConfigurationKey `cbor:"req," json:"req"`
+	Selectors         Selectors                   `cbor:"selectors," json:"selectors"`
+	OptionalSelectors Selectors                   `cbor:"opt_selectors," json:"opt_selectors"`
+	// WaitTime is the desired wait time in seconds for updates, clamped by the server. 0 = server default.
+	WaitTime int `cbor:"wait_time,omitempty" json:"wait_time,omitempty"`
+	// AcceptDelta means the client accepts the value in binary delta against the value of the requested version
+	AcceptDelta bool `cbor:"accept_delta,omitempty" json:"accept_delta,omitempty"`
+	// Hierarchical requests the most specific configurations matching the selectors under the selector hierarchy
+	// declared on the server, rather than the exact selector combination. Delta is not applied in this mode.
+	Hierarchical bool `cbor:"hierarchical,omitempty" json:"hierarchical,omitempty"`
 }
 
 type AcquireConfigurationRes struct {
-	Requested []Configuration `cbor:"req,"`
+	Requested []Configuration `cbor:"req," json:"req"`
+	// Deleted contains the tombstones of the requested configurations which have been deleted
+	Deleted []RequestedConfigurationKey `cbor:"deleted,omitempty" json:"deleted,omitempty"`
 }
 
 type AcquireConfigurationFailRes struct {
-	Code     string   `cbor:"code,"`
-	Message  string   `cbor:"msg,"`
-	InfoList []string `cbor:"info_list,"`
+	Code     string   `cbor:"code," json:"code"`
+	Message  string   `cbor:"msg," json:"msg"`
+	InfoList []string `cbor:"info_list," json:"info_list"`
 }
 
 type GetConfigurationRes struct {
-	Code          string        `cbor:"code,"`
-	Message       string        `cbor:"msg,"`
-	Configuration Configuration `cbor:"cfg"`
+	Code          string        `cbor:"code," json:"code"`
+	Message       string        `cbor:"msg," json:"msg"`
+	Configuration Configuration `cbor:"cfg" json:"cfg"`
 }
@@ -0,0 +1,52 @@
+package configapi
+
+type AdminSelectorsItem struct {
+	Selectors          string `cbor:"selectors," json:"selectors"`
+	OptionalSelectors  string `cbor:"opt_selectors," json:"opt_selectors"`
+	ConfigurationCount int    `cbor:"cfg_count," json:"cfg_count"`
+	ListenerCount      int    `cbor:"listener_count," json:"listener_count"`
+}
+
+type AdminSelectorsRes struct {
+	Code    string               `cbor:"code," json:"code"`
+	Message string               `cbor:"msg," json:"msg"`
+	Total   int                  `cbor:"total," json:"total"`
+	Items   []AdminSelectorsItem `cbor:"items," json:"items"`
+}
+
+type AdminGroupItem struct {
+	Group    string `cbor:"group," json:"group"`
+	KeyCount int    `cbor:"key_count," json:"key_count"`
+}
+
+type AdminGroupsRes struct {
+	Code    string           `cbor:"code," json:"code"`
+	Message string           `cbor:"msg," json:"msg"`
+	Total   int              `cbor:"total," json:"total"`
+	Items   []AdminGroupItem `cbor:"items," json:"items"`
+}
+
+type AdminKeyItem struct {
+	Group   string `cbor:"group," json:"group"`
+	Key     string `cbor:"key," json:"key"`
+	Version string `cbor:"version," json:"version"`
+	// Timestamp is the same to Configuration.Timestamp
+	Timestamp     int64 `cbor:"timestamp," json:"timestamp"`
+	ListenerCount int   `cbor:"listener_count," json:"listener_count"`
+}
+
+type AdminKeysRes struct {
+	Code    string         `cbor:"code," json:"code"`
+	Message string         `cbor:"msg," json:"msg"`
+	Total   int            `cbor:"total," json:"total"`
+	Items   []AdminKeyItem `cbor:"items," json:"items"`
+}
+
+type AdminStatsRes struct {
+	Code               string `cbor:"code," json:"code"`
+	Message            string `cbor:"msg," json:"msg"`
+	SelectorsCount     int    `cbor:"selectors_count," json:"selectors_count"`
+	ConfigurationCount int    `cbor:"cfg_count," json:"cfg_count"`
+	// ListenerCount is the number of waiting requests
+	ListenerCount int `cbor:"listener_count," json:"listener_count"`
+}
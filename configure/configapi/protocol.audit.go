@@ -0,0 +1,67 @@
+package configapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const (
+	AuditOperationPublish = "publish"
+	AuditOperationDelete  = "delete"
+)
+
+// AuditInfo is the information of the request that changes a configuration
+type AuditInfo struct {
+	Operator    string
+	SourceIP    string
+	RequestId   string
+	Description string
+}
+
+type AuditRecord struct {
+	Id                int64  `cbor:"id," json:"id"`
+	Tenant            string `cbor:"tenant,omitempty" json:"tenant,omitempty"`
+	Operation         string `cbor:"operation," json:"operation"`
+	Group             string `cbor:"group," json:"group"`
+	Key               string `cbor:"key," json:"key"`
+	Selectors         string `cbor:"selectors," json:"selectors"`
+	OptionalSelectors string `cbor:"opt_selectors," json:"opt_selectors"`
+	Operator          string `cbor:"operator," json:"operator"`
+	SourceIP          string `cbor:"source_ip," json:"source_ip"`
+	RequestId         string `cbor:"request_id," json:"request_id"`
+	OldVersion        string `cbor:"old_version," json:"old_version"`
+	NewVersion        string `cbor:"new_version," json:"new_version"`
+	ValueDiffHash     string `cbor:"value_diff_hash," json:"value_diff_hash"`
+	Description       string `cbor:"description," json:"description"`
+	// Timestamp is the unix timestamp in millisecond when the record is created
+	Timestamp int64 `cbor:"timestamp," json:"timestamp"`
+}
+
+// AuditQuery filters audit records. Empty fields match all records.
+type AuditQuery struct {
+	// Tenant is always matched, DefaultTenant included
+	Tenant   string
+	Group    string
+	Key      string
+	Operator string
+	// BeforeId is used for pagination, records with id less than BeforeId will be returned if it is greater than 0
+	BeforeId int64
+	Limit    int
+}
+
+type AuditQueryRes struct {
+	Code    string        `cbor:"code," json:"code"`
+	Message string        `cbor:"msg," json:"msg"`
+	Records []AuditRecord `cbor:"records," json:"records"`
+}
+
+// ValueDiffHash generates the hash representing the change from oldValue to newValue
+// Format: sha256:hex(sha256(sha256(oldValue) + sha256(newValue)))
+func ValueDiffHash(oldValue, newValue []byte) string {
+	oldSum := sha256.Sum256(oldValue)
+	newSum := sha256.Sum256(newValue)
+	h := sha256.New()
+	h.Write(oldSum[:])
+	h.Write(newSum[:])
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
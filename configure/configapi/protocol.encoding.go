@@ -0,0 +1,105 @@
+package configapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	ErrUnsupportedContentEncoding = errors.New("unsupported content encoding")
+	ErrDecompressedSizeExceeded   = errors.New("decompressed size exceeded")
+)
+
+// Content encodings supported by the server and the client via Accept-Encoding and Content-Encoding headers
+const (
+	ContentEncodingSnappy = "snappy"
+	ContentEncodingZstd   = "zstd"
+	ContentEncodingGzip   = "gzip"
+)
+
+// maxDecompressedSize is the max size of the decompressed body
+const maxDecompressedSize = 256 * 1024 * 1024
+
+var zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+	// EncodeAll is safe for concurrent use
+	enc, _ := zstd.NewWriter(nil)
+	return enc
+})
+
+var zstdDecoder = sync.OnceValue(func() *zstd.Decoder {
+	// DecodeAll is safe for concurrent use
+	dec, _ := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize), zstd.WithDecoderConcurrency(0))
+	return dec
+})
+
+// NegotiateContentEncoding returns the first supported encoding in the Accept-Encoding header, or empty if none
+func NegotiateContentEncoding(acceptEncoding string) string {
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		encoding, params, _ := strings.Cut(item, ";")
+		if q := strings.TrimSpace(params); q == "q=0" || q == "q=0.0" {
+			continue
+		}
+		switch encoding = strings.ToLower(strings.TrimSpace(encoding)); encoding {
+		case ContentEncodingSnappy, ContentEncodingZstd, ContentEncodingGzip:
+			return encoding
+		}
+	}
+	return ""
+}
+
+func Compress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case ContentEncodingSnappy:
+		return snappy.Encode(nil, data), nil
+	case ContentEncodingZstd:
+		return zstdEncoder().EncodeAll(data, nil), nil
+	case ContentEncodingGzip:
+		buf := new(bytes.Buffer)
+		w := gzip.NewWriter(buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, ErrUnsupportedContentEncoding
+	}
+}
+
+func Decompress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case ContentEncodingSnappy:
+		if l, err := snappy.DecodedLen(data); err != nil {
+			return nil, err
+		} else if l > maxDecompressedSize {
+			return nil, ErrDecompressedSizeExceeded
+		}
+		return snappy.Decode(nil, data)
+	case ContentEncodingZstd:
+		return zstdDecoder().DecodeAll(data, nil)
+	case ContentEncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		result, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(result) > maxDecompressedSize {
+			return nil, ErrDecompressedSizeExceeded
+		}
+		return result, nil
+	default:
+		return nil, ErrUnsupportedContentEncoding
+	}
+}
@@ -0,0 +1,44 @@
+package configapi
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestNegotiateContentEncoding(t *testing.T) {
+	for header, expected := range map[string]string{
+		"":                    "",
+		"br, deflate":         "",
+		"zstd, gzip":          ContentEncodingZstd,
+		"gzip;q=0, Snappy":    ContentEncodingSnappy,
+		" gzip ;q=0.8, zstd ": ContentEncodingGzip,
+	} {
+		if encoding := NegotiateContentEncoding(header); encoding != expected {
+			t.Fatal("unexpected encoding:", header, encoding)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	data := bytes.Repeat([]byte("routing table entry;"), 1000)
+	for _, encoding := range []string{ContentEncodingSnappy, ContentEncodingZstd, ContentEncodingGzip} {
+		compressed, err := Compress(encoding, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(compressed) >= len(data) {
+			t.Fatal("data should be compressed:", encoding)
+		}
+		decompressed, err := Decompress(encoding, compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decompressed, data) {
+			t.Fatal("decompressed data mismatch:", encoding)
+		}
+	}
+	if _, err := Compress("br", data); !errors.Is(err, ErrUnsupportedContentEncoding) {
+		t.Fatal("unsupported encoding expected:", err)
+	}
+}
@@ -9,7 +9,7 @@ import (
 )
 
 type Selectors struct {
-	Data   map[string]string `cbor:"data,"`
+	Data   map[string]string `cbor:"data," json:"data"`
 	cached string
 }
 
@@ -58,23 +58,47 @@ func (s *Selectors) Fill(str string) error {
 }
 
 type Configuration struct {
+	// Tenant owns the configuration, DefaultTenant if no tenant is declared. It is assigned by the server from the
+	// credentials of the publishing request.
+	Tenant string `cbor:"tenant,omitempty" json:"tenant,omitempty"`
 	// Group is a set of configurations
-	Group string `cbor:"group,"`
+	Group string `cbor:"group," json:"group"`
 	// Key is the name of the configuration
-	Key string `cbor:"key,"`
+	Key string `cbor:"key," json:"key"`
 	// Version represents the unique configuration history
-	Version string `cbor:"version,"`
+	Version string `cbor:"version," json:"version"`
 
 	// Value is the complete data of the configuration
-	Value []byte `cbor:"value,"`
+	Value []byte `cbor:"value," json:"value"`
+	// Template means Value contains placeholders rendered by the server, see RenderTemplate. The configurations
+	// responded to the clients are the rendered ones with the versions from RenderedVersion.
+	Template bool `cbor:"template,omitempty" json:"template,omitempty"`
 	// Signature represents the data integrity of the configuration
-	Signature string `cbor:"sign,"`
+	Signature string `cbor:"sign," json:"sign"`
 	// Selector represents the part where the configuration will be used
-	Selectors Selectors `cbor:"selectors,"`
+	Selectors Selectors `cbor:"selectors," json:"selectors"`
 	// OptionalSelectors is used for optional selectors matching
-	OptionalSelectors Selectors `cbor:"opt_selectors,"`
+	OptionalSelectors Selectors `cbor:"opt_selectors," json:"opt_selectors"`
 	// Timestamp is the unix timestamp in second of the effective time(create/update) of this configuration
-	Timestamp int64 `cbor:"timestamp,"`
+	// The configuration is not visible before the time if it is scheduled in the future.
+	Timestamp int64 `cbor:"timestamp," json:"timestamp"`
+	// ExpiryTime is the unix timestamp in second when the configuration reverts to Fallback with ExpiryVersion, 0 = never
+	ExpiryTime    int64  `cbor:"expiry_time,omitempty" json:"expiry_time,omitempty"`
+	ExpiryVersion string `cbor:"expiry_version,omitempty" json:"expiry_version,omitempty"`
+	// Fallback is the configuration visible before Timestamp or after ExpiryTime of a scheduled configuration
+	Fallback *Configuration `cbor:"fallback,omitempty" json:"fallback,omitempty"`
+	// Batch is set if the configuration is published together with other configurations atomically
+	Batch *BatchInfo `cbor:"batch,omitempty" json:"batch,omitempty"`
+	// DeltaBase is set if Value is the binary delta(see ApplyDelta) against the value of the version DeltaBase.
+	// It is only used in the responses of the retrieving api.
+	DeltaBase string `cbor:"delta_base,omitempty" json:"delta_base,omitempty"`
+}
+
+// BatchInfo identifies the configurations published in the same batch
+type BatchInfo struct {
+	Id string `cbor:"id," json:"id"`
+	// Size is the number of configurations in the batch
+	Size int `cbor:"size," json:"size"`
 }
 
 func (c *Configuration) GenerateSignature() string {
@@ -88,14 +112,18 @@ func (c *Configuration) ValidateSignature() bool {
 }
 
 type RequestedConfigurationKey struct {
-	Group   string `cbor:"group,"`
-	Key     string `cbor:"key,"`
-	Version string `cbor:"version,"`
+	Group   string `cbor:"group," json:"group"`
+	Key     string `cbor:"key," json:"key"`
+	Version string `cbor:"version," json:"version"`
+	// Deleted means the client has received the deletion of the configuration and waits for it to be recreated
+	Deleted bool `cbor:"deleted,omitempty" json:"deleted,omitempty"`
 }
 
 type RawConfiguration struct {
-	Group   string `cbor:"group,"`
-	Key     string `cbor:"key,"`
-	Version string `cbor:"version,"`
-	Value   []byte `cbor:"value,"`
+	Group   string `cbor:"group," json:"group"`
+	Key     string `cbor:"key," json:"key"`
+	Version string `cbor:"version," json:"version"`
+	Value   []byte `cbor:"value," json:"value"`
+	// Template is the same to Configuration.Template
+	Template bool `cbor:"template,omitempty" json:"template,omitempty"`
 }
@@ -0,0 +1,13 @@
+package configapi
+
+type ReadinessRes struct {
+	Code    string `cbor:"code," json:"code"`
+	Message string `cbor:"msg," json:"msg"`
+	Ready   bool   `cbor:"ready," json:"ready"`
+	// PumpHealthy is the health status of the data pump, see PumpHealth.Healthy
+	PumpHealthy bool `cbor:"pump_healthy," json:"pump_healthy"`
+	// PumpLastSuccess is the unix time in seconds of the latest successful operation of the data pump, 0 = never
+	PumpLastSuccess int64 `cbor:"pump_last_success," json:"pump_last_success"`
+	// PumpFailures is the number of consecutive failures of the data pump
+	PumpFailures int `cbor:"pump_failures," json:"pump_failures"`
+}
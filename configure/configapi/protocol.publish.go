@@ -1,15 +1,74 @@
 package configapi
 
 type PublishReq struct {
-	Configuration     RawConfiguration `cbor:"cfg,"`
-	Selectors         Selectors        `cbor:"selectors,"`
-	OptionalSelectors Selectors        `cbor:"opt_selectors,"`
-	Operator          string           `cbor:"operator,"`
-	Description       string           `cbor:"description"`
+	Configuration     RawConfiguration `cbor:"cfg," json:"cfg"`
+	Selectors         Selectors        `cbor:"selectors," json:"selectors"`
+	OptionalSelectors Selectors        `cbor:"opt_selectors," json:"opt_selectors"`
+	Operator          string           `cbor:"operator," json:"operator"`
+	Description       string           `cbor:"description" json:"description"`
+	// EffectiveTime schedules the configuration to be visible at the unix timestamp in second, 0 = immediately
+	EffectiveTime int64 `cbor:"effective_time,omitempty" json:"effective_time,omitempty"`
+	// ExpiryTime reverts the configuration to the one visible before publishing at the unix timestamp in second with
+	// ExpiryVersion, 0 = never
+	ExpiryTime    int64  `cbor:"expiry_time,omitempty" json:"expiry_time,omitempty"`
+	ExpiryVersion string `cbor:"expiry_version,omitempty" json:"expiry_version,omitempty"`
+}
+
+// ToConfiguration converts the request to the Configuration with signature generated.
+// The timestamp is replaced by EffectiveTime if it is later.
+func (p *PublishReq) ToConfiguration(timestamp int64) *Configuration {
+	if p.EffectiveTime > timestamp {
+		timestamp = p.EffectiveTime
+	}
+	cfg := &Configuration{
+		Group:             p.Configuration.Group,
+		Key:               p.Configuration.Key,
+		Version:           p.Configuration.Version,
+		Value:             p.Configuration.Value,
+		Template:          p.Configuration.Template,
+		Selectors:         p.Selectors,
+		OptionalSelectors: p.OptionalSelectors,
+		Timestamp:         timestamp,
+		ExpiryTime:        p.ExpiryTime,
+		ExpiryVersion:     p.ExpiryVersion,
+	}
+	cfg.Signature = cfg.GenerateSignature()
+	return cfg
+}
+
+// PublishBatchReq publishes multiple configurations under the same selectors atomically
+type PublishBatchReq struct {
+	Configurations    []RawConfiguration `cbor:"cfgs," json:"cfgs"`
+	Selectors         Selectors          `cbor:"selectors," json:"selectors"`
+	OptionalSelectors Selectors          `cbor:"opt_selectors," json:"opt_selectors"`
+	Operator          string             `cbor:"operator," json:"operator"`
+	Description       string             `cbor:"description" json:"description"`
+}
+
+// Items splits the batch request into single publish requests
+func (p *PublishBatchReq) Items() []*PublishReq {
+	items := make([]*PublishReq, 0, len(p.Configurations))
+	for _, cfg := range p.Configurations {
+		items = append(items, &PublishReq{
+			Configuration:     cfg,
+			Selectors:         p.Selectors,
+			OptionalSelectors: p.OptionalSelectors,
+			Operator:          p.Operator,
+			Description:       p.Description,
+		})
+	}
+	return items
 }
 
 type PublishRes struct {
-	Success bool   `cbor:"success,"`
-	Code    string `cbor:"code,"`
-	Message string `cbor:"message,"`
+	Success bool         `cbor:"success," json:"success"`
+	Code    string       `cbor:"code," json:"code"`
+	Message string       `cbor:"message," json:"message"`
+	Errors  []FieldError `cbor:"errors," json:"errors"`
+}
+
+// FieldError describes the validation failure of a field
+type FieldError struct {
+	Field   string `cbor:"field," json:"field"`
+	Message string `cbor:"msg," json:"msg"`
 }
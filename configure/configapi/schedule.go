@@ -0,0 +1,57 @@
+package configapi
+
+import (
+	"errors"
+)
+
+var (
+	ErrInvalidSchedule = errors.New("invalid schedule")
+)
+
+// IsScheduled checks whether the visible configuration changes over time
+func (c *Configuration) IsScheduled() bool {
+	return c.Fallback != nil || c.ExpiryTime > 0
+}
+
+// LatestVersion returns the greatest version the configuration will have, ExpiryVersion if expiry is set
+func (c *Configuration) LatestVersion() string {
+	if c.ExpiryTime > 0 {
+		return c.ExpiryVersion
+	}
+	return c.Version
+}
+
+// EffectiveAt returns the configuration visible at the unix timestamp in second, nil if none is visible.
+// The returned configuration never carries Fallback.
+func (c *Configuration) EffectiveAt(now int64) *Configuration {
+	if c.Timestamp > now {
+		return c.Fallback
+	}
+	if c.ExpiryTime > 0 && now >= c.ExpiryTime {
+		if c.Fallback == nil {
+			return nil
+		}
+		r := *c.Fallback
+		r.Version = c.ExpiryVersion
+		r.Timestamp = c.ExpiryTime
+		r.Batch = nil
+		return &r
+	}
+	if c.Fallback == nil {
+		return c
+	}
+	r := *c
+	r.Fallback = nil
+	return &r
+}
+
+// NextTransition returns the next unix timestamp in second when the visible configuration changes, 0 = no more changes
+func (c *Configuration) NextTransition(now int64) int64 {
+	if c.Timestamp > now {
+		return c.Timestamp
+	}
+	if c.ExpiryTime > now {
+		return c.ExpiryTime
+	}
+	return 0
+}
@@ -0,0 +1,36 @@
+package configapi
+
+import (
+	"testing"
+)
+
+func TestConfiguration_EffectiveAt(t *testing.T) {
+	fallback := &Configuration{Group: "g", Key: "k", Version: "v1", Value: []byte("old"), Timestamp: 10}
+	cfg := &Configuration{Group: "g", Key: "k", Version: "v2", Value: []byte("new"), Timestamp: 100,
+		ExpiryTime: 200, ExpiryVersion: "v3", Fallback: fallback}
+
+	if v := cfg.EffectiveAt(50); v != fallback {
+		t.Fatal("fallback expected before effective time")
+	}
+	if cfg.NextTransition(50) != 100 {
+		t.Fatal("next transition should be the effective time")
+	}
+	if v := cfg.EffectiveAt(100); v.Version != "v2" || v.Fallback != nil || cfg.Fallback == nil {
+		t.Fatal("scheduled configuration expected without fallback:", v)
+	}
+	if cfg.NextTransition(100) != 200 {
+		t.Fatal("next transition should be the expiry time")
+	}
+	if v := cfg.EffectiveAt(200); v.Version != "v3" || string(v.Value) != "old" || v.Timestamp != 200 {
+		t.Fatal("reverted configuration expected:", v)
+	}
+	if cfg.NextTransition(200) != 0 || cfg.LatestVersion() != "v3" {
+		t.Fatal("no more transitions expected")
+	}
+
+	// without fallback, the configuration is invisible before effective time
+	cfg = &Configuration{Version: "v1", Timestamp: 100}
+	if cfg.EffectiveAt(50) != nil || cfg.EffectiveAt(100) != cfg || cfg.IsScheduled() {
+		t.Fatal("unexpected visible configuration")
+	}
+}
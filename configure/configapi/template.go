@@ -0,0 +1,154 @@
+package configapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Placeholder kinds of the configuration templates
+const (
+	// PlaceholderReference is ${group/key} referencing another configuration of the same tenant and selectors
+	PlaceholderReference = "reference"
+	// PlaceholderSelector is ${selector.name} referencing a selector value of the configuration
+	PlaceholderSelector = "selector"
+	// PlaceholderSecret is ${secret:name} referencing a secret resolved through the SecretResolver
+	PlaceholderSecret = "secret"
+)
+
+// RenditionSeparator separates the version of the template and the digest of the rendered value in the version of
+// the rendered configuration, e.g. v1#0a1b2c3d4e5f6a7b
+const RenditionSeparator = "#"
+
+var (
+	ErrInvalidTemplate = errors.New("invalid template")
+)
+
+// SecretResolver resolves the secrets referenced by ${secret:name} in the configuration templates
+type SecretResolver interface {
+	ResolveSecret(name string) (string, error)
+}
+
+// Placeholder is a parsed ${...} in the template value
+type Placeholder struct {
+	Kind string
+	// Group and Key are set for PlaceholderReference
+	Group string
+	Key   string
+	// Name is the selector name of PlaceholderSelector or the secret name of PlaceholderSecret
+	Name string
+}
+
+func (p Placeholder) String() string {
+	switch p.Kind {
+	case PlaceholderReference:
+		return "${" + p.Group + "/" + p.Key + "}"
+	case PlaceholderSelector:
+		return "${selector." + p.Name + "}"
+	default:
+		return "${secret:" + p.Name + "}"
+	}
+}
+
+func parsePlaceholder(s string) (Placeholder, error) {
+	if name, ok := strings.CutPrefix(s, "selector."); ok {
+		if !IsValidName(name) {
+			return Placeholder{}, fmt.Errorf("%w: invalid selector name '%s'", ErrInvalidTemplate, name)
+		}
+		return Placeholder{Kind: PlaceholderSelector, Name: name}, nil
+	}
+	if name, ok := strings.CutPrefix(s, "secret:"); ok {
+		if !IsValidName(name) {
+			return Placeholder{}, fmt.Errorf("%w: invalid secret name '%s'", ErrInvalidTemplate, name)
+		}
+		return Placeholder{Kind: PlaceholderSecret, Name: name}, nil
+	}
+	group, key, ok := strings.Cut(s, "/")
+	if !ok || !IsValidName(group) || !IsValidName(key) {
+		return Placeholder{}, fmt.Errorf("%w: invalid placeholder '${%s}'", ErrInvalidTemplate, s)
+	}
+	return Placeholder{Kind: PlaceholderReference, Group: group, Key: key}, nil
+}
+
+// walkTemplate splits the template into literals and placeholders. $${ is the escape of a literal ${.
+func walkTemplate(value []byte, literal func([]byte), placeholder func(Placeholder) error) error {
+	for {
+		idx := bytes.Index(value, []byte("${"))
+		if idx < 0 {
+			literal(value)
+			return nil
+		}
+		if idx > 0 && value[idx-1] == '$' {
+			literal(value[:idx-1])
+			literal([]byte("${"))
+			value = value[idx+2:]
+			continue
+		}
+		literal(value[:idx])
+		end := bytes.IndexByte(value[idx+2:], '}')
+		if end < 0 {
+			return fmt.Errorf("%w: unclosed placeholder at %d", ErrInvalidTemplate, idx)
+		}
+		p, err := parsePlaceholder(string(value[idx+2 : idx+2+end]))
+		if err != nil {
+			return err
+		}
+		if err := placeholder(p); err != nil {
+			return err
+		}
+		value = value[idx+2+end+1:]
+	}
+}
+
+// ParseTemplate returns the placeholders of the template value
+func ParseTemplate(value []byte) ([]Placeholder, error) {
+	var result []Placeholder
+	err := walkTemplate(value, func([]byte) {}, func(p Placeholder) error {
+		result = append(result, p)
+		return nil
+	})
+	return result, err
+}
+
+// RenderTemplate replaces the placeholders of the template value with the results of resolve
+func RenderTemplate(value []byte, resolve func(p Placeholder) (string, error)) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(value)))
+	err := walkTemplate(value, func(b []byte) {
+		buf.Write(b)
+	}, func(p Placeholder) error {
+		v, err := resolve(p)
+		if err != nil {
+			return fmt.Errorf("resolve %s: %w", p, err)
+		}
+		buf.WriteString(v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderedVersion returns the version of the rendered configuration from the versions of the inputs, so that the
+// changes of the referenced values result in new versions while the version of the template stays the same. The
+// rendered value is not digested since it may contain secrets.
+func RenderedVersion(version string, inputs []string) string {
+	h := sha256.New()
+	for _, v := range inputs {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return version + RenditionSeparator + hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// SplitRenderedVersion returns the version of the template and the digest of the rendered value, or the version
+// itself and empty digest if it is not a rendered version
+func SplitRenderedVersion(version string) (string, string) {
+	if idx := strings.LastIndex(version, RenditionSeparator); idx >= 0 {
+		return version[:idx], version[idx+len(RenditionSeparator):]
+	}
+	return version, ""
+}
@@ -0,0 +1,70 @@
+package configapi
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseTemplate(t *testing.T) {
+	placeholders, err := ParseTemplate([]byte("url=jdbc://${selector.dc}.db/${common/dbname}?p=${secret:db-pass}&raw=$${literal}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Placeholder{
+		{Kind: PlaceholderSelector, Name: "dc"},
+		{Kind: PlaceholderReference, Group: "common", Key: "dbname"},
+		{Kind: PlaceholderSecret, Name: "db-pass"},
+	}
+	if len(placeholders) != len(expected) {
+		t.Fatal("unexpected placeholders:", placeholders)
+	}
+	for i := range expected {
+		if placeholders[i] != expected[i] {
+			t.Fatal("unexpected placeholder:", placeholders[i])
+		}
+	}
+
+	for _, v := range []string{"${unclosed", "${}", "${group}", "${selector.}", "${secret:a b}", "${a/b/c}"} {
+		if _, err := ParseTemplate([]byte(v)); !errors.Is(err, ErrInvalidTemplate) {
+			t.Fatal("invalid template should be rejected:", v, err)
+		}
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	values := map[Placeholder]string{
+		{Kind: PlaceholderSelector, Name: "dc"}:                      "dc1",
+		{Kind: PlaceholderReference, Group: "common", Key: "dbname"}: "orders",
+	}
+	resolve := func(p Placeholder) (string, error) {
+		v, ok := values[p]
+		if !ok {
+			return "", errors.New("not found")
+		}
+		return v, nil
+	}
+	result, err := RenderTemplate([]byte("jdbc://${selector.dc}.db/${common/dbname} $${kept}"), resolve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != "jdbc://dc1.db/orders ${kept}" {
+		t.Fatal("unexpected result:", string(result))
+	}
+	if _, err := RenderTemplate([]byte("${common/missing}"), resolve); err == nil {
+		t.Fatal("unresolved placeholder should fail")
+	}
+}
+
+func TestRenderedVersion(t *testing.T) {
+	v1 := RenderedVersion("v1", []string{"${common/db}=v1"})
+	if v1 == RenderedVersion("v1", []string{"${common/db}=v2"}) || v1 != RenderedVersion("v1", []string{"${common/db}=v1"}) {
+		t.Fatal("renditions should be versioned by the inputs")
+	}
+	version, digest := SplitRenderedVersion(v1)
+	if version != "v1" || len(digest) != 16 {
+		t.Fatal("unexpected split:", version, digest)
+	}
+	if version, digest := SplitRenderedVersion("v2"); version != "v2" || digest != "" {
+		t.Fatal("unexpected split:", version, digest)
+	}
+}
@@ -0,0 +1,63 @@
+package configapi
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultTenant is the tenant of the configurations when no tenant is declared on the server
+const DefaultTenant = ""
+
+// MaxTenantLength is aligned with the storage
+const MaxTenantLength = 200
+
+var (
+	ErrTenantQuotaExceeded = errors.New("tenant quota exceeded")
+)
+
+// TenantQuota limits the resources of a tenant. 0 = unlimited.
+type TenantQuota struct {
+	// MaxConfigurations is the max number of configurations across all selector combinations
+	MaxConfigurations int `json:"max_configurations"`
+	// MaxValueSize is the max size in bytes of the configuration value, bounded by the server wide limit
+	MaxValueSize int `json:"max_value_size"`
+	// MaxListeners is the max number of concurrent long polling requests
+	MaxListeners int `json:"max_listeners"`
+}
+
+// Tenant isolates the configurations of a business unit. The configurations of a tenant are only visible to the
+// requests carrying the credentials of the tenant.
+type Tenant struct {
+	// Name should consist of [A-Za-z0-9_.-], while DefaultTenant(empty) is allowed for the existing configurations
+	Name string `json:"name"`
+	// ReadTokens are the bearer tokens allowed for the read api
+	ReadTokens []string `json:"read_tokens"`
+	// WriteTokens are the bearer tokens allowed for both the read api and the write api
+	WriteTokens []string    `json:"write_tokens"`
+	Quota       TenantQuota `json:"quota"`
+}
+
+// ValidateTenants checks the names of the tenants and the uniqueness of the names and the tokens
+func ValidateTenants(tenants []Tenant) error {
+	names := map[string]struct{}{}
+	tokens := map[string]struct{}{}
+	for _, t := range tenants {
+		if t.Name != DefaultTenant && !IsValidName(t.Name) || len(t.Name) > MaxTenantLength {
+			return fmt.Errorf("invalid tenant name: %s", t.Name)
+		}
+		if _, ok := names[t.Name]; ok {
+			return fmt.Errorf("duplicated tenant: %s", t.Name)
+		}
+		names[t.Name] = struct{}{}
+		for _, token := range append(append([]string(nil), t.ReadTokens...), t.WriteTokens...) {
+			if token == "" {
+				return fmt.Errorf("empty token of tenant: %s", t.Name)
+			}
+			if _, ok := tokens[token]; ok {
+				return fmt.Errorf("duplicated token of tenant: %s", t.Name)
+			}
+			tokens[token] = struct{}{}
+		}
+	}
+	return nil
+}
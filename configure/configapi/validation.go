@@ -0,0 +1,71 @@
+package configapi
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Size limits of the configuration fields which are aligned with the storage
+const (
+	MaxGroupLength     = 200
+	MaxKeyLength       = 200
+	MaxVersionLength   = 200
+	MaxSelectorsLength = 1000
+)
+
+// ValueSchema is the JSON schema that the value of the configuration [group, key] of the tenant must satisfy while
+// publishing
+type ValueSchema struct {
+	// Tenant is DefaultTenant(empty) if no tenant is declared on the server
+	Tenant string          `json:"tenant"`
+	Group  string          `json:"group"`
+	Key    string          `json:"key"`
+	Schema json.RawMessage `json:"schema"`
+}
+
+// IsValidName checks whether the string is non-empty and consists of [A-Za-z0-9_.-] only.
+// This is the rule of group, key and the fields of selectors.
+func IsValidName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '_', c == '-', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateIdentification checks the character rules and size limits of the identification fields of the configuration
+func ValidateIdentification(group, key, version string, selectors, optSelectors *Selectors) []FieldError {
+	var errs []FieldError
+	checkName := func(field, value string, maxLen int) {
+		if !IsValidName(value) {
+			errs = append(errs, FieldError{Field: field, Message: "should be non-empty and consist of [A-Za-z0-9_.-]"})
+		} else if len(value) > maxLen {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf("exceeds max length %d", maxLen)})
+		}
+	}
+	checkName("group", group, MaxGroupLength)
+	checkName("key", key, MaxKeyLength)
+	if len(version) > MaxVersionLength {
+		errs = append(errs, FieldError{Field: "version", Message: fmt.Sprintf("exceeds max length %d", MaxVersionLength)})
+	}
+	checkSelectors := func(field string, s *Selectors) {
+		for k, v := range s.Data {
+			if !IsValidName(k) || !IsValidName(v) {
+				errs = append(errs, FieldError{Field: field, Message: "selector '" + k + "=" + v + "' should be non-empty and consist of [A-Za-z0-9_.-]"})
+			}
+		}
+		if l := len(SelectorsHelperCacheValue(s)); l > MaxSelectorsLength {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf("exceeds max length %d", MaxSelectorsLength)})
+		}
+	}
+	checkSelectors("selectors", selectors)
+	checkSelectors("opt_selectors", optSelectors)
+	return errs
+}
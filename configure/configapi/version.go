@@ -1,5 +1,39 @@
 package configapi
 
+import "errors"
+
+var (
+	ErrVersionNotIncreased = errors.New("version is not greater than the current one")
+	ErrInvalidVersion      = errors.New("invalid version")
+)
+
 type VersionComparator interface {
 	HasUpdate(requestVersion, dataVersion string) bool
 }
+
+// VersionValidator is optionally implemented by VersionComparator which validates the published versions.
+// The version to be published should be valid and strictly greater than the current one if implemented.
+type VersionValidator interface {
+	// Compare returns a negative number when v1 < v2, a positive number when v1 > v2, otherwise 0
+	Compare(v1, v2 string) int
+	// ValidateVersion checks the format of the version to be published
+	ValidateVersion(version string) error
+}
+
+// VersionGenerator is optionally implemented by VersionComparator which derives the version from the configuration itself.
+// The version in the publish request will be replaced by the generated one.
+type VersionGenerator interface {
+	// GenerateVersion generates the version of cfg published over the current version which is empty if none.
+	// DataWriter generates the version again with the current version while saving.
+	GenerateVersion(cfg *Configuration, current string) string
+}
+
+// GenerateVersions replaces Version and ExpiryVersion of the configuration published over the current version.
+func GenerateVersions(generator VersionGenerator, cfg *Configuration, current string) {
+	cfg.Version = generator.GenerateVersion(cfg, current)
+	if cfg.ExpiryTime > 0 {
+		expired := *cfg
+		expired.Timestamp = cfg.ExpiryTime
+		cfg.ExpiryVersion = generator.GenerateVersion(&expired, cfg.Version)
+	}
+}
@@ -0,0 +1,35 @@
+package configclient
+
+import (
+	"github.com/BurntSushi/toml"
+	"github.com/fxamacker/cbor/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// RegisterYamlContainer will register auto updated configure container with yaml configure support
+// Struct fields are mapped via `yaml` tags.
+// Note: the behavior is the same as Register method
+func (c *ClientAdv[T]) RegisterYamlContainer(group, key string) (*ConfigContainer[T], error) {
+	return c.Register(group, key, yaml.Unmarshal)
+}
+
+// RegisterTomlContainer will register auto updated configure container with toml configure support
+// Struct fields are mapped via `toml` tags.
+// Note: the behavior is the same as Register method
+func (c *ClientAdv[T]) RegisterTomlContainer(group, key string) (*ConfigContainer[T], error) {
+	return c.Register(group, key, toml.Unmarshal)
+}
+
+// RegisterPropertiesContainer will register auto updated configure container with java style properties and ini configure support
+// Struct fields are mapped via `properties` tags. Refer to UnmarshalProperties for details.
+// Note: the behavior is the same as Register method
+func (c *ClientAdv[T]) RegisterPropertiesContainer(group, key string) (*ConfigContainer[T], error) {
+	return c.Register(group, key, UnmarshalProperties)
+}
+
+// RegisterCborContainer will register auto updated configure container with cbor configure support
+// Struct fields are mapped via `cbor` tags.
+// Note: the behavior is the same as Register method
+func (c *ClientAdv[T]) RegisterCborContainer(group, key string) (*ConfigContainer[T], error) {
+	return c.Register(group, key, cbor.Unmarshal)
+}
@@ -0,0 +1,99 @@
+package configclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/fxamacker/cbor/v2"
+	"gopkg.in/yaml.v3"
+)
+
+type FormatTestStruct struct {
+	Name    string        `yaml:"name" toml:"name" cbor:"name" properties:"app.name"`
+	Port    int           `yaml:"port" toml:"port" cbor:"port" properties:"server.port" default:"8080"`
+	Timeout time.Duration `yaml:"timeout" toml:"timeout" cbor:"timeout" properties:"server.timeout" default:"3s"`
+	Tags    []string      `yaml:"tags" toml:"tags" cbor:"tags" properties:"tags"`
+	Db      struct {
+		Host string `yaml:"host" toml:"host" cbor:"host" properties:"host" default:"localhost"`
+	} `yaml:"db" toml:"db" cbor:"db" properties:"db"`
+	Extra map[string]string `yaml:"extra" toml:"extra" cbor:"extra" properties:"extra"`
+}
+
+func decodeWithDefaults(t *testing.T, unmarshaler Unmarshaler, data []byte) *FormatTestStruct {
+	inst, err := newStructPtrWithDefaults(getStructType(new(FormatTestStruct)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unmarshaler(data, inst); err != nil {
+		t.Fatal(err)
+	}
+	return inst.(*FormatTestStruct)
+}
+
+func checkFormatTestStruct(t *testing.T, s *FormatTestStruct) {
+	if s.Name != "demo" {
+		t.Fatal("unexpected name:", s.Name)
+	}
+	if s.Port != 8080 || s.Timeout != 3*time.Second {
+		t.Fatal("default values expected:", s.Port, s.Timeout)
+	}
+	if len(s.Tags) != 2 || s.Tags[1] != "b" {
+		t.Fatal("unexpected tags:", s.Tags)
+	}
+	if s.Db.Host != "db.local" {
+		t.Fatal("unexpected db host:", s.Db.Host)
+	}
+}
+
+func TestUnmarshalProperties(t *testing.T) {
+	data := `
+# comment
+! comment
+app.name = demo
+tags: a, \
+      b
+[db]
+host=db.local
+; ini comment
+[extra]
+unicode = 你好
+key\=1 value 1
+`
+	s := decodeWithDefaults(t, UnmarshalProperties, []byte(data))
+	checkFormatTestStruct(t, s)
+	if s.Extra["unicode"] != "你好" || s.Extra["key=1"] != "value 1" {
+		t.Fatal("unexpected extra:", s.Extra)
+	}
+
+	if err := UnmarshalProperties([]byte("server.port=abc"), new(FormatTestStruct)); err == nil {
+		t.Fatal("invalid port should fail")
+	}
+	if err := UnmarshalProperties([]byte("[db"), new(FormatTestStruct)); err == nil {
+		t.Fatal("unclosed section should fail")
+	}
+}
+
+func TestUnmarshalYamlTomlCbor(t *testing.T) {
+	checkFormatTestStruct(t, decodeWithDefaults(t, yaml.Unmarshal, []byte("name: demo\ntags: [a, b]\ndb:\n  host: db.local\n")))
+	checkFormatTestStruct(t, decodeWithDefaults(t, toml.Unmarshal, []byte("name = \"demo\"\ntags = [\"a\", \"b\"]\n[db]\nhost = \"db.local\"\n")))
+
+	data, err := cbor.Marshal(map[string]any{
+		"name": "demo",
+		"tags": []string{"a", "b"},
+		"db":   map[string]string{"host": "db.local"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFormatTestStruct(t, decodeWithDefaults(t, cbor.Unmarshal, data))
+}
+
+func TestApplyDefaults_InvalidDefault(t *testing.T) {
+	type invalidDefault struct {
+		Port int `default:"abc"`
+	}
+	if _, err := newStructPtrWithDefaults(getStructType(new(invalidDefault))); err == nil {
+		t.Fatal("invalid default value should fail")
+	}
+}
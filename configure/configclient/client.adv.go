@@ -3,19 +3,39 @@ package configclient
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"reflect"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/meidoworks/nekoq-component/configure/configapi"
 )
 
+var (
+	ErrUnmarshalConfiguration = errors.New("unmarshal configuration failed")
+	ErrValidateConfiguration  = errors.New("validate configuration failed")
+)
+
 type Unmarshaler func([]byte, interface{}) error
 
+// Validator is implemented by the configure container type to check the configuration before applying
+type Validator interface {
+	Validate() error
+}
+
 type ClientAdv[T any] struct {
 	c *Client
 
 	// OnChange is called when any update on the configuration
 	OnChange func(cfg configapi.Configuration, container T)
+	// OnError is called when an update on the configuration is rejected, the container keeps the last applied value
+	OnError func(cfg configapi.Configuration, err error)
+	// Validator is called after unmarshalling the configuration and after Validate method if T implements Validator
+	Validator func(container T) error
+	// OnDelete is called when the configuration is deleted on the server.
+	// protected is true if the last applied value is kept according to ClientOptions.DeletionPolicy.
+	OnDelete func(key configapi.RequestedConfigurationKey, protected bool)
 }
 
 func NewClientAdv[T any](c *Client) *ClientAdv[T] {
@@ -30,17 +50,27 @@ func (c *ClientAdv[T]) RegisterJsonContainer(group, key string) (*ConfigContaine
 
 // Register will register auto updated configure container with the same type as the container provided
 // Note that any further update has to be accessed via responded container rather than the container provided in the parameter
+// Note2: fields with `default` tags are filled with the default values before unmarshalling, so they keep the default values if absent in the configuration
 func (c *ClientAdv[T]) Register(group, key string, unmarshaler Unmarshaler) (*ConfigContainer[T], error) {
 	var dummy T
 	if !checkStructPtr(dummy) {
 		return nil, errors.New("container parameter should be '*struct' type")
 	}
 	structType := getStructType(dummy)
+	initInst, err := newStructPtrWithDefaults(structType)
+	if err != nil {
+		return nil, err
+	}
 	val := new(atomic.Value)
-	val.Store(newStructPtr(structType))
+	val.Store(initInst)
 	result := new(ConfigContainer[T])
 	result.val = val
+	result.unmarshaler = unmarshaler
 	result.OnChange = c.OnChange
+	result.OnError = c.OnError
+	result.OnDelete = c.OnDelete
+	policy := c.c.opt.DeletionPolicy
+	validator := c.Validator
 	c.c.suspend()
 	defer c.c.resume()
 
@@ -49,36 +79,124 @@ func (c *ClientAdv[T]) Register(group, key string, unmarshaler Unmarshaler) (*Co
 			Group: group,
 			Key:   key,
 		},
-		Callback: func(cfg configapi.Configuration) {
-			newInst := newStructPtr(structType)
-			if err := unmarshaler(cfg.Value, newInst); err != nil {
-				//FIXME need better solution to alert callback error
-				// guarantee changes are applied successfully before start using container as configure
-				c.c.logError("unmarshal ClientAdv change failed", err)
+		ApplyCallback: func(cfg configapi.Configuration) error {
+			// default values have been checked while registering
+			newInst, _ := newStructPtrWithDefaults(structType)
+			err := unmarshaler(cfg.Value, newInst)
+			if err != nil {
+				err = fmt.Errorf("%w: %w", ErrUnmarshalConfiguration, err)
 			} else {
-				val.Store(newInst)
+				err = validateContainer(newInst.(T), validator)
+			}
+			if err != nil {
+				// guarantee only validated changes are applied, the last applied value is kept
+				result.failure.Store(&UpdateFailure{
+					Group:   cfg.Group,
+					Key:     cfg.Key,
+					Version: cfg.Version,
+					Err:     err,
+					Time:    time.Now(),
+				})
+				if onerror := result.OnError; onerror != nil {
+					onerror(cfg, err)
+				}
+				return err
 			}
+			oldInst := val.Swap(newInst)
+			result.raw.Store(&cfg.Value)
+			result.failure.Store(nil)
+			result.deleted.Store(false)
 			onchange := result.OnChange
 			if onchange != nil {
 				onchange(cfg, newInst.(T))
 			}
+			result.notifyChanges(oldInst.(T), newInst.(T))
+			return nil
+		},
+		DeleteCallback: func(key configapi.RequestedConfigurationKey) {
+			result.deleted.Store(true)
+			result.failure.Store(nil)
+			protected := policy != DeletionPolicyReset
+			if !protected {
+				newInst, _ := newStructPtrWithDefaults(structType)
+				oldInst := val.Swap(newInst)
+				result.raw.Store(nil)
+				result.notifyChanges(oldInst.(T), newInst.(T))
+			}
+			if ondelete := result.OnDelete; ondelete != nil {
+				ondelete(key, protected)
+			}
 		},
 	})
 
 	return result, nil
 }
 
+func validateContainer[T any](container T, validator func(container T) error) error {
+	if v, ok := any(container).(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("%w: %w", ErrValidateConfiguration, err)
+		}
+	}
+	if validator != nil {
+		if err := validator(container); err != nil {
+			return fmt.Errorf("%w: %w", ErrValidateConfiguration, err)
+		}
+	}
+	return nil
+}
+
 // ConfigContainer contains the configuration retrieved from the server with auto refresh support
 type ConfigContainer[T any] struct {
-	val *atomic.Value
+	val     *atomic.Value
+	failure atomic.Pointer[UpdateFailure]
+	deleted atomic.Bool
+	// raw is the value of the last applied configuration, nil before the first update
+	raw         atomic.Pointer[[]byte]
+	unmarshaler Unmarshaler
 	// OnChange is called when any update on the configuration
 	OnChange func(cfg configapi.Configuration, container T)
+	// OnError is called when an update on the configuration is rejected
+	OnError func(cfg configapi.Configuration, err error)
+	// OnDelete is called when the configuration is deleted on the server
+	OnDelete func(key configapi.RequestedConfigurationKey, protected bool)
+	// OnDiff is called after OnChange with the previous value and the paths of the changed leaf fields, e.g. Pool.MaxConns
+	// It is not called if no field is changed.
+	OnDiff func(old, new T, changed []string)
+
+	watchLock sync.Mutex
+	watchers  []*fieldWatcher
 }
 
 func (cc *ConfigContainer[T]) Get() T {
 	return cc.val.Load().(T)
 }
 
+// decodeRaw decodes the last applied configuration without the default values, so that only the fields present in the
+// configuration are non-zero. It returns false before the first update is applied.
+func (cc *ConfigContainer[T]) decodeRaw() (T, bool, error) {
+	var result T
+	raw := cc.raw.Load()
+	if raw == nil {
+		return result, false, nil
+	}
+	inst := newStructPtr(getStructType(result))
+	if err := cc.unmarshaler(*raw, inst); err != nil {
+		return result, false, err
+	}
+	return inst.(T), true, nil
+}
+
+// Failure returns the information of the latest rejected update, or nil if the latest update is applied
+func (cc *ConfigContainer[T]) Failure() *UpdateFailure {
+	return cc.failure.Load()
+}
+
+// Deleted returns true if the configuration has been deleted on the server and not recreated yet
+func (cc *ConfigContainer[T]) Deleted() bool {
+	return cc.deleted.Load()
+}
+
 func getSliceItemType(slice any) reflect.Type {
 	return reflect.TypeOf(slice).Elem()
 }
@@ -116,3 +234,11 @@ func getStructType(c any) reflect.Type {
 func newStructPtr(st reflect.Type) any {
 	return reflect.New(st).Interface()
 }
+
+func newStructPtrWithDefaults(st reflect.Type) (any, error) {
+	ptr := reflect.New(st)
+	if err := applyDefaults(ptr.Elem()); err != nil {
+		return nil, err
+	}
+	return ptr.Interface(), nil
+}
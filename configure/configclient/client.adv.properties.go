@@ -0,0 +1,200 @@
+package configclient
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidPropertiesLine = errors.New("invalid properties line")
+)
+
+// UnmarshalProperties decodes java style properties or ini data into *struct
+//
+// Format:
+//  1. 'key=value', 'key: value' or 'key value' per line, '#', '!' and ';' start a comment line
+//  2. A line ending with '\' continues on the next line
+//  3. Escapes: \t \n \r \f \uXXXX and escaped separators
+//  4. '[section]' prefixes the following keys as 'section.key'
+//
+// Mapping:
+//  1. Field name is from `properties` tag, otherwise the lowercase field name. "-" skips the field.
+//  2. Nested struct field maps keys with the prefix 'name.'
+//  3. map[string]string field collects all keys with the prefix 'name.'
+//  4. Slice field is split by ','
+func UnmarshalProperties(data []byte, v interface{}) error {
+	props, err := parseProperties(string(data))
+	if err != nil {
+		return err
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("properties container should be non-nil '*struct' type")
+	}
+	return decodeProperties(props, "", rv.Elem())
+}
+
+func parseProperties(data string) (map[string]string, error) {
+	props := map[string]string{}
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+	var section string
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimLeft(lines[i], " \t\f")
+		if line == "" || line[0] == '#' || line[0] == '!' || line[0] == ';' {
+			continue
+		}
+		// join continuation lines
+		for endsWithContinuation(line) && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + strings.TrimLeft(lines[i], " \t\f")
+		}
+		line = strings.TrimRight(line, " \t\f")
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return nil, fmt.Errorf("%w: line %d: unclosed section", ErrInvalidPropertiesLine, lineNo)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		key, value := splitPropertiesLine(line)
+		key, err := unescapeProperties(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrInvalidPropertiesLine, lineNo, err)
+		}
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		value, err = unescapeProperties(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrInvalidPropertiesLine, lineNo, err)
+		}
+		if section != "" {
+			key = section + "." + key
+		}
+		props[key] = value
+	}
+	return props, nil
+}
+
+func endsWithContinuation(line string) bool {
+	cnt := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		cnt++
+	}
+	return cnt%2 == 1
+}
+
+func splitPropertiesLine(line string) (string, string) {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++ // skip escaped char
+		case '=', ':':
+			return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		case ' ', '\t', '\f':
+			key := line[:i]
+			rest := strings.TrimLeft(line[i:], " \t\f")
+			if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+				rest = rest[1:]
+			}
+			return key, strings.TrimSpace(rest)
+		}
+	}
+	return line, ""
+}
+
+func unescapeProperties(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	buf := new(strings.Builder)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 >= len(s) {
+			buf.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			buf.WriteByte('\t')
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 'f':
+			buf.WriteByte('\f')
+		case 'u':
+			if i+5 > len(s) {
+				return "", errors.New("invalid unicode escape")
+			}
+			r, err := strconv.ParseUint(s[i+1:i+5], 16, 32)
+			if err != nil {
+				return "", errors.New("invalid unicode escape")
+			}
+			buf.WriteRune(rune(r))
+			i += 4
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+	if !utf8.ValidString(buf.String()) {
+		return "", errors.New("invalid utf8 string")
+	}
+	return buf.String(), nil
+}
+
+func propertiesFieldName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	name := field.Tag.Get("properties")
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name, true
+}
+
+func decodeProperties(props map[string]string, prefix string, sv reflect.Value) error {
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		name, ok := propertiesFieldName(st.Field(i))
+		if !ok {
+			continue
+		}
+		fullKey := prefix + name
+		fv := sv.Field(i)
+		switch {
+		case isNestedStruct(fv.Type()):
+			if err := decodeProperties(props, fullKey+".", fv); err != nil {
+				return err
+			}
+		case fv.Kind() == reflect.Map && fv.Type().Key().Kind() == reflect.String && fv.Type().Elem().Kind() == reflect.String:
+			mapPrefix := fullKey + "."
+			for k, v := range props {
+				if strings.HasPrefix(k, mapPrefix) {
+					if fv.IsNil() {
+						fv.Set(reflect.MakeMap(fv.Type()))
+					}
+					fv.SetMapIndex(reflect.ValueOf(strings.TrimPrefix(k, mapPrefix)), reflect.ValueOf(v))
+				}
+			}
+		default:
+			if v, ok := props[fullKey]; ok {
+				if err := setFieldFromString(fv, v); err != nil {
+					return fmt.Errorf("property %s: %w", fullKey, err)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package configclient
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var (
+	ErrInvalidWatchPath     = errors.New("invalid watch path")
+	ErrInvalidWatchCallback = errors.New("invalid watch callback")
+)
+
+type fieldWatcher struct {
+	path []string
+	fn   func(old, new reflect.Value)
+}
+
+// Watch subscribes the changes of the field specified by the path of the container, e.g. "Pool.MaxConns".
+// V should be the type of the field, e.g. func(old, new int), otherwise ErrInvalidWatchCallback is returned.
+// The callback is called only when the field changes after an update is applied, including the first update compared to the initial value.
+func Watch[T any, V any](cc *ConfigContainer[T], path string, callback func(old, new V)) error {
+	var dummy T
+	fieldType, err := fieldTypeByPath(reflect.TypeOf(dummy).Elem(), path)
+	if err != nil {
+		return err
+	}
+	if reflect.TypeFor[V]() != fieldType {
+		return fmt.Errorf("%w: func(old, new %s) expected", ErrInvalidWatchCallback, fieldType)
+	}
+	cc.watchLock.Lock()
+	defer cc.watchLock.Unlock()
+	cc.watchers = append(cc.watchers, &fieldWatcher{
+		path: strings.Split(path, "."),
+		fn: func(old, new reflect.Value) {
+			callback(old.Interface().(V), new.Interface().(V))
+		},
+	})
+	return nil
+}
+
+func fieldTypeByPath(st reflect.Type, path string) (reflect.Type, error) {
+	if path == "" {
+		return nil, ErrInvalidWatchPath
+	}
+	t := st
+	for _, name := range strings.Split(path, ".") {
+		if t.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("%w: %s is not a struct field", ErrInvalidWatchPath, path)
+		}
+		f, ok := t.FieldByName(name)
+		if !ok || !f.IsExported() {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidWatchPath, path)
+		}
+		t = f.Type
+	}
+	return t, nil
+}
+
+func fieldByPath(sv reflect.Value, path []string) reflect.Value {
+	for _, name := range path {
+		sv = sv.FieldByName(name)
+	}
+	return sv
+}
+
+// notifyChanges calls OnDiff and watchers with the previous and the new applied values
+func (cc *ConfigContainer[T]) notifyChanges(old, new T) {
+	ov := reflect.ValueOf(old).Elem()
+	nv := reflect.ValueOf(new).Elem()
+	if ondiff := cc.OnDiff; ondiff != nil {
+		// a new version may carry the same value
+		if changed := diffFields(ov, nv); len(changed) > 0 {
+			ondiff(old, new, changed)
+		}
+	}
+
+	cc.watchLock.Lock()
+	watchers := cc.watchers
+	cc.watchLock.Unlock()
+	for _, w := range watchers {
+		o := fieldByPath(ov, w.path)
+		n := fieldByPath(nv, w.path)
+		if !reflect.DeepEqual(o.Interface(), n.Interface()) {
+			w.fn(o, n)
+		}
+	}
+}
+
+// diffFields returns the paths of the leaf fields which are different
+func diffFields(ov, nv reflect.Value) []string {
+	var changed []string
+	walkLeafFields(ov.Type(), func(path string, field reflect.StructField, index []int) {
+		if !reflect.DeepEqual(ov.FieldByIndex(index).Interface(), nv.FieldByIndex(index).Interface()) {
+			changed = append(changed, path)
+		}
+	})
+	return changed
+}
@@ -2,9 +2,12 @@ package configclient
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 	"testing"
 	"time"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
 )
 
 func TestClientAdv_Basic1(t *testing.T) {
@@ -90,3 +93,181 @@ func TestClientAdv_NewStructPtr(t *testing.T) {
 	}
 	t.Log(elem)
 }
+
+type ValidatedTestStruct struct {
+	Port int `json:"port" default:"8080"`
+}
+
+func (v *ValidatedTestStruct) Validate() error {
+	if v.Port <= 0 || v.Port > 65535 {
+		return errors.New("invalid port")
+	}
+	return nil
+}
+
+func TestClientAdv_RejectInvalidUpdate(t *testing.T) {
+	c := NewClient([]string{"http://127.0.0.1:8080"}, ClientOptions{})
+	ca := NewClientAdv[*ValidatedTestStruct](c)
+	var errCnt, changeCnt int
+	ca.OnError = func(cfg configapi.Configuration, err error) {
+		errCnt++
+	}
+	ca.OnChange = func(cfg configapi.Configuration, container *ValidatedTestStruct) {
+		changeCnt++
+	}
+	ca.Validator = func(container *ValidatedTestStruct) error {
+		if container.Port == 22 {
+			return errors.New("reserved port")
+		}
+		return nil
+	}
+	container, err := ca.RegisterJsonContainer("group", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.Get().Port != 8080 {
+		t.Fatal("default value expected before first update")
+	}
+	callback := c.reqCallbacks[GetConfigurationKey(configapi.RequestedConfigurationKey{Group: "group", Key: "key"})]
+
+	if err := callback(configapi.Configuration{Group: "group", Key: "key", Version: "v1", Value: []byte(`{"port":9090}`)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		version string
+		value   string
+		err     error
+	}{
+		{"v2", `{"port":`, ErrUnmarshalConfiguration},
+		{"v3", `{"port":70000}`, ErrValidateConfiguration},
+		{"v4", `{"port":22}`, ErrValidateConfiguration},
+	} {
+		err := callback(configapi.Configuration{Group: "group", Key: "key", Version: v.version, Value: []byte(v.value)})
+		if !errors.Is(err, v.err) {
+			t.Fatal("unexpected error:", err)
+		}
+		if container.Get().Port != 9090 {
+			t.Fatal("last applied value should be kept")
+		}
+		if f := container.Failure(); f == nil || f.Version != v.version {
+			t.Fatal("failed version should be tracked:", f)
+		}
+	}
+	if errCnt != 3 || changeCnt != 1 {
+		t.Fatal("unexpected callback count:", errCnt, changeCnt)
+	}
+
+	if err := callback(configapi.Configuration{Group: "group", Key: "key", Version: "v5", Value: []byte(`{"port":9091}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if container.Failure() != nil || container.Get().Port != 9091 {
+		t.Fatal("failure should be cleared after applied")
+	}
+}
+
+type WatchTestStruct struct {
+	Name string `json:"name"`
+	Pool struct {
+		MaxConns int      `json:"max_conns"`
+		Hosts    []string `json:"hosts"`
+	} `json:"pool"`
+}
+
+func TestClientAdv_Watch(t *testing.T) {
+	c := NewClient([]string{"http://127.0.0.1:8080"}, ClientOptions{})
+	container, err := NewClientAdv[*WatchTestStruct](c).RegisterJsonContainer("group", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var maxConnsChanges [][2]int
+	if err := Watch(container, "Pool.MaxConns", func(old, new int) {
+		maxConnsChanges = append(maxConnsChanges, [2]int{old, new})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var hostsChanged int
+	if err := Watch(container, "Pool.Hosts", func(old, new []string) {
+		hostsChanged++
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var lastChanged []string
+	var diffCount int
+	container.OnDiff = func(old, new *WatchTestStruct, changed []string) {
+		lastChanged = changed
+		diffCount++
+	}
+	if err := Watch(container, "Pool.MaxConns", func(old, new string) {}); !errors.Is(err, ErrInvalidWatchCallback) {
+		t.Fatal("type mismatch expected:", err)
+	}
+	if err := Watch(container, "Pool.Unknown", func(old, new int) {}); !errors.Is(err, ErrInvalidWatchPath) {
+		t.Fatal("invalid path expected:", err)
+	}
+
+	callback := c.reqCallbacks[GetConfigurationKey(configapi.RequestedConfigurationKey{Group: "group", Key: "key"})]
+	for i, v := range []string{
+		`{"name":"a","pool":{"max_conns":10,"hosts":["h1"]}}`,
+		`{"name":"b","pool":{"max_conns":10,"hosts":["h1"]}}`,
+		`{"name":"b","pool":{"max_conns":20,"hosts":["h1","h2"]}}`,
+		`{"name":"b","pool":{"max_conns":20,"hosts":["h1","h2"]}}`,
+	} {
+		if err := callback(configapi.Configuration{Group: "group", Key: "key", Version: fmt.Sprint(i), Value: []byte(v)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(maxConnsChanges) != 2 || maxConnsChanges[0] != [2]int{0, 10} || maxConnsChanges[1] != [2]int{10, 20} {
+		t.Fatal("unexpected max conns changes:", maxConnsChanges)
+	}
+	if hostsChanged != 2 {
+		t.Fatal("unexpected hosts changes:", hostsChanged)
+	}
+	if !reflect.DeepEqual(lastChanged, []string{"Pool.MaxConns", "Pool.Hosts"}) {
+		t.Fatal("unexpected changed fields:", lastChanged)
+	}
+	// the last version carries the same value
+	if diffCount != 3 {
+		t.Fatal("OnDiff should be skipped without changes:", diffCount)
+	}
+}
+
+func TestClientAdv_Delete(t *testing.T) {
+	for _, policy := range []DeletionPolicy{DeletionPolicyProtect, DeletionPolicyReset} {
+		c := NewClient([]string{"http://127.0.0.1:8080"}, ClientOptions{DeletionPolicy: policy})
+		ca := NewClientAdv[*ValidatedTestStruct](c)
+		var deleted []bool
+		ca.OnDelete = func(key configapi.RequestedConfigurationKey, protected bool) {
+			deleted = append(deleted, protected)
+		}
+		container, err := ca.RegisterJsonContainer("group", "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.processResponse(&configapi.AcquireConfigurationRes{
+			Requested: []configapi.Configuration{{Group: "group", Key: "key", Version: "v1", Value: []byte(`{"port":9090}`)}},
+		})
+		c.processResponse(&configapi.AcquireConfigurationRes{
+			Deleted: []configapi.RequestedConfigurationKey{{Group: "group", Key: "key", Version: "v1"}},
+		})
+		if !container.Deleted() || len(deleted) != 1 || deleted[0] != (policy == DeletionPolicyProtect) {
+			t.Fatal("deletion should be notified:", policy, deleted)
+		}
+		if r := c.requests.Requested[0]; !r.Deleted || r.Version != "v1" {
+			t.Fatal("request should wait for recreation:", r)
+		}
+		expected := 9090
+		if policy == DeletionPolicyReset {
+			expected = 8080
+		}
+		if container.Get().Port != expected {
+			t.Fatal("unexpected value after deletion:", policy, container.Get().Port)
+		}
+
+		c.processResponse(&configapi.AcquireConfigurationRes{
+			Requested: []configapi.Configuration{{Group: "group", Key: "key", Version: "v1", Value: []byte(`{"port":9091}`)}},
+		})
+		if container.Deleted() || c.requests.Requested[0].Deleted || container.Get().Port != 9091 {
+			t.Fatal("recreated configuration should be applied")
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package configclient
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// field setters shared by UnmarshalProperties, Bind and the special formats
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// isNestedStruct checks whether the type is a struct which should be processed field by field
+func isNestedStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && !reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+// setFieldFromString sets the field by parsing the string according to the type of the field
+func setFieldFromString(fv reflect.Value, s string) error {
+	if fv.CanAddr() && fv.Addr().Type().Implements(textUnmarshalerType) {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	case reflect.Slice:
+		return setSliceFromString(fv, s, ",")
+	case reflect.Ptr:
+		elem := reflect.New(fv.Type().Elem())
+		if err := setFieldFromString(elem.Elem(), s); err != nil {
+			return err
+		}
+		fv.Set(elem)
+	default:
+		return errors.New("unsupported field type:" + fv.Type().String())
+	}
+	return nil
+}
+
+// setSliceFromString splits the string by the separator and sets the items to the slice field
+func setSliceFromString(fv reflect.Value, s string, sep string) error {
+	var items []string
+	if s = strings.TrimSpace(s); s != "" {
+		items = strings.Split(s, sep)
+	}
+	slice := reflect.MakeSlice(fv.Type(), len(items), len(items))
+	for i, item := range items {
+		if err := setFieldFromString(slice.Index(i), strings.TrimSpace(item)); err != nil {
+			return err
+		}
+	}
+	fv.Set(slice)
+	return nil
+}
+
+// applyDefaults sets the values from `default` tags to the zero value fields of the struct recursively
+func applyDefaults(sv reflect.Value) error {
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fv := sv.Field(i)
+		if isNestedStruct(field.Type) {
+			if err := applyDefaults(fv); err != nil {
+				return err
+			}
+			continue
+		}
+		dv, ok := field.Tag.Lookup("default")
+		if !ok || !fv.IsZero() {
+			continue
+		}
+		if err := setFieldFromString(fv, dv); err != nil {
+			return fmt.Errorf("default value of field %s: %w", field.Name, err)
+		}
+	}
+	return nil
+}
@@ -21,11 +21,39 @@ import (
 
 var (
 	ErrWaitStartupLoadedTimeout = errors.New("wait startup loaded timeout")
+	ErrStartupConfigureRejected = errors.New("startup configuration rejected")
 )
 
 type RequiredConfig struct {
 	Required configapi.RequestedConfigurationKey
 	Callback func(cfg configapi.Configuration)
+	// ApplyCallback is used instead of Callback if provided.
+	// Returning error means the update is rejected: the failure is tracked in FailedUpdates and the callback keeps the
+	// last applied value. The rejected version is recorded as seen, so it is not delivered again until a newer version
+	// is published.
+	ApplyCallback func(cfg configapi.Configuration) error
+	// DeleteCallback is called when the configuration is deleted on the server, the key contains the last version.
+	// The client keeps waiting for the recreation of the configuration.
+	DeleteCallback func(key configapi.RequestedConfigurationKey)
+}
+
+// DeletionPolicy decides how ClientAdv handles the configurations deleted on the server
+type DeletionPolicy int
+
+const (
+	// DeletionPolicyProtect keeps the last applied value in use, default
+	DeletionPolicyProtect DeletionPolicy = iota
+	// DeletionPolicyReset resets the container to the initial value with defaults
+	DeletionPolicyReset
+)
+
+// UpdateFailure is the information of the configuration update rejected by the callback
+type UpdateFailure struct {
+	Group   string
+	Key     string
+	Version string
+	Err     error
+	Time    time.Time
 }
 
 type ClientOptions struct {
@@ -41,11 +69,36 @@ type ClientOptions struct {
 	OverrideSelectors         *configapi.Selectors // used for overriding detail selector config
 	OverrideOptionalSelectors *configapi.Selectors // used for overriding detail selector config
 
+	// Auth is the bearer token of the tenant, required if tenants are declared on the server
 	Auth string
 
 	LocalFallbackDataPath             string
 	AllowedLocalFallbackDataTTL       int64 // In seconds. Compare to last retrieved time rather than configuration timestamp.
 	AcquireFullConfigurationsInterval int64 // Effective > 0 (in seconds). Used for 1. refresh data, 2. keep fallback data fresh
+
+	DeletionPolicy DeletionPolicy
+
+	// WaitTimeForUpdate is the desired wait time in seconds of the long polling, clamped by the server.
+	// It should be shorter than the idle timeout of the load balancers in between. 0 = server default(60s).
+	WaitTimeForUpdate int
+
+	// AcceptEncoding is the compressions accepted for the responses in preferred order, e.g. "zstd,snappy,gzip".
+	// Empty = gzip handled by the http transport.
+	AcceptEncoding string
+	// EnableDelta requests the updated values in binary delta against the last applied values.
+	// The client keeps the last applied values in memory.
+	EnableDelta bool
+	// Hierarchical requests the most specific configurations under the selector hierarchy declared on the server,
+	// falling back to the less specific selector combinations. Delta is disabled in this mode.
+	Hierarchical bool
+}
+
+// httpTimeout returns two times of the wait time, at least 30s
+func (c *ClientOptions) httpTimeout() time.Duration {
+	if c.WaitTimeForUpdate <= 0 {
+		return 2 * 60 * time.Second // two times of default wait time(60s) on server side
+	}
+	return max(2*time.Duration(c.WaitTimeForUpdate)*time.Second, 30*time.Second)
 }
 
 func (c *ClientOptions) ToSelectors() configapi.Selectors {
@@ -96,12 +149,21 @@ type Client struct {
 	lock         sync.Mutex
 	lockRequests atomic.Bool
 	requests     *configapi.AcquireConfigurationReq
-	reqCallbacks map[string]func(cfg configapi.Configuration)
+	reqCallbacks map[string]func(cfg configapi.Configuration) error
+	delCallbacks map[string]func(key configapi.RequestedConfigurationKey)
+	// baseValues contains the last applied configurations for resolving deltas, protected by lock
+	baseValues map[string]configapi.Configuration
+
+	failLock      sync.Mutex
+	failedUpdates map[string]*UpdateFailure // access should be protected by failLock
 
 	client *http.Client
 
 	closeCh       chan struct{}
 	startupLoadCh chan struct{}
+	// startupFailCh is closed when an update is rejected before the startup configure loaded
+	startupFailCh   chan struct{}
+	startupFailOnce sync.Once
 }
 
 func NewClient(serverList []string, opt ClientOptions) *Client {
@@ -109,18 +171,30 @@ func NewClient(serverList []string, opt ClientOptions) *Client {
 		serverLists:   serverList,
 		opt:           opt,
 		requests:      &configapi.AcquireConfigurationReq{},
-		reqCallbacks:  map[string]func(cfg configapi.Configuration){},
+		reqCallbacks:  map[string]func(cfg configapi.Configuration) error{},
+		delCallbacks:  map[string]func(key configapi.RequestedConfigurationKey){},
+		baseValues:    map[string]configapi.Configuration{},
+		failedUpdates: map[string]*UpdateFailure{},
 		closeCh:       make(chan struct{}, 1),
 		startupLoadCh: make(chan struct{}, 1),
+		startupFailCh: make(chan struct{}),
 		client: &http.Client{
-			Timeout: 2 * 60 * time.Second, // two times of default wait time(60s) on server side
+			Timeout: opt.httpTimeout(),
 		},
 	}
 	c.requests.Selectors = opt.ToSelectors()
 	c.requests.OptionalSelectors = opt.ToOptSelectors()
+	c.requests.WaitTime = opt.WaitTimeForUpdate
+	c.requests.AcceptDelta = opt.EnableDelta && !opt.Hierarchical
+	c.requests.Hierarchical = opt.Hierarchical
 	return c
 }
 
+// Options returns the options of the client
+func (c *Client) Options() ClientOptions {
+	return c.opt
+}
+
 func (c *Client) AddConfigurationRequirement(req RequiredConfig) {
 	// use add method rather than retrieve synchronously is to support dynamic listening(add new or remove existing)
 	if c.reqCallbacks == nil {
@@ -140,7 +214,17 @@ func (c *Client) AddConfigurationRequirement(req RequiredConfig) {
 		Key:     req.Required.Key,
 		Version: req.Required.Version,
 	})
-	c.reqCallbacks[ckey] = req.Callback
+	callback := req.ApplyCallback
+	if callback == nil && req.Callback != nil {
+		callback = func(cfg configapi.Configuration) error {
+			req.Callback(cfg)
+			return nil
+		}
+	}
+	c.reqCallbacks[ckey] = callback
+	if req.DeleteCallback != nil {
+		c.delCallbacks[ckey] = req.DeleteCallback
+	}
 }
 
 func (c *Client) StartClient() error {
@@ -163,60 +247,136 @@ Overall:
 		}
 
 		// send request and process response
-		f := func() bool {
+		f := func() (ready bool, hasFailure bool) {
 			c.lock.Lock()
 			defer c.lock.Unlock()
 			if !c.lockRequests.Load() {
-				return false
+				return false, false
 			}
 
 			// avoid empty request which will cause 400 bad request result
 			if len(c.requests.Requested) == 0 {
 				c.logWarn("no configure requested", nil)
-				return false
+				return false, false
 			}
 
 			res, err := c.sendRetrieveRequest()
 			if err != nil {
 				c.logError("sendRetrieveRequest failed", err)
-				return false
+				return false, false
 			}
 			if res == nil {
 				// no updates, trigger next round
-				return true
+				return true, false
 			}
 
-			// trigger updates
-			for _, v := range res.Requested {
-				k := GetConfigurationKeyFromCfg(v)
-				callback := c.reqCallbacks[k]
-				if callback != nil {
-					callback(v)
-				} else {
-					c.logError("no callback for:"+k, nil)
-				}
-				// update versions for next round
-				for idx, vv := range c.requests.Requested {
-					if vv.Group == v.Group && vv.Key == v.Key {
-						newV := vv
-						newV.Version = v.Version
-						c.requests.Requested[idx] = newV
-					}
-				}
-			}
-			return true
+			// rejected versions are recorded as seen, so the next round starts immediately
+			return true, c.processResponse(res)
 		}
-		ready := f()
+		ready, hasFailure := f()
 		if !ready {
 			time.Sleep(10 * time.Second)
 			continue
-		} else {
-			// mark startup configure loaded when first ready
+		} else if hasFailure {
+			// release the startup waiting with the failure rather than blocking until a valid version is published
+			c.markStartupConfigureFailed()
+		} else if !c.hasFailedUpdates() {
+			// mark startup configure loaded when first ready with all configurations applied
 			c.markStartupConfigureLoaded()
 		}
 	}
 }
 
+// processResponse triggers the callbacks and updates the requests for the next round.
+// It returns true if any update is rejected.
+func (c *Client) processResponse(res *configapi.AcquireConfigurationRes) (hasFailure bool) {
+	// trigger updates
+	for _, v := range res.Requested {
+		k := GetConfigurationKeyFromCfg(v)
+		if v.DeltaBase != "" {
+			if err := c.resolveDelta(&v); err != nil {
+				c.logError("resolve configuration delta failed:"+k+" version:"+v.Version, err)
+				c.markUpdateFailed(v, err)
+				hasFailure = true
+				// request the full value in the next round
+				c.resetRequestedVersion(v.Group, v.Key)
+				continue
+			}
+		}
+		callback := c.reqCallbacks[k]
+		if callback != nil {
+			if err := callback(v); err != nil {
+				// the callback keeps the last applied value, while the version is advanced below so that the server
+				// does not respond the rejected version again and the failure is reported once
+				c.logError("apply configuration update failed:"+k+" version:"+v.Version, err)
+				c.markUpdateFailed(v, err)
+				hasFailure = true
+			} else {
+				c.clearUpdateFailed(v)
+			}
+		} else {
+			c.logError("no callback for:"+k, nil)
+		}
+		// the rejected value is also the base of the next delta since its version is requested
+		if c.opt.EnableDelta {
+			c.baseValues[k] = v
+		}
+		// update versions for next round
+		for idx, vv := range c.requests.Requested {
+			if vv.Group == v.Group && vv.Key == v.Key {
+				newV := vv
+				newV.Version = v.Version
+				newV.Deleted = false
+				c.requests.Requested[idx] = newV
+			}
+		}
+	}
+	// trigger deletions
+	for _, v := range res.Deleted {
+		k := GetConfigurationKey(v)
+		c.logWarn("configuration deleted on server:"+k+" version:"+v.Version, nil)
+		c.clearUpdateFailed(configapi.Configuration{Group: v.Group, Key: v.Key})
+		delete(c.baseValues, k)
+		// wait for recreation in next round
+		for idx, vv := range c.requests.Requested {
+			if vv.Group == v.Group && vv.Key == v.Key {
+				c.requests.Requested[idx].Deleted = true
+			}
+		}
+		if callback := c.delCallbacks[k]; callback != nil {
+			callback(v)
+		}
+	}
+	return hasFailure
+}
+
+// resolveDelta restores the value of the configuration from the delta and the last applied value
+func (c *Client) resolveDelta(cfg *configapi.Configuration) error {
+	base, ok := c.baseValues[GetConfigurationKeyFromCfg(*cfg)]
+	if !ok || base.Version != cfg.DeltaBase {
+		return errors.New("base version of delta not found:" + cfg.DeltaBase)
+	}
+	value, err := configapi.ApplyDelta(base.Value, cfg.Value)
+	if err != nil {
+		return err
+	}
+	cfg.Value = value
+	cfg.DeltaBase = ""
+	if !cfg.ValidateSignature() {
+		return errors.New("signature mismatch after applying delta")
+	}
+	return nil
+}
+
+func (c *Client) resetRequestedVersion(group, key string) {
+	for idx, vv := range c.requests.Requested {
+		if vv.Group == group && vv.Key == key {
+			c.requests.Requested[idx].Version = ""
+		}
+	}
+	delete(c.baseValues, group+"||"+key)
+}
+
 func (c *Client) StopClient() error {
 	c.lockRequests.Store(false)
 	close(c.closeCh)
@@ -243,6 +403,12 @@ func (c *Client) sendRetrieveRequest() (*configapi.AcquireConfigurationRes, erro
 		return nil, err
 	}
 	req.Header.Add("Accept", "application/cbor")
+	if c.opt.Auth != "" {
+		req.Header.Add("Authorization", "Bearer "+c.opt.Auth)
+	}
+	if c.opt.AcceptEncoding != "" {
+		req.Header.Add("Accept-Encoding", c.opt.AcceptEncoding)
+	}
 	res, err := c.client.Do(req)
 	if err != nil {
 		return nil, err
@@ -268,6 +434,11 @@ func (c *Client) sendRetrieveRequest() (*configapi.AcquireConfigurationRes, erro
 	if err != nil {
 		return nil, err
 	}
+	if encoding := res.Header.Get("Content-Encoding"); encoding != "" {
+		if resBody, err = configapi.Decompress(encoding, resBody); err != nil {
+			return nil, err
+		}
+	}
 	result := new(configapi.AcquireConfigurationRes)
 	if err = cbor.Unmarshal(resBody, result); err != nil {
 		return nil, err
@@ -275,6 +446,42 @@ func (c *Client) sendRetrieveRequest() (*configapi.AcquireConfigurationRes, erro
 	return result, nil
 }
 
+func (c *Client) markUpdateFailed(cfg configapi.Configuration, err error) {
+	c.failLock.Lock()
+	defer c.failLock.Unlock()
+	c.failedUpdates[GetConfigurationKeyFromCfg(cfg)] = &UpdateFailure{
+		Group:   cfg.Group,
+		Key:     cfg.Key,
+		Version: cfg.Version,
+		Err:     err,
+		Time:    time.Now(),
+	}
+}
+
+func (c *Client) clearUpdateFailed(cfg configapi.Configuration) {
+	c.failLock.Lock()
+	defer c.failLock.Unlock()
+	delete(c.failedUpdates, GetConfigurationKeyFromCfg(cfg))
+}
+
+func (c *Client) hasFailedUpdates() bool {
+	c.failLock.Lock()
+	defer c.failLock.Unlock()
+	return len(c.failedUpdates) > 0
+}
+
+// FailedUpdates returns the configurations whose latest update is rejected.
+// An entry is removed once a later update of the configuration is applied.
+func (c *Client) FailedUpdates() []UpdateFailure {
+	c.failLock.Lock()
+	defer c.failLock.Unlock()
+	result := make([]UpdateFailure, 0, len(c.failedUpdates))
+	for _, v := range c.failedUpdates {
+		result = append(result, *v)
+	}
+	return result
+}
+
 func (c *Client) logError(msg string, err error) {
 	if err != nil {
 		log.Println("[ERROR]", msg, err)
@@ -297,6 +504,14 @@ func (c *Client) markStartupConfigureLoaded() {
 	}
 }
 
+func (c *Client) markStartupConfigureFailed() {
+	if !c.isStartupConfigureLoaded() {
+		c.startupFailOnce.Do(func() {
+			close(c.startupFailCh)
+		})
+	}
+}
+
 func (c *Client) isStartupConfigureLoaded() bool {
 	select {
 	case _, ok := <-c.startupLoadCh:
@@ -309,15 +524,44 @@ func (c *Client) isStartupConfigureLoaded() bool {
 	}
 }
 
+// WaitStartupConfigureLoaded waits until all the required configurations are applied.
+// ErrStartupConfigureRejected is returned with the failures if any of them is rejected before loaded, while the client
+// keeps retrieving updates and the startup configure is marked loaded once the valid versions are applied.
 func (c *Client) WaitStartupConfigureLoaded(ctx context.Context) error {
 	select {
 	case <-c.startupLoadCh:
 		return nil
+	case <-c.startupFailCh:
+		if c.isStartupConfigureLoaded() {
+			return nil
+		}
+		if c.hasFailedUpdates() {
+			return c.startupFailure()
+		}
+		// the failure has been fixed by a later version
+		select {
+		case <-c.startupLoadCh:
+			return nil
+		case <-ctx.Done():
+			return ErrWaitStartupLoadedTimeout
+		}
 	case <-ctx.Done():
+		if c.hasFailedUpdates() {
+			return errors.Join(ErrWaitStartupLoadedTimeout, c.startupFailure())
+		}
 		return ErrWaitStartupLoadedTimeout
 	}
 }
 
+// startupFailure builds the error from the current failed updates
+func (c *Client) startupFailure() error {
+	var errs []error
+	for _, v := range c.FailedUpdates() {
+		errs = append(errs, fmt.Errorf("%s/%s version %s: %w", v.Group, v.Key, v.Version, v.Err))
+	}
+	return fmt.Errorf("%w: %w", ErrStartupConfigureRejected, errors.Join(errs...))
+}
+
 func GetConfigurationSync() {
 	//TODO get at once
 	panic("implement me")
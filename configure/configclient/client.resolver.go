@@ -0,0 +1,283 @@
+package configclient
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+type ConfigSource string
+
+// Sources in the order of precedence from low to high
+const (
+	SourceNone    ConfigSource = ""
+	SourceDefault ConfigSource = "default"
+	SourceFile    ConfigSource = "file"
+	SourceRemote  ConfigSource = "remote"
+	SourceEnv     ConfigSource = "env"
+	SourceFlag    ConfigSource = "flag"
+)
+
+// ResolvedField records where the value of a field comes from
+type ResolvedField struct {
+	// Path is the field path from the root struct, e.g. Db.Host
+	Path   string
+	Source ConfigSource
+	// Origin is the detail of the source: file path, remote group/key, environment variable name or flag name
+	Origin string
+}
+
+type Resolved[T any] struct {
+	Value T
+	// Fields contains the leaf fields that are provided by any source
+	Fields map[string]ResolvedField
+}
+
+// Resolver merges configurations from multiple sources into one *struct
+//
+// Precedence from low to high:
+//  1. `default` tag
+//  2. local files, later added files override earlier ones
+//  3. remote ClientAdv containers, later added containers override earlier ones
+//  4. environment variables, mapped via `env` tag with the full variable name
+//  5. program flags, mapped via `flag` tag. Only the flags set explicitly take effect.
+//
+// Note: values from files and remote containers are merged field by field, and only non-zero fields override lower
+// sources since zero values can not be distinguished from absent ones.
+type Resolver[T any] struct {
+	files []struct {
+		path        string
+		unmarshaler Unmarshaler
+		optional    bool
+	}
+	remotes []struct {
+		origin string
+		decode func() (T, bool, error)
+	}
+	env     *EnvClient
+	flagSet *flag.FlagSet
+}
+
+func NewResolver[T any]() *Resolver[T] {
+	return &Resolver[T]{}
+}
+
+// AddFile adds a local file source, missing file causes error while resolving
+func (r *Resolver[T]) AddFile(path string, unmarshaler Unmarshaler) *Resolver[T] {
+	r.files = append(r.files, struct {
+		path        string
+		unmarshaler Unmarshaler
+		optional    bool
+	}{path: path, unmarshaler: unmarshaler})
+	return r
+}
+
+// AddOptionalFile adds a local file source which is skipped if the file doesn't exist
+func (r *Resolver[T]) AddOptionalFile(path string, unmarshaler Unmarshaler) *Resolver[T] {
+	r.files = append(r.files, struct {
+		path        string
+		unmarshaler Unmarshaler
+		optional    bool
+	}{path: path, unmarshaler: unmarshaler, optional: true})
+	return r
+}
+
+// AddRemote adds the container of ClientAdv as a source. The last applied configuration of the container is decoded
+// again without the `default` tag values while resolving, so the container provides nothing before the first update.
+func (r *Resolver[T]) AddRemote(group, key string, container *ConfigContainer[T]) *Resolver[T] {
+	r.remotes = append(r.remotes, struct {
+		origin string
+		decode func() (T, bool, error)
+	}{origin: group + "/" + key, decode: container.decodeRaw})
+	return r
+}
+
+func (r *Resolver[T]) WithEnv(env *EnvClient) *Resolver[T] {
+	r.env = env
+	return r
+}
+
+// WithFlags uses the flag set as a source. DefineFlags could be used to define the flags from `flag` tags.
+func (r *Resolver[T]) WithFlags(fs *flag.FlagSet) *Resolver[T] {
+	r.flagSet = fs
+	return r
+}
+
+// DefineFlags defines string flags in the flag set for the fields with `flag` tag unless the flags have been defined.
+// The usage of the flag is from `usage` tag. It should be called before parsing the flags.
+func (r *Resolver[T]) DefineFlags(fs *flag.FlagSet) error {
+	st, err := resolverStructType[T]()
+	if err != nil {
+		return err
+	}
+	walkLeafFields(st, func(path string, field reflect.StructField, index []int) {
+		name := field.Tag.Get("flag")
+		if name == "" || fs.Lookup(name) != nil {
+			return
+		}
+		fs.String(name, field.Tag.Get("default"), field.Tag.Get("usage"))
+	})
+	r.flagSet = fs
+	return nil
+}
+
+func resolverStructType[T any]() (reflect.Type, error) {
+	var dummy T
+	if !checkStructPtr(dummy) {
+		return nil, errors.New("resolver type should be '*struct' type")
+	}
+	return getStructType(dummy), nil
+}
+
+// walkLeafFields visits the exported fields which are not nested structs
+func walkLeafFields(st reflect.Type, fn func(path string, field reflect.StructField, index []int)) {
+	var walk func(st reflect.Type, prefix string, index []int)
+	walk = func(st reflect.Type, prefix string, index []int) {
+		for i := 0; i < st.NumField(); i++ {
+			field := st.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			fieldIndex := append(append([]int(nil), index...), i)
+			if isNestedStruct(field.Type) {
+				walk(field.Type, prefix+field.Name+".", fieldIndex)
+			} else {
+				fn(prefix+field.Name, field, fieldIndex)
+			}
+		}
+	}
+	walk(st, "", nil)
+}
+
+// Resolve merges all the sources into a new instance. Errors of all fields are aggregated.
+func (r *Resolver[T]) Resolve() (*Resolved[T], error) {
+	st, err := resolverStructType[T]()
+	if err != nil {
+		return nil, err
+	}
+	result := reflect.New(st)
+	root := result.Elem()
+	fields := map[string]ResolvedField{}
+	var errs []error
+
+	// defaults
+	walkLeafFields(st, func(path string, field reflect.StructField, index []int) {
+		dv, ok := field.Tag.Lookup("default")
+		if !ok {
+			return
+		}
+		if err := setFieldFromString(root.FieldByIndex(index), dv); err != nil {
+			errs = append(errs, fmt.Errorf("default value of field %s: %w", path, err))
+			return
+		}
+		fields[path] = ResolvedField{Path: path, Source: SourceDefault}
+	})
+
+	// struct based sources
+	mergeStruct := func(src reflect.Value, source ConfigSource, origin string) {
+		walkLeafFields(st, func(path string, field reflect.StructField, index []int) {
+			v := src.FieldByIndex(index)
+			if v.IsZero() {
+				return
+			}
+			root.FieldByIndex(index).Set(v)
+			fields[path] = ResolvedField{Path: path, Source: source, Origin: origin}
+		})
+	}
+	for _, f := range r.files {
+		data, err := os.ReadFile(f.path)
+		if errors.Is(err, os.ErrNotExist) && f.optional {
+			continue
+		} else if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		inst := reflect.New(st)
+		if err := f.unmarshaler(data, inst.Interface()); err != nil {
+			errs = append(errs, fmt.Errorf("unmarshal file %s: %w", f.path, err))
+			continue
+		}
+		mergeStruct(inst.Elem(), SourceFile, f.path)
+	}
+	for _, remote := range r.remotes {
+		inst, ok, err := remote.decode()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unmarshal remote %s: %w", remote.origin, err))
+			continue
+		}
+		if !ok {
+			continue
+		}
+		mergeStruct(reflect.ValueOf(inst).Elem(), SourceRemote, remote.origin)
+	}
+
+	// tag based sources
+	setFromTag := func(tag string, source ConfigSource, lookup func(name string) (string, bool)) {
+		walkLeafFields(st, func(path string, field reflect.StructField, index []int) {
+			name := field.Tag.Get(tag)
+			if name == "" {
+				return
+			}
+			value, ok := lookup(name)
+			if !ok {
+				return
+			}
+			if err := setFieldFromString(root.FieldByIndex(index), value); err != nil {
+				errs = append(errs, fmt.Errorf("%s %s of field %s: %w", source, name, path, err))
+				return
+			}
+			fields[path] = ResolvedField{Path: path, Source: source, Origin: name}
+		})
+	}
+	if r.env != nil {
+		setFromTag("env", SourceEnv, func(name string) (string, bool) {
+			v, err := r.env.GetString(name)
+			return v, err == nil
+		})
+	}
+	if r.flagSet != nil {
+		setFlags := map[string]string{}
+		r.flagSet.Visit(func(f *flag.Flag) {
+			setFlags[f.Name] = f.Value.String()
+		})
+		setFromTag("flag", SourceFlag, func(name string) (string, bool) {
+			v, ok := setFlags[name]
+			return v, ok
+		})
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return &Resolved[T]{
+		Value:  result.Interface().(T),
+		Fields: fields,
+	}, nil
+}
+
+// Source returns the source of the field by path, SourceNone if the field is not provided by any source
+func (r *Resolved[T]) Source(path string) ConfigSource {
+	return r.Fields[path].Source
+}
+
+// String prints the sources of all fields, e.g. for startup logs
+func (r *Resolved[T]) String() string {
+	buf := new(strings.Builder)
+	walkLeafFields(getStructType(r.Value), func(path string, field reflect.StructField, index []int) {
+		f, ok := r.Fields[path]
+		if !ok {
+			return
+		}
+		buf.WriteString(path)
+		buf.WriteString("=")
+		buf.WriteString(string(f.Source))
+		if f.Origin != "" {
+			buf.WriteString("(" + f.Origin + ")")
+		}
+		buf.WriteString("\n")
+	})
+	return buf.String()
+}
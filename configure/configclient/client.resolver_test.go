@@ -0,0 +1,113 @@
+package configclient
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type ResolverTestStruct struct {
+	Name    string        `yaml:"name" env:"RESOLVER_TEST_NAME" flag:"name" default:"app"`
+	Port    int           `yaml:"port" env:"RESOLVER_TEST_PORT" flag:"port" default:"8080"`
+	Timeout time.Duration `yaml:"timeout" default:"1s"`
+	Db      struct {
+		Host string `yaml:"host" env:"RESOLVER_TEST_DB_HOST"`
+		User string `yaml:"user"`
+	} `yaml:"db"`
+	Debug bool `yaml:"debug"`
+}
+
+func newResolverTestContainer(t *testing.T, value string) *ConfigContainer[*ResolverTestStruct] {
+	inst, err := newStructPtrWithDefaults(getStructType(&ResolverTestStruct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "" {
+		if err := yaml.Unmarshal([]byte(value), inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &ConfigContainer[*ResolverTestStruct]{val: new(atomic.Value), unmarshaler: yaml.Unmarshal}
+	c.val.Store(inst)
+	if value != "" {
+		raw := []byte(value)
+		c.raw.Store(&raw)
+	}
+	return c
+}
+
+func TestResolver_Precedence(t *testing.T) {
+	dir := t.TempDir()
+	file1 := filepath.Join(dir, "base.yaml")
+	if err := os.WriteFile(file1, []byte("port: 9000\ndb:\n  host: file-host\n  user: file-user\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file2 := filepath.Join(dir, "override.yaml")
+	if err := os.WriteFile(file2, []byte("timeout: 5s\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RESOLVER_TEST_DB_HOST", "env-host")
+	t.Setenv("RESOLVER_TEST_PORT", "9001")
+
+	// the container is filled with the default values, which should not override the files
+	remote := newResolverTestContainer(t, "debug: true\ndb:\n  user: remote-user\n")
+	// not loaded yet
+	pending := newResolverTestContainer(t, "")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	r := NewResolver[*ResolverTestStruct]().
+		AddFile(file1, yaml.Unmarshal).
+		AddFile(file2, yaml.Unmarshal).
+		AddOptionalFile(filepath.Join(dir, "missing.yaml"), yaml.Unmarshal).
+		AddRemote("group", "key", remote).
+		AddRemote("group", "pending", pending).
+		WithEnv(NewEnvClient())
+	if err := r.DefineFlags(fs); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Parse([]string{"-port", "9002"}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := r.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log("\n" + res.String())
+	v := res.Value
+	if v.Name != "app" || res.Source("Name") != SourceDefault {
+		t.Fatal("default value expected for name")
+	}
+	if v.Port != 9002 || res.Source("Port") != SourceFlag {
+		t.Fatal("flag value expected for port")
+	}
+	if v.Timeout != 5*time.Second || res.Fields["Timeout"].Origin != file2 {
+		t.Fatal("later file should override timeout")
+	}
+	if v.Db.Host != "env-host" || res.Source("Db.Host") != SourceEnv {
+		t.Fatal("env value expected for db host")
+	}
+	if v.Db.User != "remote-user" || res.Source("Db.User") != SourceRemote {
+		t.Fatal("remote value expected for db user")
+	}
+	if !v.Debug {
+		t.Fatal("remote value expected for debug")
+	}
+}
+
+func TestResolver_Errors(t *testing.T) {
+	t.Setenv("RESOLVER_TEST_PORT", "abc")
+	_, err := NewResolver[*ResolverTestStruct]().
+		AddFile(filepath.Join(t.TempDir(), "missing.yaml"), yaml.Unmarshal).
+		WithEnv(NewEnvClient()).
+		Resolve()
+	if err == nil {
+		t.Fatal("missing file and invalid env value should fail")
+	}
+	t.Log(err)
+}
@@ -2,13 +2,16 @@ package configclient
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
 )
 
 var (
 	ErrNoSuchEnvironmentVariable                = errors.New("no such environment variable")
+	ErrInvalidBindingTarget                     = errors.New("binding target should be non-nil '*struct' type")
 	ErrHasDuplicatedKeysWithDifferentCaseFormat = errors.New("duplicated keys with different case format")
 )
 
@@ -127,3 +130,74 @@ func (c *EnvClient) CaseInsensitive() *EnvClient {
 		parserWarning:     parserWarning,
 	}
 }
+
+// Bind populates the *struct from environment variables via struct tags
+//
+// Tags:
+//  1. `env:"DB_HOST"` - the name of the environment variable. Fields without the tag are skipped.
+//     For nested struct fields, the tag is used as the prefix of the children joined with '_', e.g. `env:"DB"` + `env:"HOST"` => DB_HOST.
+//  2. `default:"localhost"` - the default value if the environment variable doesn't exist
+//  3. `required:"true"` - the environment variable should exist if no default value
+//  4. `envSeparator:";"` - the separator of slice fields, default ','
+//
+// Errors of all fields are aggregated.
+func (c *EnvClient) Bind(v any) error {
+	return c.BindWithPrefix("", v)
+}
+
+// BindWithPrefix is the same as Bind with the prefix prepended to all environment variable names
+func (c *EnvClient) BindWithPrefix(prefix string, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrInvalidBindingTarget
+	}
+	var errs []error
+	c.bindStruct(prefix, "", rv.Elem(), &errs)
+	return errors.Join(errs...)
+}
+
+func (c *EnvClient) bindStruct(prefix, pathPrefix string, sv reflect.Value, errs *[]error) {
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Tag.Get("env")
+		path := pathPrefix + field.Name
+		if isNestedStruct(field.Type) {
+			childPrefix := prefix
+			if name != "" {
+				childPrefix = prefix + name + "_"
+			}
+			c.bindStruct(childPrefix, path+".", sv.Field(i), errs)
+			continue
+		}
+		if name == "" {
+			continue
+		}
+		name = prefix + name
+
+		value, err := c.GetString(name)
+		if errors.Is(err, ErrNoSuchEnvironmentVariable) {
+			if dv, ok := field.Tag.Lookup("default"); ok {
+				value = dv
+			} else if field.Tag.Get("required") == "true" {
+				*errs = append(*errs, fmt.Errorf("field %s: %w: %s", path, ErrNoSuchEnvironmentVariable, name))
+				continue
+			} else {
+				continue
+			}
+		}
+
+		fv := sv.Field(i)
+		if sep, ok := field.Tag.Lookup("envSeparator"); ok && fv.Kind() == reflect.Slice {
+			err = setSliceFromString(fv, value, sep)
+		} else {
+			err = setFieldFromString(fv, value)
+		}
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("field %s: environment variable %s: %w", path, name, err))
+		}
+	}
+}
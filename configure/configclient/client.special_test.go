@@ -1,7 +1,10 @@
 package configclient_test
 
 import (
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/meidoworks/nekoq-component/configure/configclient"
 )
@@ -28,3 +31,57 @@ func TestEnvClientBasic(t *testing.T) {
 		t.Fatal("unexpected value from environment")
 	}
 }
+
+type envBindingConfig struct {
+	Name    string        `env:"NAME" required:"true"`
+	Port    int           `env:"PORT" default:"8080"`
+	Timeout time.Duration `env:"TIMEOUT" default:"3s"`
+	Hosts   []string      `env:"HOSTS" envSeparator:";"`
+	Ports   []int         `env:"PORTS"`
+	Db      struct {
+		Host string `env:"HOST" default:"localhost"`
+		User string `env:"USER" required:"true"`
+	} `env:"DB"`
+	Ignored string
+}
+
+func TestEnvClient_Bind(t *testing.T) {
+	t.Setenv("BINDTEST_NAME", "demo")
+	t.Setenv("BINDTEST_TIMEOUT", "1m")
+	t.Setenv("BINDTEST_HOSTS", "a.local; b.local")
+	t.Setenv("BINDTEST_PORTS", "1,2,3")
+	t.Setenv("BINDTEST_DB_USER", "admin")
+
+	cfg := new(envBindingConfig)
+	if err := configclient.NewEnvClient().BindWithPrefix("BINDTEST_", cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "demo" || cfg.Port != 8080 || cfg.Timeout != time.Minute {
+		t.Fatal("unexpected scalar fields:", cfg)
+	}
+	if len(cfg.Hosts) != 2 || cfg.Hosts[1] != "b.local" || len(cfg.Ports) != 3 || cfg.Ports[2] != 3 {
+		t.Fatal("unexpected slice fields:", cfg.Hosts, cfg.Ports)
+	}
+	if cfg.Db.Host != "localhost" || cfg.Db.User != "admin" {
+		t.Fatal("unexpected nested fields:", cfg.Db)
+	}
+}
+
+func TestEnvClient_BindAggregatedErrors(t *testing.T) {
+	t.Setenv("BINDTEST2_PORT", "not-a-number")
+
+	err := configclient.NewEnvClient().BindWithPrefix("BINDTEST2_", new(envBindingConfig))
+	if err == nil {
+		t.Fatal("binding should fail")
+	}
+	if !errors.Is(err, configclient.ErrNoSuchEnvironmentVariable) {
+		t.Fatal("missing required variable expected:", err)
+	}
+	// NAME, PORT and DB_USER
+	if cnt := len(strings.Split(err.Error(), "\n")); cnt != 3 {
+		t.Fatal("3 errors expected:", err)
+	}
+	if err := configclient.NewEnvClient().Bind(envBindingConfig{}); !errors.Is(err, configclient.ErrInvalidBindingTarget) {
+		t.Fatal("invalid target expected:", err)
+	}
+}
@@ -1,7 +1,16 @@
 package configclient
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
 
 	"github.com/meidoworks/nekoq-component/configure/configapi"
 )
@@ -40,3 +49,153 @@ func TestClient_basic1(t *testing.T) {
 	<-ch // first time
 	<-ch // wait for update
 }
+
+func TestClient_WaitTimeForUpdate(t *testing.T) {
+	c := NewClient([]string{"http://127.0.0.1:8080"}, ClientOptions{WaitTimeForUpdate: 25})
+	if c.requests.WaitTime != 25 {
+		t.Fatal("wait time should be sent in requests")
+	}
+	if c.client.Timeout != 50*time.Second {
+		t.Fatal("unexpected http timeout:", c.client.Timeout)
+	}
+	if c := NewClient([]string{"http://127.0.0.1:8080"}, ClientOptions{}); c.client.Timeout != 2*time.Minute {
+		t.Fatal("unexpected default http timeout:", c.client.Timeout)
+	}
+}
+
+func TestClient_ResolveDelta(t *testing.T) {
+	c := NewClient([]string{"http://127.0.0.1:8080"}, ClientOptions{EnableDelta: true})
+	var applied []configapi.Configuration
+	c.AddConfigurationRequirement(RequiredConfig{
+		Required: configapi.RequestedConfigurationKey{Group: "group", Key: "key"},
+		Callback: func(cfg configapi.Configuration) {
+			applied = append(applied, cfg)
+		},
+	})
+	if !c.requests.AcceptDelta {
+		t.Fatal("delta should be requested")
+	}
+	newCfg := func(version, value string) configapi.Configuration {
+		cfg := configapi.Configuration{Group: "group", Key: "key", Version: version, Value: []byte(value)}
+		cfg.Signature = cfg.GenerateSignature()
+		return cfg
+	}
+	v1 := newCfg("v1", "a long configuration value which is updated by delta")
+	v2 := newCfg("v2", "a long configuration value which is updated by delta!")
+	if c.processResponse(&configapi.AcquireConfigurationRes{Requested: []configapi.Configuration{v1}}) {
+		t.Fatal("full value should be applied")
+	}
+
+	d := v2
+	d.Value = configapi.EncodeDelta(v1.Value, v2.Value)
+	d.DeltaBase = "v1"
+	if c.processResponse(&configapi.AcquireConfigurationRes{Requested: []configapi.Configuration{d}}) {
+		t.Fatal("delta should be applied")
+	}
+	if len(applied) != 2 || string(applied[1].Value) != string(v2.Value) || applied[1].DeltaBase != "" {
+		t.Fatal("restored value expected:", applied)
+	}
+
+	// unknown base requests the full value in the next round
+	d.DeltaBase = "v0"
+	if !c.processResponse(&configapi.AcquireConfigurationRes{Requested: []configapi.Configuration{d}}) {
+		t.Fatal("delta without base should fail")
+	}
+	if c.requests.Requested[0].Version != "" || len(applied) != 2 {
+		t.Fatal("version should be reset")
+	}
+}
+
+func TestClient_RejectedVersionRecorded(t *testing.T) {
+	c := NewClient([]string{"http://127.0.0.1:8080"}, ClientOptions{})
+	var rejected int
+	c.AddConfigurationRequirement(RequiredConfig{
+		Required: configapi.RequestedConfigurationKey{Group: "group", Key: "key"},
+		ApplyCallback: func(cfg configapi.Configuration) error {
+			if string(cfg.Value) == "bad" {
+				rejected++
+				return errors.New("invalid value")
+			}
+			return nil
+		},
+	})
+	if c.processResponse(&configapi.AcquireConfigurationRes{Requested: []configapi.Configuration{
+		{Group: "group", Key: "key", Version: "v1", Value: []byte("good")}}}) {
+		t.Fatal("update should be applied")
+	}
+	if !c.processResponse(&configapi.AcquireConfigurationRes{Requested: []configapi.Configuration{
+		{Group: "group", Key: "key", Version: "v2", Value: []byte("bad")}}}) {
+		t.Fatal("update should be rejected")
+	}
+	// the server does not respond v2 again with the requested version
+	if c.requests.Requested[0].Version != "v2" || rejected != 1 || !c.hasFailedUpdates() {
+		t.Fatal("rejected version should be recorded as seen:", c.requests.Requested[0].Version, rejected)
+	}
+	if c.processResponse(&configapi.AcquireConfigurationRes{Requested: []configapi.Configuration{
+		{Group: "group", Key: "key", Version: "v3", Value: []byte("good")}}}) {
+		t.Fatal("update should be applied")
+	}
+	if c.requests.Requested[0].Version != "v3" || c.hasFailedUpdates() {
+		t.Fatal("failure should be cleared after applied")
+	}
+}
+
+func TestClient_StartupVersionRejected(t *testing.T) {
+	var fixed atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		req := new(configapi.AcquireConfigurationReq)
+		if err := cbor.Unmarshal(data, req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var cfg configapi.Configuration
+		switch {
+		case req.Requested[0].Version == "":
+			cfg = configapi.Configuration{Group: "group", Key: "key", Version: "v1", Value: []byte("bad")}
+		case req.Requested[0].Version == "v1" && fixed.Load():
+			cfg = configapi.Configuration{Group: "group", Key: "key", Version: "v2", Value: []byte("good")}
+		default:
+			time.Sleep(10 * time.Millisecond)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		res, _ := cbor.Marshal(&configapi.AcquireConfigurationRes{Requested: []configapi.Configuration{cfg}})
+		w.Header().Set("Content-Type", "application/cbor")
+		_, _ = w.Write(res)
+	}))
+	defer srv.Close()
+
+	c := NewClient([]string{srv.URL}, ClientOptions{})
+	c.AddConfigurationRequirement(RequiredConfig{
+		Required: configapi.RequestedConfigurationKey{Group: "group", Key: "key"},
+		ApplyCallback: func(cfg configapi.Configuration) error {
+			if string(cfg.Value) == "bad" {
+				return errors.New("invalid value")
+			}
+			return nil
+		},
+	})
+	if err := c.StartClient(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.StopClient()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.WaitStartupConfigureLoaded(ctx); !errors.Is(err, ErrStartupConfigureRejected) {
+		t.Fatal("rejected startup configuration expected:", err)
+	}
+
+	// a valid version is applied later
+	fixed.Store(true)
+	for c.WaitStartupConfigureLoaded(ctx) != nil {
+		if ctx.Err() != nil {
+			t.Fatal("startup configuration should be loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.hasFailedUpdates() {
+		t.Fatal("failure should be cleared after loaded")
+	}
+}
@@ -3,8 +3,10 @@ package configserver
 import (
 	"crypto/rand"
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -71,6 +73,10 @@ func (d *DumpDataPump) Stop() error {
 	return nil
 }
 
+func (d *DumpDataPump) Health() configapi.PumpHealth {
+	return configapi.PumpHealth{Healthy: true}
+}
+
 func (d *DumpDataPump) EventChannel() <-chan configapi.Event {
 	return nil
 }
@@ -143,7 +149,7 @@ func TestServerBench_WaitingThroughput(t *testing.T) {
 				f := func() {
 					t := time.NewTimer(2 * time.Minute)
 					defer t.Stop()
-					ch, cancelFunc, err := s.RetrieveOrWait(&configapi.AcquireConfigurationReq{
+					ch, cancelFunc, err := s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
 						Requested:         requested,
 						Selectors:         configapi.Selectors{},
 						OptionalSelectors: configapi.Selectors{},
@@ -213,7 +219,7 @@ func TestServerBench_NoWaitingThroughput(t *testing.T) {
 				f := func() {
 					t := time.NewTimer(2 * time.Minute)
 					defer t.Stop()
-					ch, cancelFunc, err := s.RetrieveOrWait(&configapi.AcquireConfigurationReq{
+					ch, cancelFunc, err := s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
 						Requested:         requested,
 						Selectors:         configapi.Selectors{},
 						OptionalSelectors: configapi.Selectors{},
@@ -253,3 +259,134 @@ func TestServerBench_NoWaitingThroughput(t *testing.T) {
 
 //TODO test: based on data generator
 // case6 detail: with http, response after changes
+
+// benchmark: notification latency of a change on an idle server, from sending the event to the pump channel to
+// receiving the notification by the waiting listener
+func BenchmarkPumpPipeline_Latency(b *testing.B) {
+	pump := &channelDataPump{ch: make(chan configapi.Event)}
+	s := newServer(pump, DefaultVersionComparator{})
+	if err := s.Startup(); err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		_ = s.Shutdown()
+	}()
+	sel := configapi.Selectors{Data: map[string]string{"area": "dc1"}}
+	version := func(i int) string {
+		return fmt.Sprintf("v%012d", i)
+	}
+	pump.ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Group: "g", Key: "k", Version: version(0), Selectors: sel}}
+	for {
+		if _, err := s.GetConfigurationViaPlainRequest(configapi.DefaultTenant, "g", "k", "area=dc1", ""); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	costs := make([]time.Duration, 0, b.N)
+	b.ResetTimer()
+	for i := 1; i <= b.N; i++ {
+		ch, cancelFn, err := s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
+			Requested: []configapi.RequestedConfigurationKey{{Group: "g", Key: "k", Version: version(i - 1)}},
+			Selectors: sel,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		start := time.Now()
+		pump.ch <- configapi.Event{Modified: true, Configuration: &configapi.Configuration{Group: "g", Key: "k", Version: version(i), Selectors: sel}}
+		<-ch
+		costs = append(costs, time.Since(start))
+		cancelFn()
+	}
+	b.StopTimer()
+	slices.Sort(costs)
+	b.ReportMetric(float64(costs[len(costs)/2].Microseconds()), "p50-us")
+	b.ReportMetric(float64(costs[len(costs)*99/100].Microseconds()), "p99-us")
+}
+
+// benchmark: throughput of applying the changes of 100 keys while the listeners keep waiting for the changes
+func BenchmarkPumpPipeline_Throughput(b *testing.B) {
+	for _, listeners := range []int{0, 1000} {
+		b.Run(fmt.Sprint("listeners=", listeners), func(b *testing.B) {
+			benchmarkPumpThroughput(b, listeners)
+		})
+	}
+}
+
+func benchmarkPumpThroughput(b *testing.B, listeners int) {
+	const keys = 100
+	pump := &channelDataPump{ch: make(chan configapi.Event, 4096)}
+	s := newServer(pump, DefaultVersionComparator{})
+	if err := s.Startup(); err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		_ = s.Shutdown()
+	}()
+	sel := configapi.Selectors{Data: map[string]string{"area": "dc1"}}
+	version := func(i int) string {
+		return fmt.Sprintf("v%012d", i)
+	}
+	for k := 0; k < keys; k++ {
+		pump.ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Group: "g", Key: fmt.Sprint("k", k), Version: version(0), Selectors: sel, Value: data}}
+	}
+	waitApplied := func(key string) {
+		for {
+			if _, err := s.GetConfigurationViaPlainRequest(configapi.DefaultTenant, "g", key, "area=dc1", ""); err == nil {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitApplied(fmt.Sprint("k", keys-1))
+
+	stop := make(chan struct{})
+	wg := new(sync.WaitGroup)
+	notified := &atomic.Int64{}
+	for i := 0; i < listeners; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			current := version(0)
+			for {
+				ch, cancelFn, err := s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
+					Requested: []configapi.RequestedConfigurationKey{{Group: "g", Key: key, Version: current}},
+					Selectors: sel,
+				})
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				select {
+				case ev, ok := <-ch:
+					if ok {
+						current = ev.Configuration.Version
+						notified.Add(1)
+					}
+				case <-stop:
+				}
+				cancelFn()
+				select {
+				case <-stop:
+					return
+				default:
+				}
+			}
+		}(fmt.Sprint("k", i%keys))
+	}
+
+	b.ResetTimer()
+	start := time.Now()
+	for i := 1; i <= b.N; i++ {
+		pump.ch <- configapi.Event{Modified: true, Configuration: &configapi.Configuration{Group: "g", Key: fmt.Sprint("k", i%keys), Version: version(i), Selectors: sel, Value: data}}
+	}
+	pump.ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Group: "g", Key: "end", Version: version(0), Selectors: sel}}
+	waitApplied("end")
+	elapsed := time.Since(start)
+	b.StopTimer()
+	close(stop)
+	wg.Wait()
+	b.ReportMetric(float64(b.N)/elapsed.Seconds(), "events/s")
+	b.ReportMetric(float64(notified.Load())/elapsed.Seconds(), "notifications/s")
+}
@@ -0,0 +1,288 @@
+package configserver
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+const (
+	adminDefaultPageSize = 100
+	adminMaxPageSize     = 1000
+)
+
+func (s *selectorsStore) ListenerCount(group, key string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.listeners[s.cfgKey(group, key)])
+}
+
+func (s *selectorsStore) collectListeners(set map[int64]struct{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, m := range s.listeners {
+		for reqid := range m {
+			set[reqid] = struct{}{}
+		}
+	}
+}
+
+func (s *server) ListSelectors(tenant, prefix string) []configapi.AdminSelectorsItem {
+	var result []configapi.AdminSelectorsItem
+	s.stores.ForEach(tenant, func(selectorsKey, optSelectorsKey string, store *selectorsStore) {
+		if !strings.HasPrefix(selectorsKey, prefix) {
+			return
+		}
+		set := map[int64]struct{}{}
+		store.collectListeners(set)
+		result = append(result, configapi.AdminSelectorsItem{
+			Selectors:          selectorsKey,
+			OptionalSelectors:  optSelectorsKey,
+			ConfigurationCount: store.snapshot.Load().count,
+			ListenerCount:      len(set),
+		})
+	})
+	return result
+}
+
+// ListGroups returns nil if the selector combination doesn't exist
+func (s *server) ListGroups(tenant, selectorsKey, optSelectorsKey, prefix string) []configapi.AdminGroupItem {
+	store := s.stores.GetExact(tenant, selectorsKey, optSelectorsKey)
+	if store == nil {
+		return nil
+	}
+	groups := map[string]int{}
+	store.snapshot.Load().forEach(func(_ string, e *storeEntry) {
+		if strings.HasPrefix(e.cfg.Group, prefix) {
+			groups[e.cfg.Group]++
+		}
+	})
+	result := make([]configapi.AdminGroupItem, 0, len(groups))
+	for g, cnt := range groups {
+		result = append(result, configapi.AdminGroupItem{
+			Group:    g,
+			KeyCount: cnt,
+		})
+	}
+	slices.SortFunc(result, func(a, b configapi.AdminGroupItem) int {
+		return strings.Compare(a.Group, b.Group)
+	})
+	return result
+}
+
+// ListKeys returns nil if the selector combination doesn't exist
+func (s *server) ListKeys(tenant, selectorsKey, optSelectorsKey, group, prefix string) []configapi.AdminKeyItem {
+	store := s.stores.GetExact(tenant, selectorsKey, optSelectorsKey)
+	if store == nil {
+		return nil
+	}
+	result := []configapi.AdminKeyItem{}
+	store.snapshot.Load().forEach(func(_ string, e *storeEntry) {
+		cfg := e.cfg
+		if cfg.Group != group || !strings.HasPrefix(cfg.Key, prefix) {
+			return
+		}
+		result = append(result, configapi.AdminKeyItem{
+			Group:         cfg.Group,
+			Key:           cfg.Key,
+			Version:       cfg.Version,
+			Timestamp:     cfg.Timestamp,
+			ListenerCount: store.ListenerCount(cfg.Group, cfg.Key),
+		})
+	})
+	slices.SortFunc(result, func(a, b configapi.AdminKeyItem) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return result
+}
+
+func (s *server) Stats(tenant string) (selectorsCount, cfgCount, listenerCount int) {
+	set := map[int64]struct{}{}
+	s.stores.ForEach(tenant, func(selectorsKey, optSelectorsKey string, store *selectorsStore) {
+		selectorsCount++
+		cfgCount += store.snapshot.Load().count
+		store.collectListeners(set)
+	})
+	return selectorsCount, cfgCount, len(set)
+}
+
+func paginate[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+	end := offset + limit
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[offset:end]
+}
+
+func parsePageParams(r *http.Request) (offset, limit int, ok bool) {
+	params := r.URL.Query()
+	limit = adminDefaultPageSize
+	if v := params.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		offset = n
+	}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > adminMaxPageSize {
+			return 0, 0, false
+		}
+		limit = n
+	}
+	return offset, limit, true
+}
+
+// prepareAdminApi mounts the admin query apis listing the in-memory data, which are served by the write api, or by
+// the read api if the write api is disabled
+func (c *ConfigureServer) prepareAdminApi(r chi.Router) {
+	r.Get("/admin/selectors", c.adminListSelectors)
+	r.Get("/admin/groups", c.adminListGroups)
+	r.Get("/admin/groups/{group}/keys", c.adminListKeys)
+	r.Get("/admin/stats", c.adminStats)
+}
+
+func (c *ConfigureServer) adminListSelectors(w http.ResponseWriter, r *http.Request) {
+	cc := acceptCodec(r)
+	if cc == nil {
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+	offset, limit, ok := parsePageParams(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	items := c.server.ListSelectors(tenantOf(r), r.URL.Query().Get("prefix"))
+	c.writeResponse(w, cc, &configapi.AdminSelectorsRes{
+		Code:    "200",
+		Message: "success",
+		Total:   len(items),
+		Items:   paginate(items, offset, limit),
+	})
+}
+
+func (c *ConfigureServer) adminListGroups(w http.ResponseWriter, r *http.Request) {
+	cc := acceptCodec(r)
+	if cc == nil {
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+	selectorsInfo := strings.TrimSpace(r.Header.Get("X-Configuration-Sel"))
+	if selectorsInfo == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	optSelectorsInfo := strings.TrimSpace(r.Header.Get("X-Configuration-Opt-Sel"))
+	offset, limit, ok := parsePageParams(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	items := c.server.ListGroups(tenantOf(r), selectorsInfo, optSelectorsInfo, r.URL.Query().Get("prefix"))
+	if items == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	c.writeResponse(w, cc, &configapi.AdminGroupsRes{
+		Code:    "200",
+		Message: "success",
+		Total:   len(items),
+		Items:   paginate(items, offset, limit),
+	})
+}
+
+func (c *ConfigureServer) adminListKeys(w http.ResponseWriter, r *http.Request) {
+	cc := acceptCodec(r)
+	if cc == nil {
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+	selectorsInfo := strings.TrimSpace(r.Header.Get("X-Configuration-Sel"))
+	if selectorsInfo == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	optSelectorsInfo := strings.TrimSpace(r.Header.Get("X-Configuration-Opt-Sel"))
+	group := chi.URLParam(r, "group")
+	if group == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	offset, limit, ok := parsePageParams(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	items := c.server.ListKeys(tenantOf(r), selectorsInfo, optSelectorsInfo, group, r.URL.Query().Get("prefix"))
+	if items == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	c.writeResponse(w, cc, &configapi.AdminKeysRes{
+		Code:    "200",
+		Message: "success",
+		Total:   len(items),
+		Items:   paginate(items, offset, limit),
+	})
+}
+
+// adminGetConfiguration returns the configuration as published, the template is not rendered. It is served by the
+// admin api rather than the read api, so that the templates are only visible to the write credentials.
+func (c *ConfigureServer) adminGetConfiguration(w http.ResponseWriter, r *http.Request) {
+	cc := acceptCodec(r)
+	if cc == nil {
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+	selectorsInfo := strings.TrimSpace(r.Header.Get("X-Configuration-Sel"))
+	if selectorsInfo == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	optSelectorsInfo := strings.TrimSpace(r.Header.Get("X-Configuration-Opt-Sel"))
+	group := chi.URLParam(r, "group")
+	key := chi.URLParam(r, "key")
+	if group == "" || key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	cfg, err := c.server.GetRawConfigurationViaPlainRequest(tenantOf(r), group, key, selectorsInfo, optSelectorsInfo)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	c.writeResponse(w, cc, &configapi.GetConfigurationRes{
+		Code:          "200",
+		Message:       "success",
+		Configuration: cfg,
+	})
+}
+
+func (c *ConfigureServer) adminStats(w http.ResponseWriter, r *http.Request) {
+	cc := acceptCodec(r)
+	if cc == nil {
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+	selectorsCount, cfgCount, listenerCount := c.server.Stats(tenantOf(r))
+	c.writeResponse(w, cc, &configapi.AdminStatsRes{
+		Code:               "200",
+		Message:            "success",
+		SelectorsCount:     selectorsCount,
+		ConfigurationCount: cfgCount,
+		ListenerCount:      listenerCount,
+	})
+}
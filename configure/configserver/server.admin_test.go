@@ -0,0 +1,108 @@
+package configserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+func TestServer_AdminQuery(t *testing.T) {
+	s := newServer(PreparedDataPump{}, DefaultVersionComparator{})
+	if err := s.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+
+	_, cancelFn, err := s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
+		Requested: []configapi.RequestedConfigurationKey{
+			{Group: "group1", Key: "key1", Version: "v1"},
+			{Group: "group2", Key: "key2", Version: "v2"},
+		},
+		Selectors: configapi.Selectors{Data: map[string]string{"area": "dc1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancelFn()
+
+	selectors := s.ListSelectors(configapi.DefaultTenant, "")
+	if len(selectors) != 1 || selectors[0].Selectors != "area=dc1" || selectors[0].ConfigurationCount != 3 || selectors[0].ListenerCount != 1 {
+		t.Fatal("unexpected selectors:", selectors)
+	}
+	if len(s.ListSelectors(configapi.DefaultTenant, "dc=")) != 0 {
+		t.Fatal("no selectors expected with unmatched prefix")
+	}
+	groups := s.ListGroups(configapi.DefaultTenant, "area=dc1", "", "group")
+	if len(groups) != 3 || groups[0].Group != "group1" || groups[0].KeyCount != 1 {
+		t.Fatal("unexpected groups:", groups)
+	}
+	if s.ListGroups(configapi.DefaultTenant, "area=dc2", "", "") != nil {
+		t.Fatal("unknown selectors should return nil")
+	}
+	keys := s.ListKeys(configapi.DefaultTenant, "area=dc1", "", "group2", "")
+	if len(keys) != 1 || keys[0].Version != "v2" || keys[0].Timestamp != 2 || keys[0].ListenerCount != 1 {
+		t.Fatal("unexpected keys:", keys)
+	}
+	selectorsCount, cfgCount, listenerCount := s.Stats(configapi.DefaultTenant)
+	if selectorsCount != 1 || cfgCount != 3 || listenerCount != 1 {
+		t.Fatal("unexpected stats:", selectorsCount, cfgCount, listenerCount)
+	}
+}
+
+func TestConfigureServer_AdminListGroupsPagination(t *testing.T) {
+	opt := ConfigureOptions{DataPump: PreparedDataPump{}}
+	opt.WriteApi.DataWriter = &recordDataWriter{}
+	s := NewConfigureServer(opt)
+	if err := s.server.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.server.Shutdown()
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/groups?offset=1&limit=1", nil)
+	req.Header.Set("Accept", "application/cbor")
+	req.Header.Set("X-Configuration-Sel", "area=dc1")
+	w := httptest.NewRecorder()
+	s.writeServer.writeMux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal("unexpected status code:", w.Code)
+	}
+	res := new(configapi.AdminGroupsRes)
+	if err := cbor.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != 3 || len(res.Items) != 1 || res.Items[0].Group != "group2" {
+		t.Fatal("unexpected result:", res)
+	}
+}
+
+func TestConfigureServer_AdminReadOnly(t *testing.T) {
+	// the admin apis are served by the read api if the write api is disabled
+	s := NewConfigureServer(ConfigureOptions{DataPump: PreparedDataPump{}})
+	if err := s.server.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.server.Shutdown()
+
+	get := func(path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", "application/cbor")
+		req.Header.Set("X-Configuration-Sel", "area=dc1")
+		w := httptest.NewRecorder()
+		s.readMux.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := get("/admin/stats"); code != http.StatusOK {
+		t.Fatal("unexpected status code:", code)
+	}
+	if code := get("/admin/groups/group1/keys"); code != http.StatusOK {
+		t.Fatal("unexpected status code:", code)
+	}
+	// the configurations as published are only served by the write api
+	if code := get("/admin/groups/group1/keys/key1"); code != http.StatusNotFound {
+		t.Fatal("unexpected status code:", code)
+	}
+}
@@ -0,0 +1,124 @@
+package configserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+// maxBatchSize is the max number of configurations in a batch publish request
+const maxBatchSize = 100
+
+func newBatchId() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// validatePublishBatchReq validates all the configurations in the batch. Field names are prefixed by 'cfgs[i].'.
+func (c *ConfigureServer) validatePublishBatchReq(tenant string, req *configapi.PublishBatchReq) []configapi.FieldError {
+	if len(req.Configurations) == 0 {
+		return []configapi.FieldError{{Field: "cfgs", Message: "empty batch"}}
+	}
+	if len(req.Configurations) > maxBatchSize {
+		return []configapi.FieldError{{Field: "cfgs", Message: fmt.Sprintf("exceeds max batch size %d", maxBatchSize)}}
+	}
+	var errs []configapi.FieldError
+	existing := map[string]struct{}{}
+	for i, item := range req.Items() {
+		prefix := fmt.Sprintf("cfgs[%d].", i)
+		for _, e := range c.validatePublishReq(tenant, item) {
+			errs = append(errs, configapi.FieldError{Field: prefix + e.Field, Message: e.Message})
+		}
+		cfgKey := item.Configuration.Group + "||" + item.Configuration.Key
+		if _, ok := existing[cfgKey]; ok {
+			errs = append(errs, configapi.FieldError{Field: prefix + "key", Message: "duplicated configuration in batch"})
+		}
+		existing[cfgKey] = struct{}{}
+	}
+	return errs
+}
+
+func (c *ConfigureServer) saveConfigurations(w http.ResponseWriter, r *http.Request) {
+	cc := acceptCodec(r)
+	if cc == nil {
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+	reqCodec := contentCodec(r)
+	if reqCodec == nil {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		c.logError("read http body error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	req := new(configapi.PublishBatchReq)
+	if err := reqCodec.Unmarshal(data, req); err != nil {
+		c.logWarn("parse http body error", err)
+		c.writeResponseWithStatus(w, cc, http.StatusBadRequest, &configapi.PublishRes{
+			Success: false,
+			Code:    "400",
+			Message: "invalid request body",
+			Errors:  []configapi.FieldError{{Field: "body", Message: err.Error()}},
+		})
+		return
+	}
+	tenant := tenantOf(r)
+	validationErrors := c.validatePublishBatchReq(tenant, req)
+	versionValidator, _ := c.server.versionComparator.(configapi.VersionValidator)
+	now := time.Now().Unix()
+	batch := &configapi.BatchInfo{
+		Id:   newBatchId(),
+		Size: len(req.Configurations),
+	}
+	cfgs := make([]configapi.Configuration, 0, len(req.Configurations))
+	for i, item := range req.Items() {
+		cfg := item.ToConfiguration(now)
+		cfg.Tenant = tenant
+		cfg.Batch = batch
+		if generator, ok := c.server.versionComparator.(configapi.VersionGenerator); ok {
+			configapi.GenerateVersions(generator, cfg, "")
+		}
+		validationErrors = append(validationErrors, validateVersion(cfg, versionValidator, fmt.Sprintf("cfgs[%d].version", i))...)
+		cfgs = append(cfgs, *cfg)
+	}
+	if len(validationErrors) > 0 {
+		c.logWarn("invalid configuration batch publish request", validationErrors)
+		c.writeResponseWithStatus(w, cc, http.StatusBadRequest, &configapi.PublishRes{
+			Success: false,
+			Code:    "400",
+			Message: "invalid configuration",
+			Errors:  validationErrors,
+		})
+		return
+	}
+	if !c.checkConfigurationQuota(tenant, cfgs) {
+		c.writeQuotaExceeded(w, cc, tenant)
+		return
+	}
+
+	if err := c.writeServer.writeServer.SaveConfigurations(cfgs, configapi.SaveOptions{
+		Audit:            c.auditInfo(r, req.Operator, req.Description),
+		VersionValidator: versionValidator,
+	}); errors.Is(err, configapi.ErrVersionNotIncreased) {
+		c.logWarn("SaveConfigurations rejected", err)
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if err != nil {
+		c.logError("SaveConfigurations error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,246 @@
+package configserver
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+func postBatch(t *testing.T, s *ConfigureServer, req *configapi.PublishBatchReq) *httptest.ResponseRecorder {
+	data, err := cbor.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/configure/batch", bytes.NewReader(data))
+	r.Header.Set("Accept", "application/cbor")
+	r.Header.Set("Content-Type", "application/cbor")
+	w := httptest.NewRecorder()
+	s.writeServer.writeMux.ServeHTTP(w, r)
+	return w
+}
+
+func TestConfigureServer_PublishBatch(t *testing.T) {
+	dw := &recordDataWriter{}
+	opt := ConfigureOptions{VersionComparatorName: VersionComparatorNumeric}
+	opt.WriteApi.DataWriter = dw
+	s := NewConfigureServer(opt)
+
+	req := &configapi.PublishBatchReq{
+		Configurations: []configapi.RawConfiguration{
+			{Group: "db", Key: "host", Version: "2", Value: []byte("db2.local")},
+			{Group: "db", Key: "credential", Version: "2", Value: []byte("user2:pass2")},
+		},
+		Selectors: configapi.Selectors{Data: map[string]string{"dc": "dc1"}},
+		Operator:  "admin",
+	}
+	if w := postBatch(t, s, req); w.Code != http.StatusOK {
+		t.Fatal("unexpected status code:", w.Code)
+	}
+	if len(dw.saved) != 2 || len(dw.records) != 2 {
+		t.Fatal("2 configurations and audit records expected")
+	}
+	b1, b2 := dw.saved[0].Batch, dw.saved[1].Batch
+	if b1 == nil || b2 == nil || b1.Id == "" || b1.Id != b2.Id || b1.Size != 2 {
+		t.Fatal("configurations should be in the same batch:", b1, b2)
+	}
+	if !dw.saved[0].ValidateSignature() || !dw.saved[1].ValidateSignature() {
+		t.Fatal("configurations should be saved with signature")
+	}
+
+	// none is saved if any version is not increased
+	req.Configurations[0].Version = "3"
+	if w := postBatch(t, s, req); w.Code != http.StatusConflict {
+		t.Fatal("conflict expected:", w.Code)
+	}
+	if len(dw.saved) != 2 {
+		t.Fatal("no configuration should be saved")
+	}
+
+	// invalid batch
+	req.Configurations[1] = configapi.RawConfiguration{Group: "db", Key: "host", Version: "x", Value: []byte("db3.local")}
+	w := postBatch(t, s, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatal("bad request expected:", w.Code)
+	}
+	res := new(configapi.PublishRes)
+	if err := cbor.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]bool{}
+	for _, e := range res.Errors {
+		fields[e.Field] = true
+	}
+	if !fields["cfgs[1].key"] || !fields["cfgs[1].version"] {
+		t.Fatal("unexpected errors:", res.Errors)
+	}
+	if w := postBatch(t, s, &configapi.PublishBatchReq{Selectors: req.Selectors}); w.Code != http.StatusBadRequest {
+		t.Fatal("empty batch should be rejected:", w.Code)
+	}
+}
+
+type channelDataPump struct {
+	PreparedDataPump
+	ch chan configapi.Event
+}
+
+func (c *channelDataPump) EventChannel() <-chan configapi.Event {
+	return c.ch
+}
+
+func TestServer_BatchNotifiedTogether(t *testing.T) {
+	pump := &channelDataPump{ch: make(chan configapi.Event)}
+	s := newServer(pump, DefaultVersionComparator{})
+	if err := s.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = s.Shutdown()
+	}()
+
+	sel := configapi.Selectors{Data: map[string]string{"area": "dc1"}}
+	ch, cancelFn, err := s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
+		Requested: []configapi.RequestedConfigurationKey{
+			{Group: "group1", Key: "key1", Version: "v1"},
+			{Group: "group2", Key: "key2", Version: "v2"},
+		},
+		Selectors: sel,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancelFn()
+
+	batch := &configapi.BatchInfo{Id: "batch1", Size: 2}
+	pump.ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Group: "group1", Key: "key1", Version: "v2", Selectors: sel, Batch: batch}}
+	// the rest of the batch arrives after a pump loop round
+	time.Sleep(800 * time.Millisecond)
+	pump.ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Group: "group2", Key: "key2", Version: "v3", Selectors: sel, Batch: batch}}
+
+	var result []*configapi.Configuration
+	timeout := time.After(3 * time.Second)
+Loop:
+	for {
+		select {
+		case v, ok := <-ch:
+			if !ok {
+				break Loop
+			}
+			result = append(result, v.Configuration)
+		case <-timeout:
+			t.Fatal("wait notification timeout")
+		}
+	}
+	if len(result) != 2 {
+		t.Fatal("the whole batch should be notified together:", len(result))
+	}
+}
+
+func TestCollectPumpEvents_BatchTimeout(t *testing.T) {
+	ch := make(chan configapi.Event, 2)
+	ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Group: "g", Key: "k1", Batch: &configapi.BatchInfo{Id: "b", Size: 3}}}
+	ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Group: "g", Key: "k2", Batch: &configapi.BatchInfo{Id: "b", Size: 3}}}
+	start := time.Now()
+	events := collectPumpEvents(ch, nil, 50, 100*time.Millisecond)
+	if len(events) != 2 || time.Since(start) < 100*time.Millisecond {
+		t.Fatal("should wait for the incomplete batch until timeout")
+	}
+
+	// a non-batch event ends the waiting of the incomplete batch
+	ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Group: "g", Key: "k1", Batch: &configapi.BatchInfo{Id: "b", Size: 2}}}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Group: "g", Key: "k3"}}
+	}()
+	if events := collectPumpEvents(ch, nil, 50, 3*time.Second); len(events) != 2 {
+		t.Fatal("unexpected events:", len(events))
+	}
+}
+
+func TestServer_BatchReadTogether(t *testing.T) {
+	pump := &channelDataPump{ch: make(chan configapi.Event)}
+	s := newServer(pump, DefaultVersionComparator{})
+	s.selectorHierarchy = []string{"area"}
+	if err := s.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = s.Shutdown()
+	}()
+
+	const batches = 200
+	sel := configapi.Selectors{Data: map[string]string{"area": "dc1"}}
+	keys := []string{"key1", "key2", "key3", "key4"}
+	publish := func(n int) {
+		batch := &configapi.BatchInfo{Id: fmt.Sprint("batch", n), Size: len(keys)}
+		for _, k := range keys {
+			pump.ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Group: "group", Key: k, Version: fmt.Sprintf("v%04d", n), Selectors: sel, Batch: batch}}
+		}
+	}
+	publish(0)
+	for i := 0; ; i++ {
+		if store := s.stores.Get(configapi.DefaultTenant, configapi.SelectorsHelperCacheValue(&sel), ""); store != nil && store.GetConfiguration("group", "key4") != nil {
+			break
+		} else if i > 100 {
+			t.Fatal("wait for the initial batch timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	go func() {
+		for n := 1; n <= batches; n++ {
+			publish(n)
+		}
+	}()
+
+	// poll with the oldest version so that any visible update is responded via the lock-free path
+	deadline := time.Now().Add(10 * time.Second)
+	for i := 0; ; i++ {
+		req := &configapi.AcquireConfigurationReq{Selectors: sel, Hierarchical: i%2 == 1}
+		for _, k := range keys {
+			req.Requested = append(req.Requested, configapi.RequestedConfigurationKey{Group: "group", Key: k, Version: "v0000"})
+		}
+		ch, cancelFn, err := s.RetrieveOrWait(configapi.DefaultTenant, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cancelFn()
+		var versions []string
+	Drain:
+		for {
+			select {
+			case ev, ok := <-ch:
+				if !ok {
+					break Drain
+				}
+				versions = append(versions, ev.Configuration.Version)
+			default:
+				// waiting for updates
+				versions = nil
+				break Drain
+			}
+		}
+		if len(versions) == 0 {
+			continue
+		}
+		if len(versions) != len(keys) {
+			t.Fatal("part of the batch responded:", versions)
+		}
+		for _, v := range versions {
+			if v != versions[0] {
+				t.Fatal("configurations of different batches responded:", versions)
+			}
+		}
+		if versions[0] == fmt.Sprintf("v%04d", batches) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("wait for the last batch timeout")
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package configserver
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// codec is the encoding of the request and response bodies. cbor is the primary encoding while json is provided for
+// the clients without a convenient cbor library, e.g. shell scripts.
+type codec struct {
+	ContentType string
+	Marshal     func(v any) ([]byte, error)
+	Unmarshal   func(data []byte, v any) error
+}
+
+var (
+	cborCodec = &codec{ContentType: "application/cbor", Marshal: cbor.Marshal, Unmarshal: cbor.Unmarshal}
+	jsonCodec = &codec{ContentType: "application/json", Marshal: json.Marshal, Unmarshal: json.Unmarshal}
+)
+
+func codecByMediaType(value string) *codec {
+	mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(value))
+	if err != nil {
+		return nil
+	}
+	switch mediaType {
+	case cborCodec.ContentType:
+		return cborCodec
+	case jsonCodec.ContentType:
+		return jsonCodec
+	default:
+		return nil
+	}
+}
+
+// acceptCodec selects the codec of the response by the Accept header, or nil if not acceptable.
+// The supported media type with the highest q-value is selected, and the earlier one wins on the same q-value.
+// cbor is the default for an empty header, */* and application/*, and media types with q=0 are never selected.
+func acceptCodec(r *http.Request) *codec {
+	return negotiateCodec(r, cborCodec)
+}
+
+// negotiateCodec is acceptCodec with the given default codec
+func negotiateCodec(r *http.Request, defaultCodec *codec) *codec {
+	header := strings.TrimSpace(r.Header.Get("Accept"))
+	if header == "" {
+		return defaultCodec
+	}
+	wildcard := []*codec{cborCodec, jsonCodec}
+	if defaultCodec == jsonCodec {
+		wildcard = []*codec{jsonCodec, cborCodec}
+	}
+	type acceptItem struct {
+		codecs []*codec
+		q      float64
+	}
+	var items []acceptItem
+	rejected := map[*codec]bool{}
+	for _, v := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qv, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qv, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		var codecs []*codec
+		switch mediaType {
+		case "*/*", "application/*":
+			codecs = wildcard
+		default:
+			if cc := codecByMediaType(mediaType); cc != nil {
+				codecs = []*codec{cc}
+			}
+		}
+		if len(codecs) == 1 && q == 0 {
+			rejected[codecs[0]] = true
+		}
+		if len(codecs) > 0 && q > 0 {
+			items = append(items, acceptItem{codecs: codecs, q: q})
+		}
+	}
+	var selected *codec
+	var selectedQ float64
+	for _, item := range items {
+		for _, cc := range item.codecs {
+			if !rejected[cc] {
+				if item.q > selectedQ {
+					selected, selectedQ = cc, item.q
+				}
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// contentCodec returns the codec of the request body by the Content-Type header, or nil if not supported
+func contentCodec(r *http.Request) *codec {
+	return codecByMediaType(r.Header.Get("Content-Type"))
+}
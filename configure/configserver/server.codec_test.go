@@ -0,0 +1,166 @@
+package configserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+// TestConfigureServer_Codecs runs the same cases through both cbor and json encodings
+func TestConfigureServer_Codecs(t *testing.T) {
+	for _, cc := range []*codec{cborCodec, jsonCodec} {
+		t.Run(cc.ContentType, func(t *testing.T) {
+			testCodecConformance(t, cc)
+		})
+	}
+}
+
+func testCodecConformance(t *testing.T, cc *codec) {
+	dw := &recordDataWriter{}
+	opt := ConfigureOptions{DataPump: PreparedDataPump{}}
+	opt.WriteApi.DataWriter = dw
+	s := NewConfigureServer(opt)
+	s.server.dumpFromPump()
+
+	do := func(mux http.Handler, method, path string, headers map[string]string, body any) *httptest.ResponseRecorder {
+		var data []byte
+		if body != nil {
+			var err error
+			if data, err = cc.Marshal(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r := httptest.NewRequest(method, path, bytes.NewReader(data))
+		r.Header.Set("Accept", cc.ContentType)
+		if body != nil {
+			r.Header.Set("Content-Type", cc.ContentType)
+		}
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder, status int, obj any) {
+		if w.Code != status {
+			t.Fatal("unexpected status code:", w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != cc.ContentType {
+			t.Fatal("unexpected content type:", ct)
+		}
+		if err := cc.Unmarshal(w.Body.Bytes(), obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sel := configapi.Selectors{Data: map[string]string{"area": "dc1"}}
+
+	// retrieving
+	retrieved := new(configapi.AcquireConfigurationRes)
+	decode(do(s.readMux, http.MethodPost, "/retrieving", nil, &configapi.AcquireConfigurationReq{
+		Requested: []configapi.RequestedConfigurationKey{{Group: "group1", Key: "key1"}},
+		Selectors: sel,
+	}), http.StatusOK, retrieved)
+	if len(retrieved.Requested) != 1 || string(retrieved.Requested[0].Value) != "value1" || retrieved.Requested[0].Selectors.Data["area"] != "dc1" {
+		t.Fatal("unexpected retrieving result:", retrieved)
+	}
+	if w := do(s.readMux, http.MethodPost, "/retrieving", nil, &configapi.AcquireConfigurationReq{
+		Requested: []configapi.RequestedConfigurationKey{{Group: "group1", Key: "unknown"}},
+		Selectors: sel,
+	}); w.Code != http.StatusNotFound {
+		t.Fatal("not found expected:", w.Code)
+	}
+
+	// get configuration
+	got := new(configapi.GetConfigurationRes)
+	decode(do(s.readMux, http.MethodGet, "/configure/group2/key2", map[string]string{"X-Configuration-Sel": "area=dc1"}, nil), http.StatusOK, got)
+	if got.Code != "200" || got.Configuration.Version != "v2" || string(got.Configuration.Value) != "value2" {
+		t.Fatal("unexpected configuration:", got)
+	}
+
+	// publish
+	if w := do(s.writeServer.writeMux, http.MethodPost, "/configure", nil, &configapi.PublishReq{
+		Configuration: configapi.RawConfiguration{Group: "group1", Key: "key1", Version: "v3", Value: []byte("value3")},
+		Selectors:     sel,
+		Operator:      "admin",
+	}); w.Code != http.StatusOK {
+		t.Fatal("unexpected publish status code:", w.Code)
+	}
+	if len(dw.saved) != 1 || string(dw.saved[0].Value) != "value3" || dw.records[0].Operator != "admin" {
+		t.Fatal("configuration should be saved:", dw.saved)
+	}
+	invalid := new(configapi.PublishRes)
+	decode(do(s.writeServer.writeMux, http.MethodPost, "/configure", nil, &configapi.PublishReq{
+		Configuration: configapi.RawConfiguration{Key: "key1", Version: "v4", Value: []byte("value4")},
+		Selectors:     sel,
+	}), http.StatusBadRequest, invalid)
+	if invalid.Success || len(invalid.Errors) == 0 || invalid.Errors[0].Field != "group" {
+		t.Fatal("unexpected publish errors:", invalid)
+	}
+	if w := do(s.writeServer.writeMux, http.MethodPost, "/configure/batch", nil, &configapi.PublishBatchReq{
+		Configurations: []configapi.RawConfiguration{{Group: "group2", Key: "key2", Version: "v3", Value: []byte("value3")}},
+		Selectors:      sel,
+	}); w.Code != http.StatusOK {
+		t.Fatal("unexpected batch publish status code:", w.Code)
+	}
+
+	// admin and audit
+	stats := new(configapi.AdminStatsRes)
+	decode(do(s.writeServer.writeMux, http.MethodGet, "/admin/stats", nil, nil), http.StatusOK, stats)
+	if stats.ConfigurationCount != 3 {
+		t.Fatal("unexpected stats:", stats)
+	}
+	audit := new(configapi.AuditQueryRes)
+	decode(do(s.writeServer.writeMux, http.MethodGet, "/audit?group=group1", nil, nil), http.StatusOK, audit)
+	if len(audit.Records) != 1 || audit.Records[0].NewVersion != "v3" {
+		t.Fatal("unexpected audit records:", audit.Records)
+	}
+
+	// unsupported encodings
+	if w := do(s.readMux, http.MethodGet, "/configure/group2/key2", map[string]string{"X-Configuration-Sel": "area=dc1", "Accept": "text/plain"}, nil); w.Code != http.StatusNotAcceptable {
+		t.Fatal("not acceptable expected:", w.Code)
+	}
+	for _, path := range []string{"/configure", "/configure/batch"} {
+		for _, contentType := range []string{"text/plain", ""} {
+			if w := do(s.writeServer.writeMux, http.MethodPost, path, map[string]string{"Content-Type": contentType}, &configapi.PublishReq{}); w.Code != http.StatusUnsupportedMediaType {
+				t.Fatal("unsupported media type expected:", path, contentType, w.Code)
+			}
+		}
+	}
+}
+
+func TestAcceptCodec(t *testing.T) {
+	for header, expected := range map[string]*codec{
+		"":                                  cborCodec,
+		"*/*":                               cborCodec,
+		"application/*":                     cborCodec,
+		"text/plain":                        nil,
+		"application/cbor":                  cborCodec,
+		"application/json; charset=utf-8":   jsonCodec,
+		"text/html, application/json;q=0.9": jsonCodec,
+		// q-values
+		"application/cbor;q=0.5, application/json":       jsonCodec,
+		"application/json;q=0.8, application/cbor;q=0.8": jsonCodec,
+		"application/json;q=0.5, */*;q=0.9":              cborCodec,
+		"application/cbor;q=0, */*":                      jsonCodec,
+		"application/json;q=0":                           nil,
+		"application/json;q=invalid":                     nil,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", header)
+		if cc := acceptCodec(r); cc != expected {
+			t.Fatal("unexpected codec:", header, cc)
+		}
+	}
+	// json preferred, e.g. readiness probes
+	for _, header := range []string{"", "*/*"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", header)
+		if cc := negotiateCodec(r, jsonCodec); cc != jsonCodec {
+			t.Fatal("json expected:", header, cc)
+		}
+	}
+}
@@ -3,7 +3,8 @@ package configserver
 import (
 	"context"
 	"errors"
-	"log"
+	"maps"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -18,12 +19,37 @@ var (
 type server struct {
 	pump configapi.DataPump
 
-	closeCh chan struct{}
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	// started is set after the initial dump completes
+	started atomic.Bool
+	// loops tracks pumpLoop and scheduleLoop for shutdown
+	loops sync.WaitGroup
 
-	rwlock   sync.RWMutex
 	cachedId atomic.Int64
 
-	selectorsMap selectorsMap // access should be protected by rwlock
+	// stores contains the selector combinations of all tenants. Each store has its own lock, and the visible
+	// configurations are read without lock.
+	stores *storeRegistry
+	// trackLock protects scheduledStores and templateStores. It is acquired after the lock of a store, so that the
+	// tracking changes together with the store.
+	trackLock sync.Mutex
+	// scheduledStores contains the stores with pending scheduled transitions
+	scheduledStores map[*selectorsStore]struct{}
+	// templateStores contains the stores with templates to be rendered
+	templateStores map[*selectorsStore]templateScope
+	// selectorHierarchy is the selector keys from the highest priority for the hierarchical mode
+	selectorHierarchy []string
+	// renderLock serializes the rendering rounds and protects the fields below
+	renderLock sync.Mutex
+	// renderErrors is the last rendering errors of the templates
+	renderErrors map[string]string
+	// dependents indexes the templates by the configurations and the secrets they reference
+	dependents map[dependency]map[templateRef]struct{}
+	// dependencies is the reverse of dependents
+	dependencies map[templateRef][]dependency
+	// secrets resolves ${secret:name} of the templates, nil if no SecretResolver is configured
+	secrets *secretCache
 
 	versionComparator configapi.VersionComparator
 }
@@ -38,8 +64,17 @@ func (s *server) nextId() int64 {
 //
 // Note1: The reason to return the cancel fn rather than wait inside the method => let the caller decide keep waiting or cancel
 //
-// Note2: Result channel will pull update only. For deleted items, it should be figured out in the next request. This is because the configuration that is in use should not be deleted to avoid potential live issues.
-func (s *server) RetrieveOrWait(req *configapi.AcquireConfigurationReq) (NotifyChannel, context.CancelFunc, error) {
+// Note2: Result channel contains updates and tombstones(NotifyEvent.Deleted) of the deleted configurations. A requested
+// configuration which is missing is treated as deleted if the request carries its version, otherwise it is unknown.
+// Requested configurations marked as deleted wait for recreation. Whether to stop using the deleted configurations is
+// decided by the client, since the configuration in use should not be dropped to avoid potential live issues.
+//
+// Note3: Requests in hierarchical mode(AcquireConfigurationReq.Hierarchical) are served by retrieveOrWaitHierarchical
+// if the selector hierarchy is declared.
+func (s *server) RetrieveOrWait(tenant string, req *configapi.AcquireConfigurationReq) (NotifyChannel, context.CancelFunc, error) {
+	if req.Hierarchical && len(s.selectorHierarchy) > 0 {
+		return s.retrieveOrWaitHierarchical(tenant, req)
+	}
 	configapi.SelectorsHelperCache(&req.Selectors)
 	selectorsKey := configapi.SelectorsHelperCacheValue(&req.Selectors)
 	configapi.SelectorsHelperCache(&req.OptionalSelectors)
@@ -47,37 +82,16 @@ func (s *server) RetrieveOrWait(req *configapi.AcquireConfigurationReq) (NotifyC
 	// no need to guarantee uniqueness since int64 is large enough to support rewinding beyond a short period(generally before CancelFunc is called)
 	reqid := s.nextId()
 
-	//step1. try retrieve configurations by request
-	f1 := func() (r []*configapi.Configuration, ready bool) {
-		s.rwlock.RLock()
-		defer s.rwlock.RUnlock()
-		var store = s.selectorsMap.GetSelectorsGeneral(selectorsKey, optSelectorsKey)
-		if store == nil {
-			return nil, false
-		}
-		for _, v := range req.Requested {
-			cfg := store.GetConfiguration(v.Group, v.Key)
-			if cfg == nil {
-				return nil, false
-			}
-			if s.versionComparator.HasUpdate(v.Version, cfg.Version) {
-				r = append(r, cfg)
-			}
-		}
-		return r, true
+	//step1. try retrieve configurations by request without lock
+	store := s.stores.Get(tenant, selectorsKey, optSelectorsKey)
+	if store == nil {
+		return nil, nil, ErrHasUnknownConfiguration
 	}
-	if result, ready := f1(); !ready {
+	if result, ready := s.checkRequested(store, req.Requested); !ready {
 		return nil, nil, ErrHasUnknownConfiguration
 	} else if len(result) > 0 {
 		// directly respond configurations
-		ch := make(NotifyChannel, len(result))
-		for _, cfg := range result {
-			ch <- NotifyEvent{
-				Configuration: cfg,
-			}
-		}
-		close(ch)
-		return ch, func() {}, nil
+		return respondEvents(result), func() {}, nil
 	}
 
 	//step2. wait for all data
@@ -92,38 +106,29 @@ func (s *server) RetrieveOrWait(req *configapi.AcquireConfigurationReq) (NotifyC
 			Key   string
 		}{Group: v.Group, Key: v.Key})
 	}
-	f2 := func() (r []*configapi.Configuration, ch NotifyChannel, cancelFn context.CancelFunc, ready bool) {
-		s.rwlock.Lock()
-		defer s.rwlock.Unlock()
-
-		var store = s.selectorsMap.GetSelectorsGeneral(selectorsKey, optSelectorsKey)
-		if store == nil {
+	f2 := func() (r []NotifyEvent, ch NotifyChannel, cancelFn context.CancelFunc, ready bool) {
+		// the store may be replaced by a more specific one created in between
+		store := s.stores.Get(tenant, selectorsKey, optSelectorsKey)
+		store.lock.Lock()
+		defer store.lock.Unlock()
+
+		// pre-check configurations, the updates are published under the lock of the store so no update is lost
+		r, ready = s.checkRequested(store, req.Requested)
+		if !ready {
 			return nil, nil, nil, false
 		}
-		// pre-check configurations
-		for _, v := range req.Requested {
-			cfg := store.GetConfiguration(v.Group, v.Key)
-			if cfg == nil {
-				return nil, nil, nil, false
-			}
-			if s.versionComparator.HasUpdate(v.Version, cfg.Version) {
-				r = append(r, cfg)
-			}
-		}
 		// respond immediately if new updates found without registering listeners
 		if len(r) > 0 {
 			return r, nil, func() {}, true
 		}
 		// register listeners
 		notifyCh := make(NotifyChannel, len(req.Requested))
+		l := &listener{ch: notifyCh}
 		for _, v := range req.Requested {
-			store.RegisterListener(reqid, v.Group, v.Key, notifyCh)
+			store.RegisterListener(reqid, v.Group, v.Key, l)
 		}
 		// prepare cancel
 		cfn := func() {
-			s.rwlock.Lock()
-			defer s.rwlock.Unlock()
-
 			store.CancelWait(reqid, waitList)
 		}
 		return nil, notifyCh, cfn, true
@@ -133,23 +138,53 @@ func (s *server) RetrieveOrWait(req *configapi.AcquireConfigurationReq) (NotifyC
 		return nil, nil, ErrHasUnknownConfiguration
 	}
 	if len(res) > 0 {
-		ch := make(NotifyChannel, len(res))
-		for _, v := range res {
-			ch <- NotifyEvent{
-				Configuration: v,
-			}
-		}
-		close(ch)
-		return ch, func() {}, nil
+		return respondEvents(res), func() {}, nil
 	} else {
 		return ch, cfn, nil
 	}
 }
 
-func (s *server) GetConfigurationViaPlainRequest(group, key string, selectors, optSelector string) (configapi.Configuration, error) {
-	s.rwlock.RLock()
-	defer s.rwlock.RUnlock()
-	store := s.selectorsMap.GetSelectorsGeneral(selectors, optSelector)
+// checkRequested returns the updates and the tombstones of the requested configurations.
+// ready is false if any of the requested configurations is unknown.
+// All of them are read from the same snapshot, so the configurations published together are responded all or none.
+func (s *server) checkRequested(store *selectorsStore, requested []configapi.RequestedConfigurationKey) (r []NotifyEvent, ready bool) {
+	snapshot := store.snapshot.Load()
+	for _, v := range requested {
+		cfg := snapshot.visible(v.Group, v.Key)
+		if cfg == nil {
+			switch {
+			case v.Deleted:
+				// wait for recreation
+			case v.Version == "":
+				return nil, false
+			default:
+				r = append(r, NotifyEvent{
+					Configuration: &configapi.Configuration{Group: v.Group, Key: v.Key, Version: v.Version},
+					Deleted:       true,
+				})
+			}
+			continue
+		}
+		if v.Deleted || s.hasUpdate(v.Version, cfg) {
+			r = append(r, NotifyEvent{
+				Configuration: cfg,
+			})
+		}
+	}
+	return r, true
+}
+
+func respondEvents(events []NotifyEvent) NotifyChannel {
+	ch := make(NotifyChannel, len(events))
+	for _, ev := range events {
+		ch <- ev
+	}
+	close(ch)
+	return ch
+}
+
+func (s *server) GetConfigurationViaPlainRequest(tenant, group, key string, selectors, optSelector string) (configapi.Configuration, error) {
+	store := s.stores.Get(tenant, selectors, optSelector)
 	if store == nil {
 		return configapi.Configuration{}, ErrHasUnknownConfiguration
 	}
@@ -160,88 +195,88 @@ func (s *server) GetConfigurationViaPlainRequest(group, key string, selectors, o
 	return *cfg, nil
 }
 
+// GetHistoryConfiguration returns the previous configuration of the version kept for the delta encoding, or nil
+func (s *server) GetHistoryConfiguration(tenant, selectorsKey, optSelectorsKey, group, key, version string) *configapi.Configuration {
+	store := s.stores.Get(tenant, selectorsKey, optSelectorsKey)
+	if store == nil {
+		return nil
+	}
+	return store.GetHistoryConfiguration(group, key, version)
+}
+
+func (s *server) getOrCreateStore(configuration *configapi.Configuration) *selectorsStore {
+	return s.stores.GetOrCreate(configuration.Tenant, configapi.SelectorsHelperCacheValue(&configuration.Selectors), configapi.SelectorsHelperCacheValue(&configuration.OptionalSelectors))
+}
+
+// saveConfiguration saves the configuration to its store and tracks the store for the scheduled transitions and the
+// template rendering
+func (s *server) saveConfiguration(configuration *configapi.Configuration, round *notifyRound) {
+	store := s.getOrCreateStore(configuration)
+	store.Update(func() {
+		s.saveToStore(store, configuration, round)
+	})
+}
+
+// saveToStore is saveConfiguration with the lock of the store held
+func (s *server) saveToStore(store *selectorsStore, configuration *configapi.Configuration, round *notifyRound) {
+	scheduled := store.saveConfiguration(configuration, round)
+	template := configuration.Template || (configuration.Fallback != nil && configuration.Fallback.Template)
+	if !scheduled && !template {
+		return
+	}
+	s.trackLock.Lock()
+	defer s.trackLock.Unlock()
+	if scheduled {
+		s.scheduledStores[store] = struct{}{}
+	}
+	if template {
+		s.templateStores[store] = newTemplateScope(configuration)
+	}
+}
+
 func (s *server) dumpFromPump() {
 	// full dump from pump
-	emptyMap := map[int64]NotifyChannel{}
+	round := newNotifyRound()
 	for ev := range s.pump.TriggerDumpToChannel() {
-		store := s.selectorsMap.GetOrCreateSelectorsGeneral(configapi.SelectorsHelperCacheValue(&ev.Configuration.Selectors), configapi.SelectorsHelperCacheValue(&ev.Configuration.OptionalSelectors))
-		store.SaveConfigurationWithNotification(ev.Configuration, emptyMap)
+		s.saveConfiguration(ev.Configuration, round)
 	}
+	s.renderTemplates(round)
+	// no listener before started
+	round.Flush()
 }
 
-func (s *server) pumpLoop() {
-Overall:
+// scheduleLoop releases the scheduled configurations to the waiting listeners when the time arrives
+func (s *server) scheduleLoop() {
+	defer s.loops.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-s.closeCh:
-			break Overall
-		default:
+			return
+		case <-ticker.C:
 		}
+		s.applySchedules(time.Now().Unix())
+	}
+}
 
-		f := func() {
-			// collect events
-			// support merge changes to optimize waiting task notification
-			ch := s.pump.EventChannel()
-			const maxCnt = 50
-			events := make([]configapi.Event, 0, maxCnt)
-		PumpLoop:
-			for i := 0; i < maxCnt; i++ {
-				select {
-				case ev, ok := <-ch:
-					if ok {
-						events = append(events, ev)
-					}
-				default:
-					break PumpLoop
-				}
-			}
-			// generate selectors cache
-			for _, ev := range events {
-				if ev.Configuration != nil {
-					configapi.SelectorsHelperCache(&ev.Configuration.Selectors)
-					configapi.SelectorsHelperCache(&ev.Configuration.OptionalSelectors)
-				}
-			}
-
-			chMap := make(map[int64]NotifyChannel)
-			// apply change events
-			s.rwlock.Lock()
-			defer s.rwlock.Unlock()
-			// dedup and merge events
-			dedupFn := func() {
-				dedupMap := make(map[string]configapi.Event)
-				for _, ev := range events {
-					key := ev.Configuration.Group + "||" + ev.Configuration.Key
-					dedupMap[key] = ev
-				}
-				var newEvents []configapi.Event
-				for _, v := range events {
-					newEvents = append(newEvents, v)
-				}
-				events = newEvents
-			}
-			dedupFn()
-			for _, ev := range events {
-				if ev.Created || ev.Modified {
-					store := s.selectorsMap.GetOrCreateSelectorsGeneral(configapi.SelectorsHelperCacheValue(&ev.Configuration.Selectors), configapi.SelectorsHelperCacheValue(&ev.Configuration.OptionalSelectors))
-					store.SaveConfigurationWithNotification(ev.Configuration, chMap)
-				} else if ev.Deleted {
-					store := s.selectorsMap.GetOrCreateSelectorsGeneral(configapi.SelectorsHelperCacheValue(&ev.Configuration.Selectors), configapi.SelectorsHelperCacheValue(&ev.Configuration.OptionalSelectors))
-					store.DeleteConfiguration(ev.Configuration)
-				} else {
-					//FIXME print error information of unknown event operation
-					log.Println("unknown pump event type")
-				}
-			}
-
-			// close client notifyChannel
-			for _, v := range chMap {
-				close(v)
+func (s *server) applySchedules(now int64) {
+	round := newNotifyRound()
+	s.trackLock.Lock()
+	stores := slices.Collect(maps.Keys(s.scheduledStores))
+	s.trackLock.Unlock()
+	for _, store := range stores {
+		store.Update(func() {
+			if !store.applySchedules(now, round) {
+				s.trackLock.Lock()
+				delete(s.scheduledStores, store)
+				s.trackLock.Unlock()
 			}
-		}
-		f()
-		time.Sleep(500 * time.Millisecond)
+		})
 	}
+	// the scheduled transitions and the refreshed secrets may change the rendered templates
+	s.renderTemplates(round)
+	round.Flush()
 }
 
 func (s *server) Startup() error {
@@ -249,16 +284,35 @@ func (s *server) Startup() error {
 		return err
 	}
 	s.dumpFromPump()
+	s.loops.Add(2)
 	go s.pumpLoop()
+	go s.scheduleLoop()
+	s.started.Store(true)
 	return nil
 }
 
 func (s *server) Shutdown() error {
-	if err := s.pump.Stop(); err != nil {
-		return err
+	return s.ShutdownContext(context.Background())
+}
+
+// ShutdownContext stops the loops first and then the pump, so the pump is not consumed after stopped.
+// The pump is stopped even if the loops do not exit before the deadline of ctx. It is safe to be called more than once.
+func (s *server) ShutdownContext(ctx context.Context) error {
+	s.closeOnce.Do(func() {
+		close(s.closeCh)
+	})
+	done := make(chan struct{})
+	go func() {
+		s.loops.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
 	}
-	close(s.closeCh)
-	return nil
+	return errors.Join(err, s.pump.Stop())
 }
 
 func newServer(pump configapi.DataPump, versionComparator configapi.VersionComparator) *server {
@@ -267,127 +321,23 @@ func newServer(pump configapi.DataPump, versionComparator configapi.VersionCompa
 
 		closeCh: make(chan struct{}, 1),
 
-		selectorsMap: selectorsMap{},
+		stores:          newStoreRegistry(),
+		scheduledStores: map[*selectorsStore]struct{}{},
+		templateStores:  map[*selectorsStore]templateScope{},
+		renderErrors:    map[string]string{},
+		dependents:      map[dependency]map[templateRef]struct{}{},
+		dependencies:    map[templateRef][]dependency{},
 
 		versionComparator: versionComparator,
 	}
 }
 
-type selectorsMap map[string]*struct {
-	SelectorsStore    *selectorsStore
-	OptSelectorsStore map[string]*selectorsStore
-}
-
-func (s selectorsMap) GetSelectorsGeneral(selectorsKey, optSelectorsKey string) *selectorsStore {
-	// optSelectorsKey not empty: 1st get by selectorsKey + optSelectorsKey, otherwise get by selectorsKey, otherwise nil
-	// selectorsKey empty: get by selectorsKey, otherwise nil
-	if v, ok := s[selectorsKey]; ok {
-		if optSelectorsKey == "" {
-			return v.SelectorsStore
-		}
-		if vv, ok := v.OptSelectorsStore[optSelectorsKey]; ok {
-			return vv
-		} else {
-			return v.SelectorsStore
-		}
-	}
-	return nil
-}
-
-func (s selectorsMap) GetOrCreateSelectorsGeneral(selectorsKey, optSelectorsKey string) *selectorsStore {
-	if v, ok := s[selectorsKey]; ok {
-		if optSelectorsKey == "" {
-			return v.SelectorsStore
-		}
-		if vv, ok := v.OptSelectorsStore[optSelectorsKey]; ok {
-			return vv
-		} else {
-			vv = newSelectorsStore()
-			v.OptSelectorsStore[optSelectorsKey] = vv
-			return vv
-		}
-	} else {
-		v = &struct {
-			SelectorsStore    *selectorsStore
-			OptSelectorsStore map[string]*selectorsStore
-		}{SelectorsStore: newSelectorsStore(), OptSelectorsStore: make(map[string]*selectorsStore)}
-		s[selectorsKey] = v
-		if optSelectorsKey != "" {
-			store := newSelectorsStore()
-			v.OptSelectorsStore[optSelectorsKey] = store
-			return store
-		} else {
-			return v.SelectorsStore
-		}
-	}
-}
-
-type selectorsStore struct {
-	data      map[string]*configapi.Configuration
-	listeners map[string]map[int64]struct {
-		ch NotifyChannel
-	}
-}
-
-func newSelectorsStore() *selectorsStore {
-	return &selectorsStore{
-		data:      make(map[string]*configapi.Configuration),
-		listeners: map[string]map[int64]struct{ ch NotifyChannel }{},
-	}
-}
-
-func (s *selectorsStore) cfgKey(group, key string) string {
-	return group + "||" + key
-}
-
-func (s *selectorsStore) GetConfiguration(group, key string) *configapi.Configuration {
-	return s.data[s.cfgKey(group, key)]
-}
-
-func (s *selectorsStore) RegisterListener(reqid int64, group string, key string, ch NotifyChannel) {
-	m := s.listeners[s.cfgKey(group, key)]
-	if m == nil {
-		m = map[int64]struct{ ch NotifyChannel }{}
-		s.listeners[s.cfgKey(group, key)] = m
-	}
-	m[reqid] = struct {
-		ch NotifyChannel
-	}{
-		ch: ch,
-	}
-}
-
-func (s *selectorsStore) CancelWait(reqid int64, list []struct {
-	Group string
-	Key   string
-}) {
-	for _, v := range list {
-		delete(s.listeners[s.cfgKey(v.Group, v.Key)], reqid)
-	}
-}
-
-func (s *selectorsStore) SaveConfigurationWithNotification(configuration *configapi.Configuration, chMap map[int64]NotifyChannel) {
-	key := s.cfgKey(configuration.Group, configuration.Key)
-	s.data[key] = configuration
-	if listenerMap, ok := s.listeners[key]; ok {
-		for k, v := range listenerMap {
-			v.ch <- NotifyEvent{
-				Configuration: configuration,
-			}
-			chMap[k] = v.ch
-		}
-		delete(s.listeners, key)
-	}
-}
-
-func (s *selectorsStore) DeleteConfiguration(configuration *configapi.Configuration) {
-	key := s.cfgKey(configuration.Group, configuration.Key)
-	delete(s.listeners, key)
-	delete(s.data, key)
-}
-
 type NotifyEvent struct {
+	// Configuration is the updated configuration, or only contains group, key and the last version if Deleted
 	Configuration *configapi.Configuration
+	Deleted       bool
 }
 
+// NotifyChannel receives the events of the waiting request and is closed after the events. A channel closed without
+// events releases the waiting without changes, and the client polls again.
 type NotifyChannel chan NotifyEvent
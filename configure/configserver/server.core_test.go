@@ -1,9 +1,16 @@
 package configserver
 
 import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/fxamacker/cbor/v2"
+
 	"github.com/meidoworks/nekoq-component/configure/configapi"
 )
 
@@ -18,6 +25,10 @@ func (p PreparedDataPump) Startup() error {
 	return nil
 }
 
+func (p PreparedDataPump) Health() configapi.PumpHealth {
+	return configapi.PumpHealth{Healthy: true}
+}
+
 func (p PreparedDataPump) EventChannel() <-chan configapi.Event {
 	return make(chan configapi.Event)
 }
@@ -84,7 +95,7 @@ func TestServer_RetrieveOrWait_Basic1(t *testing.T) {
 	if err := s.Startup(); err != nil {
 		t.Fatal(err)
 	}
-	ch, cancelFunc, err := s.RetrieveOrWait(&configapi.AcquireConfigurationReq{
+	ch, cancelFunc, err := s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
 		Requested: []configapi.RequestedConfigurationKey{
 			{
 				Group:   "group1",
@@ -161,6 +172,10 @@ func (u UpdateDataPump) Startup() error {
 	return nil
 }
 
+func (u UpdateDataPump) Health() configapi.PumpHealth {
+	return configapi.PumpHealth{Healthy: true}
+}
+
 func (u UpdateDataPump) EventChannel() <-chan configapi.Event {
 	return u.ch
 }
@@ -194,7 +209,7 @@ func TestServer_RetrieveOrWait_Basic2(t *testing.T) {
 	if err := s.Startup(); err != nil {
 		t.Fatal(err)
 	}
-	ch, cancelFunc, err := s.RetrieveOrWait(&configapi.AcquireConfigurationReq{
+	ch, cancelFunc, err := s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
 		Requested: []configapi.RequestedConfigurationKey{
 			{
 				Group:   "group1",
@@ -228,7 +243,7 @@ func TestServer_RetrieveOrWait_Basic2(t *testing.T) {
 
 	// wait update until timeout
 	timer := time.NewTimer(2 * time.Second)
-	ch, cancelFunc, err = s.RetrieveOrWait(&configapi.AcquireConfigurationReq{
+	ch, cancelFunc, err = s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
 		Requested: []configapi.RequestedConfigurationKey{
 			{
 				Group:   "group1",
@@ -260,7 +275,7 @@ func TestServer_RetrieveOrWait_Basic2(t *testing.T) {
 
 	// wait until update
 	timer = time.NewTimer(2 * time.Second)
-	ch, cancelFunc, err = s.RetrieveOrWait(&configapi.AcquireConfigurationReq{
+	ch, cancelFunc, err = s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
 		Requested: []configapi.RequestedConfigurationKey{
 			{
 				Group:   "group1",
@@ -318,3 +333,169 @@ func TestServer_RetrieveOrWait_Basic2(t *testing.T) {
 	timer.Stop()
 	cancelFunc()
 }
+
+func TestServer_RetrieveOrWait_Deleted(t *testing.T) {
+	pump := &channelDataPump{ch: make(chan configapi.Event)}
+	s := newServer(pump, DefaultVersionComparator{})
+	if err := s.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = s.Shutdown()
+	}()
+
+	sel := configapi.Selectors{Data: map[string]string{"area": "dc1"}}
+	retrieve := func(requested ...configapi.RequestedConfigurationKey) (NotifyChannel, func()) {
+		ch, cancelFn, err := s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
+			Requested: requested,
+			Selectors: sel,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ch, cancelFn
+	}
+	receive := func(ch NotifyChannel) NotifyEvent {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				t.Fatal("channel closed without events")
+			}
+			return ev
+		case <-time.After(3 * time.Second):
+			t.Fatal("wait for event timeout")
+		}
+		return NotifyEvent{}
+	}
+
+	// the listener is woken up with the tombstone
+	ch, cancelFn := retrieve(configapi.RequestedConfigurationKey{Group: "group1", Key: "key1", Version: "v1"})
+	pump.ch <- configapi.Event{Deleted: true, Configuration: &configapi.Configuration{Group: "group1", Key: "key1", Selectors: sel}}
+	if ev := receive(ch); !ev.Deleted || ev.Configuration.Key != "key1" || ev.Configuration.Version != "v1" {
+		t.Fatal("tombstone expected:", ev)
+	}
+	cancelFn()
+
+	// the client with the last version receives the tombstone immediately
+	ch, cancelFn = retrieve(configapi.RequestedConfigurationKey{Group: "group1", Key: "key1", Version: "v1"})
+	if ev := receive(ch); !ev.Deleted {
+		t.Fatal("tombstone expected:", ev)
+	}
+	cancelFn()
+	// the configuration without version is still unknown
+	if _, _, err := s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
+		Requested: []configapi.RequestedConfigurationKey{{Group: "group1", Key: "key1"}},
+		Selectors: sel,
+	}); err != ErrHasUnknownConfiguration {
+		t.Fatal("unknown configuration expected:", err)
+	}
+
+	// the client knowing the deletion waits for the recreation
+	ch, cancelFn = retrieve(
+		configapi.RequestedConfigurationKey{Group: "group1", Key: "key1", Version: "v1", Deleted: true},
+		configapi.RequestedConfigurationKey{Group: "group2", Key: "key2", Version: "v2"},
+	)
+	defer cancelFn()
+	select {
+	case ev := <-ch:
+		t.Fatal("should wait for the recreation:", ev)
+	default:
+	}
+	pump.ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Group: "group1", Key: "key1", Version: "v1", Selectors: sel}}
+	if ev := receive(ch); ev.Deleted || ev.Configuration.Version != "v1" {
+		t.Fatal("recreated configuration expected:", ev)
+	}
+}
+
+func TestConfigureOptions_GetWaitTimeForUpdate(t *testing.T) {
+	opt := ConfigureOptions{MaxWaitTimeForUpdate: 60, WaitTimeJitter: -1}
+	for _, c := range []struct {
+		requested int
+		expected  time.Duration
+	}{
+		{0, 60 * time.Second},
+		{25, 25 * time.Second},
+		{1, 5 * time.Second},
+		{120, 60 * time.Second},
+	} {
+		if wait := opt.GetWaitTimeForUpdate(c.requested); wait != c.expected {
+			t.Fatal("unexpected wait time:", c.requested, wait)
+		}
+	}
+
+	opt.WaitTimeJitter = 0
+	jittered := false
+	for i := 0; i < 100; i++ {
+		wait := opt.GetWaitTimeForUpdate(25)
+		if wait > 25*time.Second || wait < 22500*time.Millisecond {
+			t.Fatal("wait time out of the jitter range:", wait)
+		}
+		if wait != 25*time.Second {
+			jittered = true
+		}
+	}
+	if !jittered {
+		t.Fatal("wait time should be jittered")
+	}
+}
+
+type stopRecordDataPump struct {
+	channelDataPump
+	stopped atomic.Bool
+}
+
+func (p *stopRecordDataPump) Stop() error {
+	p.stopped.Store(true)
+	return nil
+}
+
+func TestConfigureServer_ShutdownDrain(t *testing.T) {
+	pump := &stopRecordDataPump{channelDataPump: channelDataPump{ch: make(chan configapi.Event)}}
+	s := NewConfigureServer(ConfigureOptions{DataPump: pump})
+	if err := s.server.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(s.readMux)
+	defer httpServer.Close()
+
+	data, err := cbor.Marshal(&configapi.AcquireConfigurationReq{
+		Requested: []configapi.RequestedConfigurationKey{{Group: "group1", Key: "key1", Version: "v1"}},
+		Selectors: configapi.Selectors{Data: map[string]string{"area": "dc1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resCh := make(chan *http.Response, 1)
+	go func() {
+		r, _ := http.NewRequest(http.MethodPost, httpServer.URL+"/retrieving", bytes.NewReader(data))
+		r.Header.Set("Accept", "application/cbor")
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Error(err)
+		}
+		resCh <- res
+	}()
+	// wait for the request waiting for updates
+	time.Sleep(200 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := s.ShutdownContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !pump.stopped.Load() {
+		t.Fatal("pump should be stopped")
+	}
+	if err := s.ShutdownContext(ctx); err != nil {
+		t.Fatal("shutting down again should be no-op:", err)
+	}
+	select {
+	case res := <-resCh:
+		if res == nil || res.StatusCode != http.StatusNotModified || !res.Close {
+			t.Fatal("waiting request should be released with 304 and connection close")
+		}
+		_ = res.Body.Close()
+	case <-time.After(3 * time.Second):
+		t.Fatal("waiting request should be released on shutdown")
+	}
+}
@@ -0,0 +1,90 @@
+package configserver
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+const (
+	// minCompressSize is the min size of the response body to be compressed
+	minCompressSize = 1024
+	// minDeltaValueSize is the min size of the configuration value to be delta encoded
+	minDeltaValueSize = 4096
+	maxDeltaCacheSize = 1024
+)
+
+// writeEncodedBody writes the body compressed by the encoding negotiated via the Accept-Encoding header
+func (c *ConfigureServer) writeEncodedBody(w http.ResponseWriter, r *http.Request, cc *codec, data []byte) {
+	w.Header().Add("Content-Type", cc.ContentType)
+	w.Header().Add("Vary", "Accept-Encoding")
+	if encoding := configapi.NegotiateContentEncoding(r.Header.Get("Accept-Encoding")); encoding != "" && len(data) >= minCompressSize {
+		if compressed, err := configapi.Compress(encoding, data); err != nil {
+			c.logError("compress response failed", err)
+		} else {
+			w.Header().Add("Content-Encoding", encoding)
+			data = compressed
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		c.logError("write http body failed", err)
+	}
+}
+
+// encodeDeltas replaces the values of the configurations with the binary deltas against the versions requested by the
+// client if the previous versions are still kept and the deltas are smaller
+func (c *ConfigureServer) encodeDeltas(tenant string, req *configapi.AcquireConfigurationReq, cfgs []configapi.Configuration) {
+	selectorsKey := configapi.SelectorsHelperCacheValue(&req.Selectors)
+	optSelectorsKey := configapi.SelectorsHelperCacheValue(&req.OptionalSelectors)
+	versions := make(map[string]string, len(req.Requested))
+	for _, v := range req.Requested {
+		if v.Version != "" && !v.Deleted {
+			versions[v.Group+"||"+v.Key] = v.Version
+		}
+	}
+	for i := range cfgs {
+		cfg := &cfgs[i]
+		version, ok := versions[cfg.Group+"||"+cfg.Key]
+		if !ok || len(cfg.Value) < minDeltaValueSize {
+			continue
+		}
+		base := c.server.GetHistoryConfiguration(tenant, selectorsKey, optSelectorsKey, cfg.Group, cfg.Key, version)
+		if base == nil {
+			continue
+		}
+		delta := c.deltas.Get(base, cfg)
+		if len(delta) >= len(cfg.Value) {
+			continue
+		}
+		cfg.Value = delta
+		cfg.DeltaBase = base.Version
+	}
+}
+
+// deltaCache caches the deltas by the signatures of the values, since the clients waiting for the same configuration
+// are generally notified with the same change
+type deltaCache struct {
+	lock  sync.Mutex
+	items map[string][]byte
+}
+
+func (d *deltaCache) Get(base, target *configapi.Configuration) []byte {
+	key := base.Signature + "->" + target.Signature
+	d.lock.Lock()
+	delta, ok := d.items[key]
+	d.lock.Unlock()
+	if ok {
+		return delta
+	}
+
+	delta = configapi.EncodeDelta(base.Value, target.Value)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.items == nil || len(d.items) >= maxDeltaCacheSize {
+		d.items = make(map[string][]byte)
+	}
+	d.items[key] = delta
+	return delta
+}
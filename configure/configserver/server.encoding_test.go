@@ -0,0 +1,83 @@
+package configserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+func TestConfigureServer_RetrieveDeltaCompressed(t *testing.T) {
+	s := NewConfigureServer(ConfigureOptions{DataPump: PreparedDataPump{}, DeltaHistorySize: 2})
+	sel := configapi.Selectors{Data: map[string]string{"area": "dc1"}}
+	value := bytes.Repeat([]byte("route: 10.0.0.0/8 -> gateway1\n"), 1000)
+	for i, v := range [][]byte{value, append(append([]byte(nil), value...), []byte("route: 0.0.0.0/0 -> gateway2\n")...)} {
+		cfg := &configapi.Configuration{Group: "net", Key: "routes", Version: []string{"v1", "v2"}[i], Value: v, Selectors: sel}
+		cfg.Signature = cfg.GenerateSignature()
+		s.server.getOrCreateStore(cfg).SaveConfigurationWithNotification(cfg, newNotifyRound())
+	}
+
+	retrieve := func(req *configapi.AcquireConfigurationReq, acceptEncoding string) (*configapi.AcquireConfigurationRes, string) {
+		data, err := cbor.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/retrieving", bytes.NewReader(data))
+		r.Header.Set("Accept", "application/cbor")
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		s.readMux.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatal("unexpected status code:", w.Code)
+		}
+		body := w.Body.Bytes()
+		encoding := w.Header().Get("Content-Encoding")
+		if encoding != "" {
+			if body, err = configapi.Decompress(encoding, body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		res := new(configapi.AcquireConfigurationRes)
+		if err := cbor.Unmarshal(body, res); err != nil {
+			t.Fatal(err)
+		}
+		return res, encoding
+	}
+
+	// delta against the previous version
+	res, encoding := retrieve(&configapi.AcquireConfigurationReq{
+		Requested:   []configapi.RequestedConfigurationKey{{Group: "net", Key: "routes", Version: "v1"}},
+		Selectors:   sel,
+		AcceptDelta: true,
+	}, "br, zstd")
+	if encoding != "" {
+		t.Fatal("small body should not be compressed:", encoding)
+	}
+	cfg := res.Requested[0]
+	if cfg.DeltaBase != "v1" || len(cfg.Value) >= len(value) {
+		t.Fatal("delta expected:", cfg.DeltaBase, len(cfg.Value))
+	}
+	restored, err := configapi.ApplyDelta(value, cfg.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Value = restored
+	if !cfg.ValidateSignature() {
+		t.Fatal("restored value mismatch")
+	}
+
+	// full value if the version is unknown or the client does not accept delta
+	for _, req := range []*configapi.AcquireConfigurationReq{
+		{Requested: []configapi.RequestedConfigurationKey{{Group: "net", Key: "routes", Version: "v0"}}, Selectors: sel, AcceptDelta: true},
+		{Requested: []configapi.RequestedConfigurationKey{{Group: "net", Key: "routes", Version: "v1"}}, Selectors: sel},
+	} {
+		res, encoding := retrieve(req, "br, zstd")
+		if cfg := res.Requested[0]; cfg.DeltaBase != "" || !cfg.ValidateSignature() || encoding != configapi.ContentEncodingZstd {
+			t.Fatal("compressed full value expected:", encoding)
+		}
+	}
+}
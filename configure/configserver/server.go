@@ -5,13 +5,17 @@ import (
 	"crypto"
 	"crypto/x509"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/fxamacker/cbor/v2"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
@@ -28,18 +32,48 @@ type ConfigureOptions struct {
 	}
 	WriteApi struct {
 		DataWriter configapi.DataWriter
-		Addr       string
-		TLSConfig  struct {
+		// MaxValueSize is the max size in bytes of the configuration value, default 1MB
+		MaxValueSize int
+		// ValueSchemas are registered on creating the server, see ConfigureServer.RegisterValueSchema
+		ValueSchemas []configapi.ValueSchema
+		Addr         string
+		TLSConfig    struct {
 			Addr string
 			Cert *x509.Certificate
 			Key  crypto.PrivateKey
 		}
 	}
 
-	MaxWaitTimeForUpdate int // in seconds
+	MaxWaitTimeForUpdate int // in seconds, the default and the upper bound of the wait time requested by clients, default 60
+	MinWaitTimeForUpdate int // in seconds, the lower bound of the wait time requested by clients, default 5
+	// WaitTimeJitter is the ratio in [0, 1) of the wait time to be randomly reduced, so that the re-polls of the
+	// clients are not synchronized. 0 = default 0.1, negative = disabled.
+	WaitTimeJitter float64
+	// DeltaHistorySize is the number of previous versions kept per configuration for the delta encoding of the
+	// retrieving responses to the clients accepting delta, 0 = delta disabled
+	DeltaHistorySize int
+	// MaxDataStaleness is the max time in seconds the data pump may stay unhealthy before the server reports not ready,
+	// so that the load balancers stop routing to the server whose data may be stale, default 10
+	MaxDataStaleness int
+
+	// SelectorHierarchy is the selector keys from the highest priority, e.g. [env, dc, app], at most 8 keys.
+	// It enables the hierarchical mode requested by the clients, which falls back to the less specific selector
+	// combinations by dropping the selectors from the lowest priority. Empty = exact matching only.
+	SelectorHierarchy []string
+	// Tenants isolates the configurations of the business units with separated credentials and quotas. All the read and
+	// write apis require the bearer token of a tenant if declared. Empty = all requests belong to the default tenant.
+	Tenants []configapi.Tenant
+	// SecretResolver resolves ${secret:name} in the configuration templates. Empty = secrets are not allowed.
+	SecretResolver configapi.SecretResolver
+	// SecretRefreshInterval is the time in seconds the resolved secrets are cached before refreshed, so that the
+	// rotated secrets are rendered to the templates, default 300
+	SecretRefreshInterval int
 
 	DataPump          configapi.DataPump
 	VersionComparator configapi.VersionComparator
+	// VersionComparatorName selects the built-in VersionComparator if VersionComparator is nil.
+	// Available: string(default), semver, numeric, timestamp. Startup fails on unknown names.
+	VersionComparatorName string
 }
 
 func (c *ConfigureOptions) GetMaxWaitTimeForUpdate() time.Duration {
@@ -50,18 +84,76 @@ func (c *ConfigureOptions) GetMaxWaitTimeForUpdate() time.Duration {
 	}
 }
 
+func (c *ConfigureOptions) GetMinWaitTimeForUpdate() time.Duration {
+	minWait := 5 * time.Second
+	if c.MinWaitTimeForUpdate > 0 {
+		minWait = time.Duration(c.MinWaitTimeForUpdate) * time.Second
+	}
+	return min(minWait, c.GetMaxWaitTimeForUpdate())
+}
+
+func (c *ConfigureOptions) GetMaxDataStaleness() time.Duration {
+	if c.MaxDataStaleness <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(c.MaxDataStaleness) * time.Second
+}
+
+func (c *ConfigureOptions) GetSecretRefreshInterval() time.Duration {
+	if c.SecretRefreshInterval <= 0 {
+		return 300 * time.Second
+	}
+	return time.Duration(c.SecretRefreshInterval) * time.Second
+}
+
+func (c *ConfigureOptions) GetWaitTimeJitter() float64 {
+	switch {
+	case c.WaitTimeJitter == 0:
+		return 0.1
+	case c.WaitTimeJitter < 0:
+		return 0
+	default:
+		return min(c.WaitTimeJitter, 0.9)
+	}
+}
+
+// GetWaitTimeForUpdate clamps the wait time requested by the client in seconds and applies the jitter.
+// The jitter only reduces the wait time, so the result never exceeds the time requested.
+func (c *ConfigureOptions) GetWaitTimeForUpdate(requested int) time.Duration {
+	wait := c.GetMaxWaitTimeForUpdate()
+	if requested > 0 {
+		wait = min(max(time.Duration(requested)*time.Second, c.GetMinWaitTimeForUpdate()), wait)
+	}
+	if jitter := c.GetWaitTimeJitter(); jitter > 0 {
+		if n := int64(float64(wait) * jitter); n > 0 {
+			wait -= time.Duration(rand.Int63n(n))
+		}
+	}
+	return wait
+}
+
 type ConfigureServer struct {
 	readMux *chi.Mux // for client read
 
-	server      *server
-	opt         ConfigureOptions
-	writeServer struct {
+	server       *server
+	opt          ConfigureOptions
+	valueSchemas valueSchemaRegistry
+	deltas       deltaCache
+	tenants      tenantRegistry
+	writeServer  struct {
 		writeServer *writeServer
 		writeMux    *chi.Mux // for management write
 		httpServer  *stdserver.CombinedStdHttpServer
 	}
 
 	httpServer *stdserver.CombinedStdHttpServer
+	// listeners counts the long polling requests for the tenant quota
+	listeners listenerCounter
+	// drainCh is closed on shutdown to release the waiting long polling requests
+	drainCh   chan struct{}
+	drainOnce sync.Once
+	// initErr is the invalid option found while creating the server, returned by Startup
+	initErr error
 }
 
 func (c *ConfigureServer) logError(messsage string, err error) {
@@ -77,7 +169,8 @@ func (c *ConfigureServer) logWarn(message string, args ...any) {
 }
 
 func (c *ConfigureServer) handleRetrieveAndListen(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("Accept") != "application/cbor" {
+	cc := acceptCodec(r)
+	if cc == nil {
 		w.WriteHeader(http.StatusNotAcceptable)
 		return
 	}
@@ -87,8 +180,13 @@ func (c *ConfigureServer) handleRetrieveAndListen(w http.ResponseWriter, r *http
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	reqCodec := contentCodec(r)
+	if reqCodec == nil {
+		// cbor by default for compatibility
+		reqCodec = cborCodec
+	}
 	req := new(configapi.AcquireConfigurationReq)
-	if err := cbor.Unmarshal(data, req); err != nil {
+	if err := reqCodec.Unmarshal(data, req); err != nil {
 		c.logError("parse http body error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -98,7 +196,15 @@ func (c *ConfigureServer) handleRetrieveAndListen(w http.ResponseWriter, r *http
 		return
 	}
 
-	ch, cancelFn, err := c.server.RetrieveOrWait(req)
+	tenant := tenantOf(r)
+	if !c.listeners.Acquire(tenant, c.tenants.Quota(tenant).MaxListeners) {
+		c.logWarn("too many listeners of tenant:", tenant)
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	defer c.listeners.Release(tenant)
+
+	ch, cancelFn, err := c.server.RetrieveOrWait(tenant, req)
 	if errors.Is(err, ErrHasUnknownConfiguration) {
 		c.logError("some of the configuration not found", err)
 		w.WriteHeader(http.StatusNotFound)
@@ -110,49 +216,62 @@ func (c *ConfigureServer) handleRetrieveAndListen(w http.ResponseWriter, r *http
 		return
 	}
 
-	timer := time.NewTimer(c.opt.GetMaxWaitTimeForUpdate())
+	timer := time.NewTimer(c.opt.GetWaitTimeForUpdate(req.WaitTime))
 	var accumulated = make([]configapi.Configuration, 0, len(req.Requested))
+	var deleted []configapi.RequestedConfigurationKey
 	defer timer.Stop()
 	defer cancelFn()
 	select {
 	case obj, ok := <-ch:
 		for {
 			if !ok {
-				if len(accumulated) == 0 {
-					c.logError("wait result should not be empty", nil)
-					w.WriteHeader(http.StatusInternalServerError)
+				if len(accumulated) == 0 && len(deleted) == 0 {
+					// released without changes, see NotifyChannel
+					w.WriteHeader(http.StatusNotModified)
 					return
 				} else {
+					if req.AcceptDelta && !req.Hierarchical {
+						c.encodeDeltas(tenant, req, accumulated)
+					}
 					obj := &configapi.AcquireConfigurationRes{
 						Requested: accumulated,
+						Deleted:   deleted,
 					}
-					if data, err := cbor.Marshal(obj); err != nil {
+					if data, err := cc.Marshal(obj); err != nil {
 						c.logError("marshal result failed", err)
 						w.WriteHeader(http.StatusInternalServerError)
 						return
 					} else {
-						w.Header().Add("Content-Type", "application/cbor")
-						w.WriteHeader(http.StatusOK)
-						if _, err := w.Write(data); err != nil {
-							c.logError("write http body failed", err)
-							return
-						} else {
-							return
-						}
+						c.writeEncodedBody(w, r, cc, data)
+						return
 					}
 				}
 			}
-			accumulated = append(accumulated, *obj.Configuration)
+			if obj.Deleted {
+				deleted = append(deleted, configapi.RequestedConfigurationKey{
+					Group:   obj.Configuration.Group,
+					Key:     obj.Configuration.Key,
+					Version: obj.Configuration.Version,
+				})
+			} else {
+				accumulated = append(accumulated, *obj.Configuration)
+			}
 			obj, ok = <-ch // no need to check timer since any update will cause the channel to be closed
 		}
 	case <-timer.C:
 		w.WriteHeader(http.StatusNotModified)
 		return
+	case <-c.drainCh:
+		// server going away: the client re-polls immediately on a new connection, generally to another server
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
 }
 
 func (c *ConfigureServer) handleGetConfiguration(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("Accept") != "application/cbor" {
+	cc := acceptCodec(r)
+	if cc == nil {
 		w.WriteHeader(http.StatusNotAcceptable)
 		return
 	}
@@ -174,7 +293,18 @@ func (c *ConfigureServer) handleGetConfiguration(w http.ResponseWriter, r *http.
 		return
 	}
 
-	cfg, err := c.server.GetConfigurationViaPlainRequest(group, key, selectorsInfo, optSelectorsInfo)
+	var cfg configapi.Configuration
+	var err error
+	if r.Header.Get("X-Configuration-Hierarchical") == "true" && len(c.server.selectorHierarchy) > 0 {
+		var sel, optSel configapi.Selectors
+		if sel.Fill(selectorsInfo) != nil || optSel.Fill(optSelectorsInfo) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		cfg, err = c.server.GetConfigurationHierarchical(tenantOf(r), group, key, &sel, &optSel)
+	} else {
+		cfg, err = c.server.GetConfigurationViaPlainRequest(tenantOf(r), group, key, selectorsInfo, optSelectorsInfo)
+	}
 	if errors.Is(err, ErrHasUnknownConfiguration) {
 		c.logError("the configuration not found", err)
 		//FIXME add details of missing configurations according to the api spec
@@ -191,31 +321,51 @@ func (c *ConfigureServer) handleGetConfiguration(w http.ResponseWriter, r *http.
 		Message:       "success",
 		Configuration: cfg,
 	}
-	if data, err := cbor.Marshal(obj); err != nil {
+	if data, err := cc.Marshal(obj); err != nil {
 		c.logError("marshal result failed", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	} else {
-		w.Header().Add("Content-Type", "application/cbor")
-		w.WriteHeader(http.StatusOK)
-		if _, err := w.Write(data); err != nil {
-			c.logError("write http body failed", err)
-			return
-		}
+		c.writeEncodedBody(w, r, cc, data)
 	}
 }
 
 func NewConfigureServer(opt ConfigureOptions) *ConfigureServer {
 	versionComparator := opt.VersionComparator
+	var initErr error
 	if versionComparator == nil {
-		versionComparator = DefaultVersionComparator{}
+		// unknown comparator fails the startup, the default one is used meanwhile
+		versionComparator, initErr = NewVersionComparator(opt.VersionComparatorName)
+		if initErr != nil {
+			versionComparator = DefaultVersionComparator{}
+		}
 	}
 
 	// initialize server
 	var srv = newServer(opt.DataPump, versionComparator)
+	srv.stores.historySize = opt.DeltaHistorySize
+	if len(opt.SelectorHierarchy) > maxSelectorHierarchySize {
+		panic(ErrSelectorHierarchyTooLarge)
+	}
+	srv.selectorHierarchy = opt.SelectorHierarchy
+	if opt.SecretResolver != nil {
+		srv.secrets = newSecretCache(opt.SecretResolver, opt.GetSecretRefreshInterval())
+	}
+	tenants, err := newTenantRegistry(opt.Tenants)
+	if err != nil {
+		panic(err)
+	}
 	s := &ConfigureServer{
-		opt:    opt,
-		server: srv,
+		opt:     opt,
+		server:  srv,
+		tenants: tenants,
+		drainCh: make(chan struct{}),
+		initErr: initErr,
+	}
+	for _, v := range opt.WriteApi.ValueSchemas {
+		if err := s.RegisterValueSchema(v.Tenant, v.Group, v.Key, string(v.Schema)); err != nil {
+			panic(fmt.Errorf("invalid value schema of %s/%s: %w", v.Group, v.Key, err))
+		}
 	}
 
 	// read api
@@ -225,9 +375,18 @@ func NewConfigureServer(opt ConfigureOptions) *ConfigureServer {
 	r.Use(middleware.Recoverer)
 	//r.Use(middleware.Logger) //FIXME require custom implementation
 	// API - retrieve and listen
-	r.Post("/retrieving", s.handleRetrieveAndListen)
+	r.With(s.authenticate(false)).Post("/retrieving", s.handleRetrieveAndListen)
 	// API - get specific configuration
-	r.Get("/configure/{group}/{key}", s.handleGetConfiguration)
+	r.With(s.authenticate(false)).Get("/configure/{group}/{key}", s.handleGetConfiguration)
+	// API - readiness
+	r.Get("/ready", s.handleReady)
+	if opt.WriteApi.DataWriter == nil {
+		// API - admin queries of the read-only server
+		r.Group(func(r chi.Router) {
+			r.Use(s.authenticate(false))
+			s.prepareAdminApi(r)
+		})
+	}
 	s.readMux = r
 
 	// write api
@@ -238,6 +397,9 @@ func NewConfigureServer(opt ConfigureOptions) *ConfigureServer {
 
 func (c *ConfigureServer) Startup() error {
 	log.Println("ConfigureServer starting...")
+	if c.initErr != nil {
+		return c.initErr
+	}
 	if err := c.server.Startup(); err != nil {
 		return err
 	}
@@ -263,21 +425,35 @@ func (c *ConfigureServer) Startup() error {
 }
 
 func (c *ConfigureServer) Shutdown() error {
-	if err := c.stopWriteApi(); err != nil {
-		return err
-	}
+	return c.ShutdownContext(context.Background())
+}
+
+// ShutdownContext shuts down the server gracefully before the deadline of ctx:
+//  1. Release the waiting long polling requests with 304 and connection close
+//  2. Stop the http servers after the in-flight requests complete
+//  3. Stop the internal loops and then the data pump
+//
+// The remaining steps are still performed if the deadline exceeds, and the errors are joined.
+func (c *ConfigureServer) ShutdownContext(ctx context.Context) error {
+	c.drainOnce.Do(func() {
+		close(c.drainCh)
+	})
+	var errs []error
 	// stop http server
 	if c.httpServer != nil {
-		if err := c.httpServer.Shutdown(context.Background()); err != nil {
-			return err
+		if err := c.httpServer.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
 		}
 	}
+	if err := c.stopWriteApi(ctx); err != nil {
+		errs = append(errs, err)
+	}
 	if c.server != nil {
-		if err := c.server.Shutdown(); err != nil {
-			return err
+		if err := c.server.ShutdownContext(ctx); err != nil {
+			errs = append(errs, err)
 		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 func (c *ConfigureServer) prepareWriteApi() {
@@ -292,10 +468,19 @@ func (c *ConfigureServer) prepareWriteApi() {
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Recoverer)
 	//r.Use(middleware.Logger) //FIXME require custom implementation
+	r.Use(c.authenticate(true))
 	// save or update configuration
 	r.Post("/configure", c.saveConfiguration)
+	// save or update configurations atomically
+	r.Post("/configure/batch", c.saveConfigurations)
 	// delete configuration
 	r.Delete("/configure/{group}/{key}", c.deleteConfiguration)
+	// query audit records
+	r.Get("/audit", c.queryAuditRecords)
+	// admin query apis
+	c.prepareAdminApi(r)
+	// configuration as published, the templates are only visible to the write credentials
+	r.Get("/admin/groups/{group}/keys/{key}", c.adminGetConfiguration)
 
 	c.writeServer.writeMux = r
 }
@@ -327,9 +512,9 @@ func (c *ConfigureServer) startWriteApi() error {
 	return nil
 }
 
-func (c *ConfigureServer) stopWriteApi() error {
+func (c *ConfigureServer) stopWriteApi(ctx context.Context) error {
 	if c.writeServer.httpServer != nil {
-		if err := c.writeServer.httpServer.Shutdown(context.Background()); err != nil {
+		if err := c.writeServer.httpServer.Shutdown(ctx); err != nil {
 			return err
 		}
 	}
@@ -342,12 +527,14 @@ func (c *ConfigureServer) stopWriteApi() error {
 }
 
 func (c *ConfigureServer) saveConfiguration(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("Accept") != "application/cbor" {
+	cc := acceptCodec(r)
+	if cc == nil {
 		w.WriteHeader(http.StatusNotAcceptable)
 		return
 	}
-	if r.Header.Get("Content-Type") != "application/cbor" {
-		w.WriteHeader(http.StatusNotAcceptable)
+	reqCodec := contentCodec(r)
+	if reqCodec == nil {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
 		return
 	}
 	data, err := io.ReadAll(r.Body)
@@ -357,18 +544,61 @@ func (c *ConfigureServer) saveConfiguration(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	cfg := new(configapi.Configuration)
-	if err := cbor.Unmarshal(data, cfg); err != nil {
-		c.logError("parse http body error", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	req := new(configapi.PublishReq)
+	if err := reqCodec.Unmarshal(data, req); err != nil {
+		c.logWarn("parse http body error", err)
+		c.writeResponseWithStatus(w, cc, http.StatusBadRequest, &configapi.PublishRes{
+			Success: false,
+			Code:    "400",
+			Message: "invalid request body",
+			Errors:  []configapi.FieldError{{Field: "body", Message: err.Error()}},
+		})
 		return
 	}
-	if cfg.Group == "" || cfg.Key == "" {
-		w.WriteHeader(http.StatusBadRequest)
+	tenant := tenantOf(r)
+	validationErrors := c.validatePublishReq(tenant, req)
+	cfg := req.ToConfiguration(time.Now().Unix())
+	cfg.Tenant = tenant
+	if generator, ok := c.server.versionComparator.(configapi.VersionGenerator); ok {
+		configapi.GenerateVersions(generator, cfg, "")
+	}
+	versionValidator, _ := c.server.versionComparator.(configapi.VersionValidator)
+	validationErrors = append(validationErrors, validateVersion(cfg, versionValidator, "version")...)
+	if len(validationErrors) == 0 {
+		validationErrors = append(validationErrors, validateSchedule(req, cfg, versionValidator)...)
+	}
+	if len(validationErrors) > 0 {
+		c.logWarn("invalid configuration publish request", validationErrors)
+		c.writeResponseWithStatus(w, cc, http.StatusBadRequest, &configapi.PublishRes{
+			Success: false,
+			Code:    "400",
+			Message: "invalid configuration",
+			Errors:  validationErrors,
+		})
+		return
+	}
+	if !c.checkConfigurationQuota(tenant, []configapi.Configuration{*cfg}) {
+		c.writeQuotaExceeded(w, cc, tenant)
 		return
 	}
 
-	if err := c.writeServer.writeServer.SaveConfiguration(cfg); err != nil {
+	if err := c.writeServer.writeServer.SaveConfiguration(cfg, configapi.SaveOptions{
+		Audit:            c.auditInfo(r, req.Operator, req.Description),
+		VersionValidator: versionValidator,
+	}); errors.Is(err, configapi.ErrVersionNotIncreased) {
+		c.logWarn("SaveConfiguration rejected", err)
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if errors.Is(err, configapi.ErrInvalidSchedule) {
+		c.logWarn("SaveConfiguration rejected", err)
+		c.writeResponseWithStatus(w, cc, http.StatusBadRequest, &configapi.PublishRes{
+			Success: false,
+			Code:    "400",
+			Message: "invalid configuration",
+			Errors:  []configapi.FieldError{{Field: "expiry_time", Message: err.Error()}},
+		})
+		return
+	} else if err != nil {
 		c.logError("SaveConfiguration error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -377,7 +607,7 @@ func (c *ConfigureServer) saveConfiguration(w http.ResponseWriter, r *http.Reque
 }
 
 func (c *ConfigureServer) deleteConfiguration(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("Accept") != "application/cbor" {
+	if acceptCodec(r) == nil {
 		w.WriteHeader(http.StatusNotAcceptable)
 		return
 	}
@@ -399,7 +629,8 @@ func (c *ConfigureServer) deleteConfiguration(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	ok, err := c.writeServer.writeServer.DeleteConfiguration(group, key, selectorsInfo, optSelectorsInfo)
+	audit := c.auditInfo(r, r.Header.Get("X-Configuration-Operator"), r.Header.Get("X-Configuration-Description"))
+	ok, err := c.writeServer.writeServer.DeleteConfiguration(tenantOf(r), group, key, selectorsInfo, optSelectorsInfo, audit)
 	if err != nil {
 		c.logError("DeleteConfiguration error", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -412,3 +643,92 @@ func (c *ConfigureServer) deleteConfiguration(w http.ResponseWriter, r *http.Req
 	}
 	w.WriteHeader(http.StatusOK)
 }
+
+func (c *ConfigureServer) auditInfo(r *http.Request, operator, description string) configapi.AuditInfo {
+	// RealIP middleware has replaced RemoteAddr with the proxy headers if present, otherwise it contains the port
+	sourceIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(sourceIP); err == nil {
+		sourceIP = host
+	}
+	return configapi.AuditInfo{
+		Operator:    strings.TrimSpace(operator),
+		SourceIP:    sourceIP,
+		RequestId:   middleware.GetReqID(r.Context()),
+		Description: description,
+	}
+}
+
+func (c *ConfigureServer) queryAuditRecords(w http.ResponseWriter, r *http.Request) {
+	cc := acceptCodec(r)
+	if cc == nil {
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+	const defaultLimit = 100
+	const maxLimit = 1000
+	params := r.URL.Query()
+	query := configapi.AuditQuery{
+		Tenant:   tenantOf(r),
+		Group:    params.Get("group"),
+		Key:      params.Get("key"),
+		Operator: params.Get("operator"),
+		Limit:    defaultLimit,
+	}
+	if v := params.Get("before_id"); v != "" {
+		beforeId, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || beforeId < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		query.BeforeId = beforeId
+	}
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		query.Limit = limit
+	}
+
+	records, err := c.writeServer.writeServer.QueryAuditRecords(query)
+	if err != nil {
+		c.logError("QueryAuditRecords error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	c.writeResponse(w, cc, &configapi.AuditQueryRes{
+		Code:    "200",
+		Message: "success",
+		Records: records,
+	})
+}
+
+func (c *ConfigureServer) writeQuotaExceeded(w http.ResponseWriter, cc *codec, tenant string) {
+	c.logWarn("configuration quota exceeded of tenant:", tenant)
+	c.writeResponseWithStatus(w, cc, http.StatusForbidden, &configapi.PublishRes{
+		Success: false,
+		Code:    "403",
+		Message: configapi.ErrTenantQuotaExceeded.Error(),
+	})
+}
+
+func (c *ConfigureServer) writeResponse(w http.ResponseWriter, cc *codec, obj any) {
+	c.writeResponseWithStatus(w, cc, http.StatusOK, obj)
+}
+
+func (c *ConfigureServer) writeResponseWithStatus(w http.ResponseWriter, cc *codec, statusCode int, obj any) {
+	if data, err := cc.Marshal(obj); err != nil {
+		c.logError("marshal result failed", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else {
+		w.Header().Add("Content-Type", cc.ContentType)
+		w.WriteHeader(statusCode)
+		if _, err := w.Write(data); err != nil {
+			c.logError("write http body failed", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package configserver
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+// readiness returns whether the server should receive traffic and the reason if not:
+//  1. The initial dump has not completed
+//  2. The server is shutting down
+//  3. The data pump has been unhealthy longer than MaxDataStaleness, so the data may be stale
+func (c *ConfigureServer) readiness(now time.Time) (bool, string) {
+	if !c.server.started.Load() {
+		return false, "starting"
+	}
+	select {
+	case <-c.drainCh:
+		return false, "shutting down"
+	default:
+	}
+	health := c.server.pump.Health()
+	if !health.Healthy && now.Sub(health.LastSuccess) > c.opt.GetMaxDataStaleness() {
+		return false, "data may be stale"
+	}
+	return true, "ready"
+}
+
+// handleReady is the readiness endpoint for the load balancers and the orchestrators.
+// json is responded if the Accept header is missing or a wildcard since the probes generally do not specify it.
+func (c *ConfigureServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	cc := negotiateCodec(r, jsonCodec)
+	if cc == nil {
+		cc = jsonCodec
+	}
+	ready, reason := c.readiness(time.Now())
+	health := c.server.pump.Health()
+	res := &configapi.ReadinessRes{
+		Code:         "200",
+		Message:      reason,
+		Ready:        ready,
+		PumpHealthy:  health.Healthy,
+		PumpFailures: health.Failures,
+	}
+	if !health.LastSuccess.IsZero() {
+		res.PumpLastSuccess = health.LastSuccess.Unix()
+	}
+	statusCode := http.StatusOK
+	if !ready {
+		statusCode = http.StatusServiceUnavailable
+		res.Code = "503"
+	}
+	c.writeResponseWithStatus(w, cc, statusCode, res)
+}
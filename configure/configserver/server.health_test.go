@@ -0,0 +1,82 @@
+package configserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+type healthDataPump struct {
+	PreparedDataPump
+	lock   sync.Mutex
+	health configapi.PumpHealth
+}
+
+func (h *healthDataPump) Health() configapi.PumpHealth {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.health
+}
+
+func (h *healthDataPump) setHealth(health configapi.PumpHealth) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.health = health
+}
+
+func TestConfigureServer_Readiness(t *testing.T) {
+	pump := &healthDataPump{health: configapi.PumpHealth{Healthy: true, LastSuccess: time.Now()}}
+	s := NewConfigureServer(ConfigureOptions{DataPump: pump, MaxDataStaleness: 5})
+	httpServer := httptest.NewServer(s.readMux)
+	defer httpServer.Close()
+
+	check := func(expectedStatus int, expectedReady bool) {
+		t.Helper()
+		r, err := http.Get(httpServer.URL + "/ready")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Body.Close()
+		if r.StatusCode != expectedStatus {
+			t.Fatal("unexpected status code:", r.StatusCode)
+		}
+		res := new(configapi.ReadinessRes)
+		if err := json.NewDecoder(r.Body).Decode(res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Ready != expectedReady {
+			t.Fatal("unexpected readiness:", res.Message)
+		}
+	}
+
+	// not ready before the initial dump
+	check(http.StatusServiceUnavailable, false)
+	if err := s.server.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	check(http.StatusOK, true)
+
+	// transient failure within the staleness
+	pump.setHealth(configapi.PumpHealth{Healthy: false, LastSuccess: time.Now().Add(-time.Second), Failures: 1, LastError: errors.New("conn refused")})
+	check(http.StatusOK, true)
+
+	// data may be stale
+	pump.setHealth(configapi.PumpHealth{Healthy: false, LastSuccess: time.Now().Add(-time.Minute), Failures: 5, LastError: errors.New("conn refused")})
+	check(http.StatusServiceUnavailable, false)
+
+	// recovered
+	pump.setHealth(configapi.PumpHealth{Healthy: true, LastSuccess: time.Now()})
+	check(http.StatusOK, true)
+
+	// shutting down
+	if err := s.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	check(http.StatusServiceUnavailable, false)
+}
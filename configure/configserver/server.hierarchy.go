@@ -0,0 +1,267 @@
+package configserver
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+// maxSelectorHierarchySize limits the candidate selector combinations of a request to 2^8
+const maxSelectorHierarchySize = 8
+
+var ErrSelectorHierarchyTooLarge = errors.New("selector hierarchy too large")
+
+// hierarchyCandidates returns the selector combinations to match in hierarchical mode, the most specific first.
+// The selectors out of the hierarchy are always kept, while the ones in the hierarchy are dropped from the lowest
+// priority, so a combination with a higher priority selector is more specific than any combination without it.
+// e.g. hierarchy [env, dc, app] and selectors env=prod,dc=dc1,app=x result in:
+// {env,dc,app}, {env,dc}, {env,app}, {env}, {dc,app}, {dc}, {app}
+func hierarchyCandidates(hierarchy []string, selectors *configapi.Selectors) []string {
+	var present []string // hierarchy keys present in the selectors, highest priority first
+	for _, k := range hierarchy {
+		if _, ok := selectors.Data[k]; ok {
+			present = append(present, k)
+		}
+	}
+	n := len(present)
+	result := make([]string, 0, 1<<n)
+	for mask := 1<<n - 1; mask >= 0; mask-- {
+		data := make(map[string]string, len(selectors.Data))
+		for k, v := range selectors.Data {
+			data[k] = v
+		}
+		for i, k := range present {
+			// the highest priority key is the most significant bit
+			if mask&(1<<(n-1-i)) == 0 {
+				delete(data, k)
+			}
+		}
+		if len(data) == 0 {
+			// selectors are required
+			continue
+		}
+		sel := configapi.Selectors{Data: data}
+		result = append(result, configapi.SelectorsHelperCacheValue(&sel))
+	}
+	return result
+}
+
+// storeView reads the stores of a tenant for a request. Each store is looked up and its snapshot is loaded at most
+// once, so the configurations published together(e.g. a batch) are read all or none.
+type storeView struct {
+	registry *storeRegistry
+	tenant   string
+	// snapshots by [selectorsKey, optSelectorsKey], nil if the store does not exist
+	snapshots map[[2]string]*storeSnapshot
+}
+
+func newStoreView(r *storeRegistry, tenant string) *storeView {
+	return &storeView{registry: r, tenant: tenant, snapshots: map[[2]string]*storeSnapshot{}}
+}
+
+func (v *storeView) snapshot(selectorsKey, optSelectorsKey string) *storeSnapshot {
+	k := [2]string{selectorsKey, optSelectorsKey}
+	if snapshot, ok := v.snapshots[k]; ok {
+		return snapshot
+	}
+	var snapshot *storeSnapshot
+	if store := v.registry.GetExact(v.tenant, selectorsKey, optSelectorsKey); store != nil {
+		snapshot = store.snapshot.Load()
+	}
+	v.snapshots[k] = snapshot
+	return snapshot
+}
+
+// resolveHierarchical returns the most specific visible configuration among the candidates and the index of the
+// candidate, or nil and len(candidates) if not found. The optional selectors are matched before the selectors of
+// each candidate.
+func resolveHierarchical(view *storeView, candidates []string, optSelectorsKey, group, key string) (*configapi.Configuration, int) {
+	for i, c := range candidates {
+		if optSelectorsKey != "" {
+			if snapshot := view.snapshot(c, optSelectorsKey); snapshot != nil {
+				if cfg := snapshot.visible(group, key); cfg != nil {
+					return cfg, i
+				}
+			}
+		}
+		if snapshot := view.snapshot(c, ""); snapshot != nil {
+			if cfg := snapshot.visible(group, key); cfg != nil {
+				return cfg, i
+			}
+		}
+	}
+	return nil, len(candidates)
+}
+
+// checkRequestedHierarchical is checkRequested in hierarchical mode, it also returns the indexes of the resolved
+// candidates. Since the versions of different selector combinations are not comparable, any version different from
+// the requested one is regarded as an update. The stores are read via a storeView, so the batches are not split.
+func (s *server) checkRequestedHierarchical(tenant string, candidates []string, optSelectorsKey string, requested []configapi.RequestedConfigurationKey) (r []NotifyEvent, resolved []int, ready bool) {
+	resolved = make([]int, len(requested))
+	view := newStoreView(s.stores, tenant)
+	for i, v := range requested {
+		cfg, idx := resolveHierarchical(view, candidates, optSelectorsKey, v.Group, v.Key)
+		resolved[i] = idx
+		if cfg == nil {
+			switch {
+			case v.Deleted:
+				// wait for recreation
+			case v.Version == "":
+				return nil, nil, false
+			default:
+				r = append(r, NotifyEvent{
+					Configuration: &configapi.Configuration{Group: v.Group, Key: v.Key, Version: v.Version},
+					Deleted:       true,
+				})
+			}
+			continue
+		}
+		if v.Deleted || cfg.Version != v.Version {
+			r = append(r, NotifyEvent{
+				Configuration: cfg,
+			})
+		}
+	}
+	return r, resolved, true
+}
+
+// retrieveOrWaitHierarchical is RetrieveOrWait in hierarchical mode.
+// The listeners are registered as triggers to the stores of the candidates at least as specific as the resolved
+// ones(the less specific ones are shadowed). The stores not created yet are waited via storeRegistry.GetOrWait
+// rather than created, so the arbitrary selectors of the requests do not grow the stores. Once triggered, the
+// requested configurations are resolved again, so that the deletion of a specific configuration falls back to a less
+// specific one and the changes of the shadowed configurations are ignored.
+func (s *server) retrieveOrWaitHierarchical(tenant string, req *configapi.AcquireConfigurationReq) (NotifyChannel, context.CancelFunc, error) {
+	candidates := hierarchyCandidates(s.selectorHierarchy, &req.Selectors)
+	optSelectorsKey := configapi.SelectorsHelperCacheValue(&req.OptionalSelectors)
+	reqid := s.nextId()
+
+	register := func() (r []NotifyEvent, trigger NotifyChannel, cancelWait func(), ready bool) {
+		for {
+			r, resolved, ready := s.checkRequestedHierarchical(tenant, candidates, optSelectorsKey, req.Requested)
+			if !ready || len(r) > 0 {
+				return r, nil, nil, ready
+			}
+			type registration struct {
+				selectorsKey, optSelectorsKey string
+				// store is nil if not created yet
+				store *selectorsStore
+				list  []struct {
+					Group string
+					Key   string
+				}
+			}
+			var registrations []registration
+			for i, v := range req.Requested {
+				list := []struct {
+					Group string
+					Key   string
+				}{{Group: v.Group, Key: v.Key}}
+				for _, c := range candidates[:min(resolved[i]+1, len(candidates))] {
+					registrations = append(registrations, registration{selectorsKey: c, list: list})
+					if optSelectorsKey != "" {
+						registrations = append(registrations, registration{selectorsKey: c, optSelectorsKey: optSelectorsKey, list: list})
+					}
+				}
+			}
+			// each registration is notified at most once
+			trigger = make(NotifyChannel, len(registrations))
+			l := &listener{ch: trigger}
+			var stores []*selectorsStore
+			for i := range registrations {
+				v := &registrations[i]
+				if v.store = s.stores.GetOrWait(tenant, v.selectorsKey, v.optSelectorsKey, reqid, l); v.store != nil {
+					stores = append(stores, v.store)
+				}
+			}
+			cancelWait = func() {
+				for _, v := range registrations {
+					if v.store != nil {
+						v.store.CancelWait(reqid, v.list)
+					} else {
+						s.stores.CancelCreationWait(tenant, v.selectorsKey, v.optSelectorsKey, reqid)
+					}
+				}
+			}
+			// check again with the existing stores locked, so that no update is lost before registered. The stores
+			// created in between notify the listener.
+			unlock := lockStores(stores)
+			r, recheck, ready := s.checkRequestedHierarchical(tenant, candidates, optSelectorsKey, req.Requested)
+			if !ready || len(r) > 0 {
+				unlock()
+				cancelWait()
+				return r, nil, nil, ready
+			}
+			if !slices.Equal(resolved, recheck) {
+				// resolved to other candidates in between, the stores to register change
+				unlock()
+				cancelWait()
+				continue
+			}
+			for _, v := range registrations {
+				if v.store != nil {
+					v.store.RegisterListener(reqid, v.list[0].Group, v.list[0].Key, l)
+				}
+			}
+			unlock()
+			return nil, trigger, cancelWait, true
+		}
+	}
+
+	r, trigger, cancelWait, ready := register()
+	if !ready {
+		return nil, nil, ErrHasUnknownConfiguration
+	}
+	if len(r) > 0 {
+		return respondEvents(r), func() {}, nil
+	}
+
+	ch := make(NotifyChannel, len(req.Requested))
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				cancelWait()
+				return
+			case <-trigger:
+			}
+			cancelWait()
+			r, trigger, cancelWait, ready = register()
+			if !ready {
+				// a requested configuration is unknown now, which is responded with 404 on the initial check.
+				// The channel is closed without events to release the waiting, so the client polls again and gets
+				// the current state rather than an error.
+				close(ch)
+				return
+			}
+			if len(r) > 0 {
+				for _, ev := range r {
+					ch <- ev
+				}
+				close(ch)
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			close(done)
+		})
+	}, nil
+}
+
+// GetConfigurationHierarchical returns the most specific configuration matching the selectors in hierarchical mode
+func (s *server) GetConfigurationHierarchical(tenant, group, key string, selectors, optSelectors *configapi.Selectors) (configapi.Configuration, error) {
+	candidates := hierarchyCandidates(s.selectorHierarchy, selectors)
+	optSelectorsKey := configapi.SelectorsHelperCacheValue(optSelectors)
+	cfg, _ := resolveHierarchical(newStoreView(s.stores, tenant), candidates, optSelectorsKey, group, key)
+	if cfg == nil {
+		return configapi.Configuration{}, ErrHasUnknownConfiguration
+	}
+	return *cfg, nil
+}
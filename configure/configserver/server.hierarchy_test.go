@@ -0,0 +1,210 @@
+package configserver
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+func TestHierarchyCandidates(t *testing.T) {
+	sel := &configapi.Selectors{Data: map[string]string{"env": "prod", "dc": "dc1", "app": "x", "zone": "z1"}}
+	result := hierarchyCandidates([]string{"env", "dc", "app"}, sel)
+	expected := []string{
+		"app=x,dc=dc1,env=prod,zone=z1",
+		"dc=dc1,env=prod,zone=z1",
+		"app=x,env=prod,zone=z1",
+		"env=prod,zone=z1",
+		"app=x,dc=dc1,zone=z1",
+		"dc=dc1,zone=z1",
+		"app=x,zone=z1",
+		"zone=z1",
+	}
+	if !slices.Equal(result, expected) {
+		t.Fatal("unexpected candidates:", result)
+	}
+
+	// keys of the hierarchy missing in the selectors are skipped and empty selectors are excluded
+	result = hierarchyCandidates([]string{"env", "dc", "app"}, &configapi.Selectors{Data: map[string]string{"env": "prod", "app": "x"}})
+	if !slices.Equal(result, []string{"app=x,env=prod", "env=prod", "app=x"}) {
+		t.Fatal("unexpected candidates:", result)
+	}
+}
+
+func waitNotification(t *testing.T, ch NotifyChannel, timeout time.Duration) []NotifyEvent {
+	t.Helper()
+	var result []NotifyEvent
+	timer := time.After(timeout)
+	for {
+		select {
+		case v, ok := <-ch:
+			if !ok {
+				return result
+			}
+			result = append(result, v)
+		case <-timer:
+			return nil
+		}
+	}
+}
+
+func TestServer_RetrieveOrWait_Hierarchical(t *testing.T) {
+	pump := &channelDataPump{ch: make(chan configapi.Event)}
+	s := newServer(pump, DefaultVersionComparator{})
+	s.selectorHierarchy = []string{"env", "dc", "app"}
+	if err := s.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = s.Shutdown()
+	}()
+
+	envSel := configapi.Selectors{Data: map[string]string{"env": "prod"}}
+	appSel := configapi.Selectors{Data: map[string]string{"env": "prod", "app": "x"}}
+	pump.ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Group: "g", Key: "k", Version: "v1", Selectors: envSel}}
+	pump.ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Group: "g", Key: "k2", Version: "v1", Selectors: envSel}}
+	pump.ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Group: "g", Key: "k2", Version: "v5", Selectors: appSel}}
+	time.Sleep(800 * time.Millisecond)
+
+	clientSel := configapi.Selectors{Data: map[string]string{"env": "prod", "dc": "dc1", "app": "x"}}
+	// strict mode by default
+	if _, _, err := s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
+		Requested: []configapi.RequestedConfigurationKey{{Group: "g", Key: "k"}},
+		Selectors: clientSel,
+	}); err != ErrHasUnknownConfiguration {
+		t.Fatal("strict mode should fail on the missing selector combination:", err)
+	}
+
+	// the most specific configurations
+	ch, cancelFn, err := s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
+		Requested:    []configapi.RequestedConfigurationKey{{Group: "g", Key: "k"}, {Group: "g", Key: "k2"}},
+		Selectors:    clientSel,
+		Hierarchical: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelFn()
+	result := waitNotification(t, ch, time.Second)
+	if len(result) != 2 || result[0].Configuration.Version != "v1" || result[1].Configuration.Version != "v5" {
+		t.Fatal("unexpected result:", result)
+	}
+
+	// changes of the shadowed configuration are ignored while the more specific one is notified
+	ch, cancelFn, err = s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
+		Requested:    []configapi.RequestedConfigurationKey{{Group: "g", Key: "k2", Version: "v5"}},
+		Selectors:    clientSel,
+		Hierarchical: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pump.ch <- configapi.Event{Modified: true, Configuration: &configapi.Configuration{Group: "g", Key: "k2", Version: "v2", Selectors: envSel}}
+	if result := waitNotification(t, ch, 1500*time.Millisecond); result != nil {
+		t.Fatal("shadowed change should not be notified:", result)
+	}
+	pump.ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Group: "g", Key: "k2", Version: "v1", Selectors: clientSel}}
+	result = waitNotification(t, ch, 2*time.Second)
+	cancelFn()
+	if len(result) != 1 || result[0].Configuration.Version != "v1" || len(result[0].Configuration.Selectors.Data) != 3 {
+		t.Fatal("the new more specific configuration should be notified:", result)
+	}
+
+	// fall back to the less specific configuration on deletion
+	ch, cancelFn, err = s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
+		Requested:    []configapi.RequestedConfigurationKey{{Group: "g", Key: "k2", Version: "v1"}},
+		Selectors:    clientSel,
+		Hierarchical: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancelFn()
+	pump.ch <- configapi.Event{Deleted: true, Configuration: &configapi.Configuration{Group: "g", Key: "k2", Version: "v1", Selectors: clientSel}}
+	result = waitNotification(t, ch, 2*time.Second)
+	if len(result) != 1 || result[0].Deleted || result[0].Configuration.Version != "v5" {
+		t.Fatal("should fall back to the less specific configuration:", result)
+	}
+
+	cfg, err := s.GetConfigurationHierarchical(configapi.DefaultTenant, "g", "k", &clientSel, &configapi.Selectors{})
+	if err != nil || cfg.Version != "v1" {
+		t.Fatal("unexpected configuration:", cfg, err)
+	}
+}
+
+func TestServer_RetrieveOrWait_HierarchicalStores(t *testing.T) {
+	s := newServer(PreparedDataPump{}, DefaultVersionComparator{})
+	s.selectorHierarchy = []string{"env", "dc", "app"}
+	envSel := configapi.Selectors{Data: map[string]string{"env": "prod"}}
+	round := newNotifyRound()
+	s.saveConfiguration(&configapi.Configuration{Group: "g", Key: "k", Version: "v1", Selectors: envSel}, round)
+	round.Flush()
+	countStores := func() (n int) {
+		s.stores.ForEach(configapi.DefaultTenant, func(string, string, *selectorsStore) {
+			n++
+		})
+		return
+	}
+	// the waiting is cancelled asynchronously
+	countWaiters := func() (n int) {
+		for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			n = 0
+			for i := range s.stores.shards {
+				shard := &s.stores.shards[i]
+				shard.lock.RLock()
+				n += len(shard.waiters)
+				shard.lock.RUnlock()
+			}
+			if n == 0 || time.Now().After(deadline) {
+				return
+			}
+		}
+	}
+
+	// waiting with arbitrary selectors does not create stores
+	for i := 0; i < 10; i++ {
+		ch, cancelFn, err := s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
+			Requested:         []configapi.RequestedConfigurationKey{{Group: "g", Key: "k", Version: "v1"}},
+			Selectors:         configapi.Selectors{Data: map[string]string{"env": "prod", "dc": "dc1", "app": fmt.Sprint("app", i)}},
+			OptionalSelectors: configapi.Selectors{Data: map[string]string{"host": fmt.Sprint("host", i)}},
+			Hierarchical:      true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ch) != 0 {
+			t.Fatal("should wait")
+		}
+		cancelFn()
+	}
+	if n := countStores(); n != 1 {
+		t.Fatal("waiting should not create stores:", n)
+	}
+	if n := countWaiters(); n != 0 {
+		t.Fatal("waiters should be removed after cancelled:", n)
+	}
+
+	// publishing to a store not created yet notifies the waiting request
+	clientSel := configapi.Selectors{Data: map[string]string{"env": "prod", "dc": "dc1", "app": "x"}}
+	ch, cancelFn, err := s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
+		Requested:    []configapi.RequestedConfigurationKey{{Group: "g", Key: "k", Version: "v1"}},
+		Selectors:    clientSel,
+		Hierarchical: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancelFn()
+	round = newNotifyRound()
+	s.saveConfiguration(&configapi.Configuration{Group: "g", Key: "k", Version: "v2", Selectors: clientSel}, round)
+	round.Flush()
+	result := waitNotification(t, ch, 2*time.Second)
+	if len(result) != 1 || result[0].Configuration.Version != "v2" {
+		t.Fatal("the configuration of the new store should be notified:", result)
+	}
+	if n := countWaiters(); n != 0 {
+		t.Fatal("waiters should be removed after notified:", n)
+	}
+}
@@ -0,0 +1,231 @@
+package configserver
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+const (
+	// minPumpBatchSize and maxPumpBatchSize bound the number of events applied in a round. The batch size grows
+	// when the events keep arriving faster than applied, so the lock is acquired less often under load.
+	minPumpBatchSize = 64
+	maxPumpBatchSize = 4096
+	// maxBatchWaitTime is the max time to wait for the remaining events of a batch
+	maxBatchWaitTime = 3 * time.Second
+)
+
+// listener is a waiting request registered to the keys of the stores. The listener is notified in one round at most,
+// since its channel is closed after the round while it may still be registered to the other keys until cancelled.
+type listener struct {
+	ch NotifyChannel
+	// fired is set by the round which notifies the listener. It is atomic since the listener may be registered to
+	// several stores which are updated by different rounds concurrently.
+	fired atomic.Bool
+}
+
+// notifyRound collects the notifications while the changes are applied under the locks of the stores, and fans them
+// out after the locks are released. The channel of a listener has the capacity of the keys it is registered to, so
+// fanning out never blocks.
+type notifyRound struct {
+	listeners []*listener
+	events    map[*listener][]NotifyEvent
+	// changes are the visible configurations changed in the round, consumed by server.renderTemplates
+	changes []storeChange
+}
+
+// storeChange is a changed configuration of a store
+type storeChange struct {
+	store *selectorsStore
+	key   string
+	// rendered means the rendition of the template changes while the template itself does not
+	rendered bool
+}
+
+func newNotifyRound() *notifyRound {
+	return &notifyRound{
+		events: map[*listener][]NotifyEvent{},
+	}
+}
+
+// Add should be called under the lock of the store the listener is registered to
+func (n *notifyRound) Add(l *listener, ev NotifyEvent) {
+	events, inRound := n.events[l]
+	if !inRound {
+		if !l.fired.CompareAndSwap(false, true) {
+			// notified by another round
+			return
+		}
+		n.listeners = append(n.listeners, l)
+	}
+	n.events[l] = append(events, ev)
+}
+
+// Changed records the change of the visible configuration, it should be called under the lock of the store
+func (n *notifyRound) Changed(store *selectorsStore, key string, rendered bool) {
+	n.changes = append(n.changes, storeChange{store: store, key: key, rendered: rendered})
+}
+
+// takeChanges returns the changes recorded since the last call
+func (n *notifyRound) takeChanges() []storeChange {
+	changes := n.changes
+	n.changes = nil
+	return changes
+}
+
+// Flush sends the events and closes the channels of the notified listeners, it should be called after the locks of
+// the stores are released
+func (n *notifyRound) Flush() {
+	for _, l := range n.listeners {
+		for _, ev := range n.events[l] {
+			l.ch <- ev
+		}
+		close(l.ch)
+	}
+	n.listeners = nil
+	n.events = map[*listener][]NotifyEvent{}
+}
+
+// pumpLoop blocks on the event channel of the pump and applies the events as soon as they arrive. The events already
+// in the channel are applied in the same round up to the adaptive batch size.
+func (s *server) pumpLoop() {
+	defer s.loops.Done()
+	ch := s.pump.EventChannel()
+	batchSize := minPumpBatchSize
+	for {
+		events, ok := waitPumpEvents(ch, s.closeCh, batchSize, maxBatchWaitTime)
+		if !ok {
+			return
+		}
+		s.applyEvents(events)
+		if len(events) >= batchSize {
+			batchSize = min(batchSize*2, maxPumpBatchSize)
+		} else {
+			batchSize = max(batchSize/2, minPumpBatchSize)
+		}
+	}
+}
+
+// applyEvents applies the events grouped by the stores, each group is applied and published at once under the lock
+// of the store. The listeners are notified after the locks are released.
+func (s *server) applyEvents(events []configapi.Event) {
+	// generate selectors cache
+	for _, ev := range events {
+		if ev.Configuration != nil {
+			configapi.SelectorsHelperCache(&ev.Configuration.Selectors)
+			configapi.SelectorsHelperCache(&ev.Configuration.OptionalSelectors)
+		}
+	}
+
+	// group events by stores in the order of arrival
+	var stores []*selectorsStore
+	groups := map[*selectorsStore][]configapi.Event{}
+	for _, ev := range events {
+		if ev.Configuration == nil || !(ev.Created || ev.Modified || ev.Deleted) {
+			//FIXME print error information of unknown event operation
+			log.Println("unknown pump event type")
+			continue
+		}
+		store := s.getOrCreateStore(ev.Configuration)
+		if _, ok := groups[store]; !ok {
+			stores = append(stores, store)
+		}
+		groups[store] = append(groups[store], ev)
+	}
+
+	round := newNotifyRound()
+	for _, store := range stores {
+		store.Update(func() {
+			for _, ev := range groups[store] {
+				if ev.Created || ev.Modified {
+					s.saveToStore(store, ev.Configuration, round)
+				} else {
+					store.deleteConfiguration(ev.Configuration, round)
+				}
+			}
+		})
+	}
+	// re-render the templates since the referenced configurations may change
+	s.renderTemplates(round)
+
+	round.Flush()
+}
+
+// waitPumpEvents blocks until an event arrives and then collects the following events via collectPumpEvents.
+// It returns false if closeCh or the event channel is closed.
+func waitPumpEvents(ch <-chan configapi.Event, closeCh <-chan struct{}, maxCnt int, batchWait time.Duration) ([]configapi.Event, bool) {
+	select {
+	case <-closeCh:
+		return nil, false
+	case ev, ok := <-ch:
+		if !ok {
+			log.Println("[WARN] pump event channel closed")
+			return nil, false
+		}
+		return collectPumpEvents(ch, []configapi.Event{ev}, maxCnt, batchWait), true
+	}
+}
+
+// collectPumpEvents appends the events available in the channel to events without blocking, up to maxCnt in total.
+// If the last collected events belong to an incomplete batch, it keeps waiting for the remaining events of the batch
+// up to batchWait, so that all configurations of a batch are applied and notified in the same round.
+func collectPumpEvents(ch <-chan configapi.Event, events []configapi.Event, maxCnt int, batchWait time.Duration) []configapi.Event {
+	var batch *configapi.BatchInfo
+	var batchCnt int
+	track := func(ev configapi.Event) {
+		var b *configapi.BatchInfo
+		if ev.Configuration != nil {
+			b = ev.Configuration.Batch
+		}
+		switch {
+		case b == nil:
+			batch, batchCnt = nil, 0
+		case batch != nil && batch.Id == b.Id:
+			batchCnt++
+		default:
+			batch, batchCnt = b, 1
+		}
+	}
+	add := func(ev configapi.Event) {
+		events = append(events, ev)
+		track(ev)
+	}
+	for _, ev := range events {
+		track(ev)
+	}
+
+CollectLoop:
+	for len(events) < maxCnt {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return events
+			}
+			add(ev)
+		default:
+			break CollectLoop
+		}
+	}
+	if batch == nil || batchCnt >= batch.Size {
+		return events
+	}
+
+	timer := time.NewTimer(batchWait)
+	defer timer.Stop()
+	for batch != nil && batchCnt < batch.Size {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return events
+			}
+			// an event out of the batch means the rest of the batch has been overwritten
+			add(ev)
+		case <-timer.C:
+			log.Println("[WARN] wait for the remaining events of batch timeout:", batch.Id)
+			return events
+		}
+	}
+	return events
+}
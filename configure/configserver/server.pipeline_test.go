@@ -0,0 +1,75 @@
+package configserver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+func TestNotifyRound_NotifiedOnce(t *testing.T) {
+	store := newSelectorsStore()
+	ch := make(NotifyChannel, 2)
+	l := &listener{ch: ch}
+	store.RegisterListener(1, "g", "k1", l)
+	store.RegisterListener(1, "g", "k2", l)
+
+	round := newNotifyRound()
+	store.SaveConfigurationWithNotification(&configapi.Configuration{Group: "g", Key: "k1", Version: "v1"}, round)
+	round.Flush()
+	// the listener is still registered to k2 before cancelled, while its channel has been closed
+	round = newNotifyRound()
+	store.SaveConfigurationWithNotification(&configapi.Configuration{Group: "g", Key: "k2", Version: "v1"}, round)
+	round.Flush()
+
+	var result []NotifyEvent
+	for ev := range ch {
+		result = append(result, ev)
+	}
+	if len(result) != 1 || result[0].Configuration.Key != "k1" {
+		t.Fatal("listener should be notified once:", result)
+	}
+}
+
+func TestServer_PumpLatency(t *testing.T) {
+	pump := &channelDataPump{ch: make(chan configapi.Event)}
+	s := newServer(pump, DefaultVersionComparator{})
+	if err := s.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = s.Shutdown()
+	}()
+
+	sel := configapi.Selectors{Data: map[string]string{"area": "dc1"}}
+	pump.ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Group: "g", Key: "k", Version: "v1", Selectors: sel}}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if _, err := s.GetConfigurationViaPlainRequest(configapi.DefaultTenant, "g", "k", "area=dc1", ""); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("configuration should be applied:", err)
+		}
+	}
+	for i := 2; i < 5; i++ {
+		version := fmt.Sprint("v", i)
+		ch, cancelFn, err := s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
+			Requested: []configapi.RequestedConfigurationKey{{Group: "g", Key: "k", Version: fmt.Sprint("v", i-1)}},
+			Selectors: sel,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		pump.ch <- configapi.Event{Modified: true, Configuration: &configapi.Configuration{Group: "g", Key: "k", Version: version, Selectors: sel}}
+		result := waitNotification(t, ch, time.Second)
+		cancelFn()
+		if len(result) != 1 || result[0].Configuration.Version != version {
+			t.Fatal("unexpected result:", result)
+		}
+		// the event is applied once arrived rather than after a fixed interval
+		if cost := time.Since(start); cost > 200*time.Millisecond {
+			t.Fatal("notification latency too high:", cost)
+		}
+	}
+}
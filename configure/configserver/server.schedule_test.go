@@ -0,0 +1,132 @@
+package configserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+func TestServer_ScheduledConfiguration(t *testing.T) {
+	s := newServer(PreparedDataPump{}, DefaultVersionComparator{})
+	sel := configapi.Selectors{Data: map[string]string{"area": "dc1"}}
+	now := time.Now().Unix()
+	fallback := &configapi.Configuration{Group: "group1", Key: "key1", Version: "v1", Value: []byte("old"), Selectors: sel, Timestamp: now - 10}
+	scheduled := &configapi.Configuration{Group: "group1", Key: "key1", Version: "v2", Value: []byte("new"), Selectors: sel,
+		Timestamp: now + 100, ExpiryTime: now + 200, ExpiryVersion: "v3", Fallback: fallback}
+	store := s.stores.GetOrCreate(configapi.DefaultTenant, "area=dc1", "")
+	if !store.SaveConfigurationWithNotification(scheduled, newNotifyRound()) {
+		t.Fatal("configuration should be scheduled")
+	}
+	s.scheduledStores[store] = struct{}{}
+
+	wait := func(version string) *configapi.Configuration {
+		ch, cancelFn, err := s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
+			Requested: []configapi.RequestedConfigurationKey{{Group: "group1", Key: "key1", Version: version}},
+			Selectors: sel,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cancelFn()
+		select {
+		case ev := <-ch:
+			return ev.Configuration
+		default:
+			return nil
+		}
+	}
+
+	if cfg := wait(""); cfg == nil || cfg.Version != "v1" {
+		t.Fatal("fallback should be visible before effective time:", cfg)
+	}
+	ch, cancelFn, err := s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
+		Requested: []configapi.RequestedConfigurationKey{{Group: "group1", Key: "key1", Version: "v1"}},
+		Selectors: sel,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.applySchedules(now + 50)
+	select {
+	case <-ch:
+		t.Fatal("should not be released before effective time")
+	default:
+	}
+	s.applySchedules(now + 100)
+	if ev, ok := <-ch; !ok || ev.Configuration.Version != "v2" || ev.Configuration.Fallback != nil {
+		t.Fatal("scheduled configuration should be released to the listener")
+	}
+	cancelFn()
+
+	s.applySchedules(now + 200)
+	if cfg := wait("v2"); cfg == nil || cfg.Version != "v3" || string(cfg.Value) != "old" {
+		t.Fatal("configuration should be reverted after expiry:", cfg)
+	}
+	if len(s.scheduledStores) != 0 {
+		t.Fatal("no more schedules expected")
+	}
+}
+
+func TestConfigureServer_PublishSchedule(t *testing.T) {
+	dw := &recordDataWriter{}
+	opt := ConfigureOptions{VersionComparatorName: VersionComparatorTimestamp}
+	opt.WriteApi.DataWriter = dw
+	s := NewConfigureServer(opt)
+
+	publish := func(req *configapi.PublishReq) *httptest.ResponseRecorder {
+		data, err := cbor.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/configure", bytes.NewReader(data))
+		r.Header.Set("Accept", "application/cbor")
+		r.Header.Set("Content-Type", "application/cbor")
+		w := httptest.NewRecorder()
+		s.writeServer.writeMux.ServeHTTP(w, r)
+		return w
+	}
+
+	effective := time.Now().Unix() + 3600
+	req := &configapi.PublishReq{
+		Configuration: configapi.RawConfiguration{Group: "group1", Key: "key1", Value: []byte("maintenance")},
+		Selectors:     configapi.Selectors{Data: map[string]string{"dc": "dc1"}},
+		EffectiveTime: effective,
+		ExpiryTime:    effective,
+	}
+	if w := publish(req); w.Code != http.StatusBadRequest {
+		t.Fatal("expiry should be later than the effective time:", w.Code)
+	}
+	req.ExpiryTime = effective + 600
+	if w := publish(req); w.Code != http.StatusOK {
+		t.Fatal("unexpected status code:", w.Code)
+	}
+	cfg := dw.saved[0]
+	if cfg.Timestamp != effective || cfg.ExpiryTime != effective+600 {
+		t.Fatal("unexpected schedule:", cfg.Timestamp, cfg.ExpiryTime)
+	}
+	if cfg.Version == "" || cfg.ExpiryVersion == "" || cfg.Version >= cfg.ExpiryVersion {
+		t.Fatal("versions should be generated from the schedule:", cfg.Version, cfg.ExpiryVersion)
+	}
+
+	// immediate publishing is effective earlier than the scheduled one
+	req.EffectiveTime, req.ExpiryTime = 0, 0
+	if w := publish(req); w.Code != http.StatusConflict {
+		t.Fatal("publishing earlier than the schedule should be rejected:", w.Code)
+	}
+	// twice within the same second
+	req.Configuration.Key = "key2"
+	for i := 0; i < 2; i++ {
+		if w := publish(req); w.Code != http.StatusOK {
+			t.Fatal("publishing within the same second should get a greater version:", w.Code)
+		}
+	}
+	if v1, v2 := dw.saved[1].Version, dw.saved[2].Version; v2 != v1+".1" {
+		t.Fatal("unexpected versions:", v1, v2)
+	}
+}
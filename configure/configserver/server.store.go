@@ -0,0 +1,576 @@
+package configserver
+
+import (
+	"cmp"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+const (
+	// registryShards is the number of shards of the store registry
+	registryShards = 64
+	// storeBuckets is the number of buckets of a store snapshot, an update only copies the buckets it touches
+	storeBuckets = 64
+)
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}
+
+type registryKey struct {
+	tenant       string
+	selectorsKey string
+}
+
+// selectorsEntry contains the store of the selectors and the stores of the optional selectors under the selectors
+type selectorsEntry struct {
+	SelectorsStore *selectorsStore
+	// OptSelectorsStore is protected by the lock of the shard
+	OptSelectorsStore map[string]*selectorsStore
+}
+
+// storeKey identifies the store of the exact selector combination
+type storeKey struct {
+	registryKey
+	optSelectorsKey string
+}
+
+type registryShard struct {
+	lock    sync.RWMutex
+	entries map[registryKey]*selectorsEntry
+	// waiters contains the listeners waiting for the stores to be created, see GetOrWait
+	waiters map[storeKey]map[int64]*listener
+}
+
+// storeRegistry holds the stores of the selector combinations of all tenants. It is sharded by [tenant, selectors], so
+// looking up the stores does not contend on a global lock. Stores are never removed once created.
+type storeRegistry struct {
+	shards [registryShards]registryShard
+	// historySize is the number of previous versions kept per configuration for the delta encoding
+	historySize int
+	nextId      atomic.Int64
+}
+
+func newStoreRegistry() *storeRegistry {
+	r := &storeRegistry{}
+	for i := range r.shards {
+		r.shards[i].entries = map[registryKey]*selectorsEntry{}
+		r.shards[i].waiters = map[storeKey]map[int64]*listener{}
+	}
+	return r
+}
+
+func (r *storeRegistry) shard(k registryKey) *registryShard {
+	return &r.shards[hashString(k.tenant+"|"+k.selectorsKey)%registryShards]
+}
+
+func (r *storeRegistry) newStore(k storeKey) *selectorsStore {
+	store := newSelectorsStore()
+	store.key = k
+	store.id = r.nextId.Add(1)
+	store.historySize = r.historySize
+	return store
+}
+
+// Get returns the store by selectors + optional selectors, falling back to the store by selectors if the optional
+// selectors are empty or not found, otherwise nil
+func (r *storeRegistry) Get(tenant, selectorsKey, optSelectorsKey string) *selectorsStore {
+	k := registryKey{tenant: tenant, selectorsKey: selectorsKey}
+	shard := r.shard(k)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	v, ok := shard.entries[k]
+	if !ok {
+		return nil
+	}
+	if optSelectorsKey != "" {
+		if store, ok := v.OptSelectorsStore[optSelectorsKey]; ok {
+			return store
+		}
+	}
+	return v.SelectorsStore
+}
+
+// GetExact returns the store of the exact selector combination, nil if not exist
+func (r *storeRegistry) GetExact(tenant, selectorsKey, optSelectorsKey string) *selectorsStore {
+	k := registryKey{tenant: tenant, selectorsKey: selectorsKey}
+	shard := r.shard(k)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	v, ok := shard.entries[k]
+	if !ok {
+		return nil
+	}
+	if optSelectorsKey == "" {
+		return v.SelectorsStore
+	}
+	return v.OptSelectorsStore[optSelectorsKey]
+}
+
+// GetOrCreate returns the store of the exact selector combination, created if not exist. The listeners waiting for
+// the creation of the store are notified without events.
+func (r *storeRegistry) GetOrCreate(tenant, selectorsKey, optSelectorsKey string) *selectorsStore {
+	if store := r.GetExact(tenant, selectorsKey, optSelectorsKey); store != nil {
+		return store
+	}
+	k := registryKey{tenant: tenant, selectorsKey: selectorsKey}
+	shard := r.shard(k)
+	var waiters []map[int64]*listener
+	defer func() {
+		for _, m := range waiters {
+			for _, l := range m {
+				if l.fired.CompareAndSwap(false, true) {
+					close(l.ch)
+				}
+			}
+		}
+	}()
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	v, ok := shard.entries[k]
+	if !ok {
+		v = &selectorsEntry{SelectorsStore: r.newStore(storeKey{registryKey: k}), OptSelectorsStore: map[string]*selectorsStore{}}
+		shard.entries[k] = v
+		waiters = append(waiters, shard.takeWaiters(storeKey{registryKey: k}))
+	}
+	if optSelectorsKey == "" {
+		return v.SelectorsStore
+	}
+	store, ok := v.OptSelectorsStore[optSelectorsKey]
+	if !ok {
+		store = r.newStore(storeKey{registryKey: k, optSelectorsKey: optSelectorsKey})
+		v.OptSelectorsStore[optSelectorsKey] = store
+		waiters = append(waiters, shard.takeWaiters(storeKey{registryKey: k, optSelectorsKey: optSelectorsKey}))
+	}
+	return store
+}
+
+// takeWaiters removes and returns the listeners waiting for the store, the lock of the shard should be held
+func (s *registryShard) takeWaiters(k storeKey) map[int64]*listener {
+	m := s.waiters[k]
+	delete(s.waiters, k)
+	return m
+}
+
+// GetOrWait returns the store of the exact selector combination if exists. Otherwise the listener is registered to be
+// notified once the store is created, without creating the store, so the waiting requests do not grow the stores
+// which are never removed. The waiting should be cancelled by CancelCreationWait.
+func (r *storeRegistry) GetOrWait(tenant, selectorsKey, optSelectorsKey string, reqid int64, l *listener) *selectorsStore {
+	if store := r.GetExact(tenant, selectorsKey, optSelectorsKey); store != nil {
+		return store
+	}
+	k := storeKey{registryKey: registryKey{tenant: tenant, selectorsKey: selectorsKey}, optSelectorsKey: optSelectorsKey}
+	shard := r.shard(k.registryKey)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if v, ok := shard.entries[k.registryKey]; ok {
+		if optSelectorsKey == "" {
+			return v.SelectorsStore
+		}
+		if store, ok := v.OptSelectorsStore[optSelectorsKey]; ok {
+			return store
+		}
+	}
+	m := shard.waiters[k]
+	if m == nil {
+		m = map[int64]*listener{}
+		shard.waiters[k] = m
+	}
+	m[reqid] = l
+	return nil
+}
+
+// CancelCreationWait removes the listener registered by GetOrWait
+func (r *storeRegistry) CancelCreationWait(tenant, selectorsKey, optSelectorsKey string, reqid int64) {
+	k := storeKey{registryKey: registryKey{tenant: tenant, selectorsKey: selectorsKey}, optSelectorsKey: optSelectorsKey}
+	shard := r.shard(k.registryKey)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if m, ok := shard.waiters[k]; ok {
+		delete(m, reqid)
+		if len(m) == 0 {
+			delete(shard.waiters, k)
+		}
+	}
+}
+
+// ForEach iterates all selector combinations of the tenant in the order of selectors then optional selectors.
+// fn is called without the locks of the shards.
+func (r *storeRegistry) ForEach(tenant string, fn func(selectorsKey, optSelectorsKey string, store *selectorsStore)) {
+	type item struct {
+		selectorsKey, optSelectorsKey string
+		store                         *selectorsStore
+	}
+	var items []item
+	for i := range r.shards {
+		shard := &r.shards[i]
+		shard.lock.RLock()
+		for k, v := range shard.entries {
+			if k.tenant != tenant {
+				continue
+			}
+			items = append(items, item{selectorsKey: k.selectorsKey, store: v.SelectorsStore})
+			for optKey, store := range v.OptSelectorsStore {
+				items = append(items, item{selectorsKey: k.selectorsKey, optSelectorsKey: optKey, store: store})
+			}
+		}
+		shard.lock.RUnlock()
+	}
+	slices.SortFunc(items, func(a, b item) int {
+		return cmp.Or(cmp.Compare(a.selectorsKey, b.selectorsKey), cmp.Compare(a.optSelectorsKey, b.optSelectorsKey))
+	})
+	for _, v := range items {
+		fn(v.selectorsKey, v.optSelectorsKey, v.store)
+	}
+}
+
+// lockStores locks the distinct stores in the order of the ids to avoid deadlock, and returns the unlock function
+func lockStores(stores []*selectorsStore) func() {
+	stores = slices.Clone(stores)
+	slices.SortFunc(stores, func(a, b *selectorsStore) int {
+		return cmp.Compare(a.id, b.id)
+	})
+	stores = slices.Compact(stores)
+	for _, store := range stores {
+		store.lock.Lock()
+	}
+	return func() {
+		for _, store := range stores {
+			store.lock.Unlock()
+		}
+	}
+}
+
+// storeEntry is immutable once published in a snapshot
+type storeEntry struct {
+	// cfg is the visible configuration as published
+	cfg *configapi.Configuration
+	// rendered is the rendition if cfg is a template, see server.renderTemplates
+	rendered *configapi.Configuration
+}
+
+// Visible returns the configuration responded to the clients, the rendered one for the template or nil if not
+// rendered yet
+func (e *storeEntry) Visible() *configapi.Configuration {
+	if e.cfg.Template {
+		return e.rendered
+	}
+	return e.cfg
+}
+
+// storeSnapshot contains the visible configurations of a store. It is immutable once published, so that it is read
+// without lock. The configurations are split into buckets, so an update only copies the buckets it touches.
+type storeSnapshot struct {
+	buckets [storeBuckets]map[string]*storeEntry
+	count   int
+}
+
+func bucketOf(key string) int {
+	return int(hashString(key) % storeBuckets)
+}
+
+func (s *storeSnapshot) get(key string) *storeEntry {
+	return s.buckets[bucketOf(key)][key]
+}
+
+// visible returns the visible configuration of the entry, see storeEntry.Visible
+func (s *storeSnapshot) visible(group, key string) *configapi.Configuration {
+	if e := s.get(cfgKeyOf(group, key)); e != nil {
+		return e.Visible()
+	}
+	return nil
+}
+
+func (s *storeSnapshot) forEach(fn func(key string, e *storeEntry)) {
+	for _, bucket := range s.buckets {
+		for k, e := range bucket {
+			fn(k, e)
+		}
+	}
+}
+
+type selectorsStore struct {
+	// id orders the locking of multiple stores
+	id int64
+	// key is the selector combination of the store
+	key storeKey
+	// snapshot contains the visible configurations, which is read without lock and replaced as a whole by the updates
+	snapshot atomic.Pointer[storeSnapshot]
+
+	// lock protects the fields below and serializes the updates of snapshot. The updates are published before the lock
+	// is released, so the snapshot read with the lock held is the latest.
+	lock sync.Mutex
+	// pending is the copy of snapshot being updated, published by commit
+	pending *storeSnapshot
+	touched [storeBuckets]bool
+	// listeners contains the waiting requests per configuration
+	listeners map[string]map[int64]*listener
+	// schedules contains the scheduled configurations with pending transitions
+	schedules map[string]*configapi.Configuration
+	// history contains the previous visible configurations, at most historySize per configuration, the latest first
+	history     map[string][]*configapi.Configuration
+	historySize int
+}
+
+func newSelectorsStore() *selectorsStore {
+	s := &selectorsStore{
+		listeners: map[string]map[int64]*listener{},
+		schedules: make(map[string]*configapi.Configuration),
+		history:   make(map[string][]*configapi.Configuration),
+	}
+	s.snapshot.Store(&storeSnapshot{})
+	return s
+}
+
+func (s *selectorsStore) cfgKey(group, key string) string {
+	return cfgKeyOf(group, key)
+}
+
+func cfgKeyOf(group, key string) string {
+	return group + "||" + key
+}
+
+// Update calls fn with the lock held and publishes the changes made by fn
+func (s *selectorsStore) Update(fn func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fn()
+	s.commit()
+}
+
+// view returns the snapshot including the pending changes, the lock should be held
+func (s *selectorsStore) view() *storeSnapshot {
+	if s.pending != nil {
+		return s.pending
+	}
+	return s.snapshot.Load()
+}
+
+// put sets the entry to the pending snapshot, the lock should be held. nil entry means removal.
+func (s *selectorsStore) put(key string, e *storeEntry) {
+	if s.pending == nil {
+		p := *s.snapshot.Load()
+		s.pending = &p
+		s.touched = [storeBuckets]bool{}
+	}
+	idx := bucketOf(key)
+	if !s.touched[idx] {
+		bucket := maps.Clone(s.pending.buckets[idx])
+		if bucket == nil {
+			bucket = map[string]*storeEntry{}
+		}
+		s.pending.buckets[idx] = bucket
+		s.touched[idx] = true
+	}
+	bucket := s.pending.buckets[idx]
+	_, exists := bucket[key]
+	if e == nil {
+		if exists {
+			delete(bucket, key)
+			s.pending.count--
+		}
+		return
+	}
+	if !exists {
+		s.pending.count++
+	}
+	bucket[key] = e
+}
+
+// commit publishes the pending snapshot, the lock should be held
+func (s *selectorsStore) commit() {
+	if s.pending != nil {
+		s.snapshot.Store(s.pending)
+		s.pending = nil
+	}
+}
+
+// GetConfiguration returns the visible configuration without lock, the rendered one for the template or nil if not
+// rendered yet
+func (s *selectorsStore) GetConfiguration(group, key string) *configapi.Configuration {
+	return s.snapshot.Load().visible(group, key)
+}
+
+// GetRawConfiguration returns the visible configuration without rendering the template
+func (s *selectorsStore) GetRawConfiguration(group, key string) *configapi.Configuration {
+	if e := s.snapshot.Load().get(s.cfgKey(group, key)); e != nil {
+		return e.cfg
+	}
+	return nil
+}
+
+func (s *selectorsStore) GetHistoryConfiguration(group, key, version string) *configapi.Configuration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, v := range s.history[s.cfgKey(group, key)] {
+		// the clients never hold the unrendered templates
+		if v.Version == version && !v.Template {
+			return v
+		}
+	}
+	return nil
+}
+
+// RegisterListener registers the listener to the key, the lock should be held together with checking the visible
+// configurations so that no update is lost in between. The same listener should be used for all keys of a request.
+func (s *selectorsStore) RegisterListener(reqid int64, group string, key string, l *listener) {
+	m := s.listeners[s.cfgKey(group, key)]
+	if m == nil {
+		m = map[int64]*listener{}
+		s.listeners[s.cfgKey(group, key)] = m
+	}
+	m[reqid] = l
+}
+
+func (s *selectorsStore) CancelWait(reqid int64, list []struct {
+	Group string
+	Key   string
+}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cancelWait(reqid, list)
+}
+
+// cancelWait is CancelWait with the lock held
+func (s *selectorsStore) cancelWait(reqid int64, list []struct {
+	Group string
+	Key   string
+}) {
+	for _, v := range list {
+		cfgKey := s.cfgKey(v.Group, v.Key)
+		delete(s.listeners[cfgKey], reqid)
+		if len(s.listeners[cfgKey]) == 0 {
+			delete(s.listeners, cfgKey)
+		}
+	}
+}
+
+// SaveConfigurationWithNotification saves the configuration and notifies the listeners if the visible configuration changes.
+// It returns true if the configuration has pending transitions which should be applied via ApplySchedules.
+func (s *selectorsStore) SaveConfigurationWithNotification(configuration *configapi.Configuration, round *notifyRound) (scheduled bool) {
+	s.Update(func() {
+		scheduled = s.saveConfiguration(configuration, round)
+	})
+	return scheduled
+}
+
+// saveConfiguration is SaveConfigurationWithNotification with the lock held
+func (s *selectorsStore) saveConfiguration(configuration *configapi.Configuration, round *notifyRound) bool {
+	key := s.cfgKey(configuration.Group, configuration.Key)
+	now := time.Now().Unix()
+	scheduled := configuration.NextTransition(now) > 0
+	if scheduled {
+		s.schedules[key] = configuration
+	} else {
+		delete(s.schedules, key)
+	}
+	s.applyVisible(key, configuration.EffectiveAt(now), round)
+	return scheduled
+}
+
+// ApplySchedules applies the transitions of scheduled configurations which are due.
+// It returns true if there are still pending transitions.
+func (s *selectorsStore) ApplySchedules(now int64, round *notifyRound) (pending bool) {
+	s.Update(func() {
+		pending = s.applySchedules(now, round)
+	})
+	return pending
+}
+
+// applySchedules is ApplySchedules with the lock held
+func (s *selectorsStore) applySchedules(now int64, round *notifyRound) bool {
+	for key, configuration := range s.schedules {
+		s.applyVisible(key, configuration.EffectiveAt(now), round)
+		if configuration.NextTransition(now) == 0 {
+			delete(s.schedules, key)
+		}
+	}
+	return len(s.schedules) > 0
+}
+
+func (s *selectorsStore) applyVisible(key string, configuration *configapi.Configuration, round *notifyRound) {
+	cur := s.view().get(key)
+	if configuration == nil {
+		// not visible yet
+		s.put(key, nil)
+		if cur != nil {
+			round.Changed(s, key, false)
+		}
+		return
+	}
+	if cur != nil && cur.cfg.Version == configuration.Version && cur.cfg.Signature == configuration.Signature {
+		// no change to the visible configuration
+		s.put(key, &storeEntry{cfg: configuration, rendered: cur.rendered})
+		return
+	}
+	if cur != nil && s.historySize > 0 {
+		history := append([]*configapi.Configuration{cur.cfg}, s.history[key]...)
+		s.history[key] = history[:min(len(history), s.historySize)]
+	}
+	round.Changed(s, key, false)
+	if configuration.Template {
+		// the previous rendition is kept visible until rendered, then notified
+		var rendered *configapi.Configuration
+		if cur != nil {
+			rendered = cur.rendered
+		}
+		s.put(key, &storeEntry{cfg: configuration, rendered: rendered})
+		return
+	}
+	s.put(key, &storeEntry{cfg: configuration})
+	s.notifyListeners(key, configuration, round)
+}
+
+func (s *selectorsStore) notifyListeners(key string, configuration *configapi.Configuration, round *notifyRound) {
+	if listenerMap, ok := s.listeners[key]; ok {
+		for _, l := range listenerMap {
+			round.Add(l, NotifyEvent{
+				Configuration: configuration,
+			})
+		}
+		delete(s.listeners, key)
+	}
+}
+
+// DeleteConfiguration deletes the configuration and notifies the listeners with the tombstone
+func (s *selectorsStore) DeleteConfiguration(configuration *configapi.Configuration, round *notifyRound) {
+	s.Update(func() {
+		s.deleteConfiguration(configuration, round)
+	})
+}
+
+// deleteConfiguration is DeleteConfiguration with the lock held
+func (s *selectorsStore) deleteConfiguration(configuration *configapi.Configuration, round *notifyRound) {
+	key := s.cfgKey(configuration.Group, configuration.Key)
+	cur := s.view().get(key)
+	s.put(key, nil)
+	delete(s.schedules, key)
+	delete(s.history, key)
+	if cur != nil {
+		round.Changed(s, key, false)
+	}
+	if listenerMap, exists := s.listeners[key]; exists {
+		if cur != nil {
+			tombstone := &configapi.Configuration{Group: cur.cfg.Group, Key: cur.cfg.Key, Version: cur.cfg.Version}
+			if cur.rendered != nil {
+				// the clients hold the rendered version
+				tombstone.Version = cur.rendered.Version
+			}
+			for _, l := range listenerMap {
+				round.Add(l, NotifyEvent{
+					Configuration: tombstone,
+					Deleted:       true,
+				})
+			}
+		}
+		delete(s.listeners, key)
+	}
+}
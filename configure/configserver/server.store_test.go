@@ -0,0 +1,164 @@
+package configserver
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+func TestSelectorsStore_SnapshotIsolation(t *testing.T) {
+	store := newSelectorsStore()
+	store.SaveConfigurationWithNotification(&configapi.Configuration{Group: "g", Key: "k1", Version: "v1"}, newNotifyRound())
+	before := store.snapshot.Load()
+
+	store.Update(func() {
+		store.saveConfiguration(&configapi.Configuration{Group: "g", Key: "k1", Version: "v2"}, newNotifyRound())
+		store.saveConfiguration(&configapi.Configuration{Group: "g", Key: "k2", Version: "v1"}, newNotifyRound())
+		// not published until the update completes
+		if cfg := store.GetConfiguration("g", "k1"); cfg.Version != "v1" || store.GetConfiguration("g", "k2") != nil {
+			t.Fatal("pending changes should not be visible:", cfg.Version)
+		}
+	})
+	if cfg := store.GetConfiguration("g", "k1"); cfg.Version != "v2" || store.GetConfiguration("g", "k2") == nil {
+		t.Fatal("changes should be published together")
+	}
+	if before.get(store.cfgKey("g", "k1")).cfg.Version != "v1" || before.count != 1 || store.snapshot.Load().count != 2 {
+		t.Fatal("published snapshot should not be modified")
+	}
+}
+
+// TestServer_ConcurrentListeners runs the long polling requests, the cancellations and the pump events concurrently,
+// and should be run with the race detector. Each epoch updates all keys in several rounds and waits until the patient
+// clients, which never cancel, receive the last round, so an update lost between the check and the registration fails
+// the epoch.
+func TestServer_ConcurrentListeners(t *testing.T) {
+	const (
+		keyCnt     = 8
+		clientCnt  = 64
+		epochCnt   = 100
+		roundCnt   = 5
+		epochLimit = 5 * time.Second
+	)
+	// the interleavings between the check and the registration rarely happen without parallelism
+	if runtime.GOMAXPROCS(0) < 4 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	}
+	pump := &channelDataPump{ch: make(chan configapi.Event, 64)}
+	s := newServer(pump, DefaultVersionComparator{})
+	s.selectorHierarchy = []string{"app"}
+	if err := s.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = s.Shutdown()
+	}()
+
+	sel := configapi.Selectors{Data: map[string]string{"area": "dc1"}}
+	other := configapi.Selectors{Data: map[string]string{"area": "dc2"}}
+	version := func(i int) string {
+		return fmt.Sprintf("v%06d", i)
+	}
+	publish := func(i int) {
+		for k := 0; k < keyCnt; k++ {
+			pump.ch <- configapi.Event{Modified: true, Configuration: &configapi.Configuration{Group: "g", Key: fmt.Sprint("k", k), Version: version(i), Selectors: sel}}
+			// updates of another store in the same rounds
+			pump.ch <- configapi.Event{Modified: true, Configuration: &configapi.Configuration{Group: "g", Key: fmt.Sprint("k", k), Version: version(i), Selectors: other}}
+		}
+	}
+	publish(0)
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if _, err := s.GetConfigurationViaPlainRequest(configapi.DefaultTenant, "g", fmt.Sprint("k", keyCnt-1), "area=dc1", ""); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("configurations should be applied:", err)
+		}
+	}
+
+	seen := make([]atomic.Value, clientCnt)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	errCh := make(chan error, clientCnt)
+	for c := 0; c < clientCnt; c++ {
+		seen[c].Store(version(0))
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			key := fmt.Sprint("k", c%keyCnt)
+			patient := c%2 == 0
+			r := rand.New(rand.NewSource(int64(c)))
+			hierarchical := c%4 < 2
+			current := version(0)
+			for {
+				// the request is still referenced after cancelled in hierarchical mode
+				req := &configapi.AcquireConfigurationReq{
+					Requested: []configapi.RequestedConfigurationKey{{Group: "g", Key: key, Version: current}},
+					Selectors: sel,
+				}
+				if hierarchical {
+					req.Selectors = configapi.Selectors{Data: map[string]string{"area": "dc1", "app": "x"}}
+					req.Hierarchical = true
+				}
+				ch, cancelFn, err := s.RetrieveOrWait(configapi.DefaultTenant, req)
+				if err != nil {
+					errCh <- err
+					return
+				}
+				var timeout <-chan time.Time
+				if !patient {
+					timeout = time.After(time.Duration(r.Intn(500)) * time.Microsecond)
+				}
+				select {
+				case ev, ok := <-ch:
+					if ok {
+						if ev.Configuration.Version <= current {
+							errCh <- fmt.Errorf("client %d received stale version of %s: %s", c, key, ev.Configuration.Version)
+							cancelFn()
+							return
+						}
+						current = ev.Configuration.Version
+						seen[c].Store(current)
+					}
+				case <-timeout:
+				case <-stop:
+					cancelFn()
+					return
+				}
+				cancelFn()
+			}
+		}(c)
+	}
+
+EpochLoop:
+	for e := 0; e < epochCnt; e++ {
+		deadline := time.Now().Add(epochLimit)
+		for i := e*roundCnt + 1; i <= (e+1)*roundCnt; i++ {
+			publish(i)
+			// the next round is published while the clients notified by this round are registering again
+			for seen[0].Load().(string) < version(i) && time.Now().Before(deadline) {
+				runtime.Gosched()
+			}
+		}
+		last := version((e + 1) * roundCnt)
+		for c := 0; c < clientCnt; c += 2 {
+			for seen[c].Load().(string) < last {
+				if time.Now().After(deadline) {
+					t.Errorf("client %d lost the update %s: %s", c, last, seen[c].Load())
+					break EpochLoop
+				}
+				time.Sleep(100 * time.Microsecond)
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Error(err)
+	}
+}
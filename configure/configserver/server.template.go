@@ -0,0 +1,475 @@
+package configserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+// maxTemplateRenderPasses bounds the rendering passes per round for the templates referencing other templates, so
+// that the cyclic references are left unresolved rather than rendered forever
+const maxTemplateRenderPasses = 8
+
+var (
+	ErrSecretNotResolved    = errors.New("secret not resolved yet")
+	ErrNoSecretResolver     = errors.New("no secret resolver configured")
+	ErrReferenceNotFound    = errors.New("referenced configuration not found")
+	ErrSelectorNotFound     = errors.New("selector not found")
+	ErrSelfReference        = errors.New("configuration references itself")
+	ErrUnsupportedRendition = errors.New("version of template should not contain " + configapi.RenditionSeparator)
+)
+
+// templateScope is where the placeholders of the templates in a store are resolved
+type templateScope struct {
+	tenant          string
+	selectorsKey    string
+	optSelectorsKey string
+	selectors       configapi.Selectors
+	// values are the selectors merged with the optional selectors for ${selector.name}
+	values map[string]string
+}
+
+func newTemplateScope(cfg *configapi.Configuration) templateScope {
+	values := maps.Clone(cfg.Selectors.Data)
+	if values == nil {
+		values = map[string]string{}
+	}
+	maps.Copy(values, cfg.OptionalSelectors.Data)
+	return templateScope{
+		tenant:          cfg.Tenant,
+		selectorsKey:    configapi.SelectorsHelperCacheValue(&cfg.Selectors),
+		optSelectorsKey: configapi.SelectorsHelperCacheValue(&cfg.OptionalSelectors),
+		selectors:       cfg.Selectors,
+		values:          values,
+	}
+}
+
+// hasUpdate checks whether the visible configuration is newer than the requested version.
+// The renditions of the same template version are compared by the digests, which are not ordered.
+func (s *server) hasUpdate(requestVersion string, cfg *configapi.Configuration) bool {
+	if !cfg.Template {
+		return s.versionComparator.HasUpdate(requestVersion, cfg.Version)
+	}
+	reqVersion, reqDigest := configapi.SplitRenderedVersion(requestVersion)
+	version, digest := configapi.SplitRenderedVersion(cfg.Version)
+	if reqVersion == version {
+		return reqDigest != digest
+	}
+	return s.versionComparator.HasUpdate(reqVersion, version)
+}
+
+// templateRef identifies a template in a store
+type templateRef struct {
+	store *selectorsStore
+	key   string
+}
+
+// dependency is what a template depends on, a configuration of the exact selector combination or a secret
+type dependency struct {
+	storeKey
+	cfgKey string
+	secret string
+}
+
+// candidates returns the selector combinations the references of the templates are resolved from
+func (t templateScope) candidates(hierarchy []string) []string {
+	if len(hierarchy) == 0 {
+		return []string{t.selectorsKey}
+	}
+	return hierarchyCandidates(hierarchy, &t.selectors)
+}
+
+// renderTemplates renders the templates affected by the changes of the round and the refreshed secrets, and notifies
+// the listeners of the changed renditions. The affected templates are found via the dependency index, which is
+// updated while rendering. The templates are rendered in passes until no rendition changes, since a template may
+// reference another template. Rendering errors are logged once and the previous renditions are kept visible.
+func (s *server) renderTemplates(round *notifyRound) {
+	s.renderLock.Lock()
+	defer s.renderLock.Unlock()
+	var secrets []string
+	if s.secrets != nil {
+		secrets = s.secrets.Refresh(time.Now(), func(name string) bool {
+			_, ok := s.dependents[dependency{secret: name}]
+			return ok
+		})
+	}
+	dirty := s.affectedTemplates(round.takeChanges(), secrets)
+	for pass := 0; pass < maxTemplateRenderPasses && len(dirty) > 0; pass++ {
+		s.trackLock.Lock()
+		scopes := maps.Clone(s.templateStores)
+		s.trackLock.Unlock()
+		stores := map[*selectorsStore][]string{}
+		for ref := range dirty {
+			stores[ref.store] = append(stores[ref.store], ref.key)
+		}
+		for store, keys := range stores {
+			scope, ok := scopes[store]
+			if !ok {
+				continue
+			}
+			store.Update(func() {
+				s.renderStore(store, scope, keys, round)
+			})
+		}
+		dirty = s.affectedTemplates(round.takeChanges(), nil)
+	}
+}
+
+// affectedTemplates returns the changed templates and the templates depending on the changes. The changed
+// configurations which are no longer templates are removed from the index.
+func (s *server) affectedTemplates(changes []storeChange, secrets []string) map[templateRef]struct{} {
+	dirty := map[templateRef]struct{}{}
+	for _, c := range changes {
+		for ref := range s.dependents[dependency{storeKey: c.store.key, cfgKey: c.key}] {
+			dirty[ref] = struct{}{}
+		}
+		if c.rendered {
+			continue
+		}
+		ref := templateRef{store: c.store, key: c.key}
+		if e := c.store.snapshot.Load().get(c.key); e != nil && e.cfg.Template {
+			dirty[ref] = struct{}{}
+		} else {
+			s.untrackTemplate(ref)
+		}
+	}
+	for _, name := range secrets {
+		for ref := range s.dependents[dependency{secret: name}] {
+			dirty[ref] = struct{}{}
+		}
+	}
+	return dirty
+}
+
+// setDependencies replaces the dependencies of the template in the index, renderLock should be held
+func (s *server) setDependencies(ref templateRef, deps []dependency) {
+	for _, d := range s.dependencies[ref] {
+		delete(s.dependents[d], ref)
+		if len(s.dependents[d]) == 0 {
+			delete(s.dependents, d)
+		}
+	}
+	delete(s.dependencies, ref)
+	if len(deps) == 0 {
+		return
+	}
+	s.dependencies[ref] = deps
+	for _, d := range deps {
+		m := s.dependents[d]
+		if m == nil {
+			m = map[templateRef]struct{}{}
+			s.dependents[d] = m
+		}
+		m[ref] = struct{}{}
+	}
+}
+
+// untrackTemplate removes the template no longer visible from the index, and the store from templateStores if it
+// has no more templates. renderLock should be held.
+func (s *server) untrackTemplate(ref templateRef) {
+	s.setDependencies(ref, nil)
+	delete(s.renderErrors, renderErrorKey(ref))
+	s.trackLock.Lock()
+	_, tracked := s.templateStores[ref.store]
+	s.trackLock.Unlock()
+	if !tracked {
+		return
+	}
+	ref.store.lock.Lock()
+	defer ref.store.lock.Unlock()
+	if !ref.store.hasTemplates() {
+		s.trackLock.Lock()
+		delete(s.templateStores, ref.store)
+		s.trackLock.Unlock()
+	}
+}
+
+func renderErrorKey(ref templateRef) string {
+	k := ref.store.key
+	return k.tenant + "|" + k.selectorsKey + "|" + k.optSelectorsKey + "|" + strings.Replace(ref.key, "||", "/", 1)
+}
+
+// renderStore renders the templates of the store with the lock of the store held. The references are read from the
+// published snapshots, so the renditions of the other stores in the same pass are visible after they are committed.
+// The version of a rendition is derived from the versions of the inputs rather than the rendered value, which may
+// contain secrets.
+func (s *server) renderStore(store *selectorsStore, scope templateScope, keys []string, round *notifyRound) {
+	candidates := scope.candidates(s.selectorHierarchy)
+	for _, key := range keys {
+		e := store.view().get(key)
+		if e == nil || !e.cfg.Template {
+			continue
+		}
+		cfg := e.cfg
+		ref := templateRef{store: store, key: key}
+		placeholders, err := configapi.ParseTemplate(cfg.Value)
+		if err == nil {
+			s.setDependencies(ref, templateDependencies(scope, candidates, placeholders))
+			inputs := make([]string, 0, len(placeholders))
+			var value []byte
+			value, err = configapi.RenderTemplate(cfg.Value, func(p configapi.Placeholder) (string, error) {
+				v, input, err := s.resolvePlaceholder(scope, candidates, cfg, p)
+				if input != "" {
+					inputs = append(inputs, p.String()+"="+input)
+				}
+				return v, err
+			})
+			if err == nil {
+				rendered := *cfg
+				rendered.Value = value
+				rendered.Signature = rendered.GenerateSignature()
+				rendered.Version = configapi.RenderedVersion(cfg.Version, inputs)
+				store.applyRendered(key, &rendered, round)
+				delete(s.renderErrors, renderErrorKey(ref))
+				continue
+			}
+		}
+		errKey := renderErrorKey(ref)
+		if s.renderErrors[errKey] != err.Error() {
+			log.Println("[ERROR] render template failed:", errKey, err)
+		}
+		s.renderErrors[errKey] = err.Error()
+	}
+}
+
+// templateDependencies returns the configurations of every candidate selector combination and the secrets the
+// placeholders may resolve to
+func templateDependencies(scope templateScope, candidates []string, placeholders []configapi.Placeholder) []dependency {
+	var deps []dependency
+	for _, p := range placeholders {
+		switch p.Kind {
+		case configapi.PlaceholderSelector:
+		case configapi.PlaceholderSecret:
+			deps = append(deps, dependency{secret: p.Name})
+		default:
+			cfgKey := cfgKeyOf(p.Group, p.Key)
+			for _, c := range candidates {
+				if scope.optSelectorsKey != "" {
+					deps = append(deps, dependency{storeKey: storeKey{registryKey: registryKey{tenant: scope.tenant, selectorsKey: c}, optSelectorsKey: scope.optSelectorsKey}, cfgKey: cfgKey})
+				}
+				deps = append(deps, dependency{storeKey: storeKey{registryKey: registryKey{tenant: scope.tenant, selectorsKey: c}}, cfgKey: cfgKey})
+			}
+		}
+	}
+	return deps
+}
+
+// resolvePlaceholder resolves the placeholder of the template cfg, and returns the version of the input for the
+// version of the rendition, empty for the selectors which are fixed in the scope. The references are resolved in the
+// same tenant and selectors of the template, falling back to the less specific selectors if the selector hierarchy is
+// declared.
+func (s *server) resolvePlaceholder(scope templateScope, candidates []string, cfg *configapi.Configuration, p configapi.Placeholder) (string, string, error) {
+	switch p.Kind {
+	case configapi.PlaceholderSelector:
+		v, ok := scope.values[p.Name]
+		if !ok {
+			return "", "", ErrSelectorNotFound
+		}
+		return v, "", nil
+	case configapi.PlaceholderSecret:
+		if s.secrets == nil {
+			return "", "", ErrNoSecretResolver
+		}
+		v, generation, err := s.secrets.Get(p.Name, time.Now())
+		return v, strconv.FormatInt(generation, 10), err
+	default:
+		if p.Group == cfg.Group && p.Key == cfg.Key {
+			return "", "", ErrSelfReference
+		}
+		ref, _ := resolveHierarchical(newStoreView(s.stores, scope.tenant), candidates, scope.optSelectorsKey, p.Group, p.Key)
+		if ref == nil {
+			return "", "", ErrReferenceNotFound
+		}
+		// the versions of different selector combinations are not comparable
+		return string(ref.Value), configapi.SelectorsHelperCacheValue(&ref.Selectors) + "|" +
+			configapi.SelectorsHelperCacheValue(&ref.OptionalSelectors) + "|" + ref.Version, nil
+	}
+}
+
+// GetRawConfigurationViaPlainRequest is GetConfigurationViaPlainRequest without rendering the templates, which is
+// used by the tools to export and import the configurations as they are published
+func (s *server) GetRawConfigurationViaPlainRequest(tenant, group, key string, selectors, optSelector string) (configapi.Configuration, error) {
+	store := s.stores.Get(tenant, selectors, optSelector)
+	if store == nil {
+		return configapi.Configuration{}, ErrHasUnknownConfiguration
+	}
+	cfg := store.GetRawConfiguration(group, key)
+	if cfg == nil {
+		return configapi.Configuration{}, ErrHasUnknownConfiguration
+	}
+	return *cfg, nil
+}
+
+// hasTemplates should be called with the lock held
+func (s *selectorsStore) hasTemplates() bool {
+	found := false
+	s.view().forEach(func(_ string, e *storeEntry) {
+		found = found || e.cfg.Template
+	})
+	if found {
+		return true
+	}
+	for _, cfg := range s.schedules {
+		if cfg.Template || (cfg.Fallback != nil && cfg.Fallback.Template) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRendered saves the rendered template and notifies the listeners if the rendition changes, the lock should be
+// held
+func (s *selectorsStore) applyRendered(key string, rendered *configapi.Configuration, round *notifyRound) {
+	cur := s.view().get(key)
+	if cur.rendered != nil && cur.rendered.Version == rendered.Version {
+		return
+	}
+	s.put(key, &storeEntry{cfg: cur.cfg, rendered: rendered})
+	s.notifyListeners(key, rendered, round)
+	round.Changed(s, key, true)
+}
+
+// validateTemplate checks the placeholders of the template while publishing. The referenced configurations are not
+// required to exist, the template is not visible until all of them are resolved.
+func (c *ConfigureServer) validateTemplate(req *configapi.PublishReq) []configapi.FieldError {
+	cfg := &req.Configuration
+	if !cfg.Template {
+		return nil
+	}
+	var errs []configapi.FieldError
+	if strings.Contains(cfg.Version, configapi.RenditionSeparator) {
+		errs = append(errs, configapi.FieldError{Field: "version", Message: ErrUnsupportedRendition.Error()})
+	}
+	placeholders, err := configapi.ParseTemplate(cfg.Value)
+	if err != nil {
+		return append(errs, configapi.FieldError{Field: "value", Message: err.Error()})
+	}
+	for _, p := range placeholders {
+		switch p.Kind {
+		case configapi.PlaceholderSelector:
+			_, ok := req.Selectors.Data[p.Name]
+			if _, optOk := req.OptionalSelectors.Data[p.Name]; !ok && !optOk {
+				errs = append(errs, configapi.FieldError{Field: "value", Message: fmt.Sprintf("%s: %s", p, ErrSelectorNotFound)})
+			}
+		case configapi.PlaceholderSecret:
+			if c.server.secrets == nil {
+				errs = append(errs, configapi.FieldError{Field: "value", Message: fmt.Sprintf("%s: %s", p, ErrNoSecretResolver)})
+			}
+		default:
+			if p.Group == cfg.Group && p.Key == cfg.Key {
+				errs = append(errs, configapi.FieldError{Field: "value", Message: fmt.Sprintf("%s: %s", p, ErrSelfReference)})
+			}
+		}
+	}
+	return errs
+}
+
+// secretCache caches the secrets resolved by the SecretResolver. The secrets are loaded and refreshed in background,
+// so that rendering under the lock of a store never waits for the secret server. The stale secret is kept if the
+// refreshing fails.
+type secretCache struct {
+	resolver configapi.SecretResolver
+	ttl      time.Duration
+	// retry is the interval to retry loading the secret failed to resolve
+	retry time.Duration
+
+	lock    sync.Mutex
+	entries map[string]*secretEntry
+	// changed contains the secrets loaded with changes since the last Refresh
+	changed map[string]struct{}
+}
+
+type secretEntry struct {
+	value string
+	err   error
+	// generation increases when the value or the error changes, which versions the renditions instead of the value
+	generation int64
+	loaded     bool
+	loading    bool
+	expire     time.Time
+}
+
+func newSecretCache(resolver configapi.SecretResolver, ttl time.Duration) *secretCache {
+	return &secretCache{
+		resolver: resolver,
+		ttl:      ttl,
+		retry:    min(ttl, 10*time.Second),
+		entries:  map[string]*secretEntry{},
+		changed:  map[string]struct{}{},
+	}
+}
+
+// Get returns the cached secret and its generation, ErrSecretNotResolved if it is being loaded for the first time
+func (c *secretCache) Get(name string, now time.Time) (string, int64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.entries[name]
+	if !ok {
+		e = &secretEntry{}
+		c.entries[name] = e
+	}
+	c.refresh(name, e, now)
+	if !e.loaded {
+		return "", 0, ErrSecretNotResolved
+	}
+	return e.value, e.generation, e.err
+}
+
+// Refresh starts loading the expired secrets, drops the secrets no longer used, and returns the secrets changed
+// since the last call
+func (c *secretCache) Refresh(now time.Time, used func(name string) bool) []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for name, e := range c.entries {
+		if !used(name) {
+			delete(c.entries, name)
+			continue
+		}
+		c.refresh(name, e, now)
+	}
+	changed := slices.Collect(maps.Keys(c.changed))
+	clear(c.changed)
+	return changed
+}
+
+// refresh should be called with the lock held
+func (c *secretCache) refresh(name string, e *secretEntry, now time.Time) {
+	if !e.loading && !now.Before(e.expire) {
+		e.loading = true
+		go c.load(name, e)
+	}
+}
+
+func (c *secretCache) load(name string, e *secretEntry) {
+	value, err := c.resolver.ResolveSecret(name)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e.loading = false
+	if err != nil {
+		log.Println("[ERROR] resolve secret failed:", name, err)
+		e.expire = time.Now().Add(c.retry)
+		if !e.loaded || e.err != nil {
+			if !e.loaded || e.err.Error() != err.Error() {
+				e.generation++
+				c.changed[name] = struct{}{}
+			}
+			e.err = err
+			e.loaded = true
+		}
+		return
+	}
+	if !e.loaded || e.err != nil || e.value != value {
+		e.generation++
+		c.changed[name] = struct{}{}
+	}
+	e.value, e.err, e.loaded = value, nil, true
+	e.expire = time.Now().Add(c.ttl)
+}
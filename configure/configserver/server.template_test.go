@@ -0,0 +1,233 @@
+package configserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+type staticSecretResolver struct {
+	value atomic.Value
+}
+
+func (s *staticSecretResolver) ResolveSecret(name string) (string, error) {
+	return name + ":" + s.value.Load().(string), nil
+}
+
+func TestServer_RenderTemplates(t *testing.T) {
+	s := newServer(PreparedDataPump{}, DefaultVersionComparator{})
+	sel := configapi.Selectors{Data: map[string]string{"dc": "dc1"}}
+	save := func(group, key, version, value string, template bool) *notifyRound {
+		round := newNotifyRound()
+		s.saveConfiguration(&configapi.Configuration{Group: group, Key: key, Version: version, Value: []byte(value), Selectors: sel, Template: template}, round)
+		s.renderTemplates(round)
+		return round
+	}
+	save("common", "db", "v1", "db1", false)
+	save("app", "url", "v1", "jdbc://${common/db}.${selector.dc}/${app/name}", true)
+	// not visible until the references are resolved
+	if _, err := s.GetConfigurationViaPlainRequest(configapi.DefaultTenant, "app", "url", "dc=dc1", ""); err != ErrHasUnknownConfiguration {
+		t.Fatal("template with unresolved references should not be visible:", err)
+	}
+	// references to another template are rendered in the same round
+	save("app", "name", "v1", "${selector.dc}-orders", true)
+	cfg, err := s.GetConfigurationViaPlainRequest(configapi.DefaultTenant, "app", "url", "dc=dc1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cfg.Value) != "jdbc://db1.dc1/dc1-orders" || !cfg.ValidateSignature() || !strings.HasPrefix(cfg.Version, "v1#") {
+		t.Fatal("unexpected rendered configuration:", string(cfg.Value), cfg.Version)
+	}
+	if raw, err := s.GetRawConfigurationViaPlainRequest(configapi.DefaultTenant, "app", "url", "dc=dc1", ""); err != nil || raw.Version != "v1" {
+		t.Fatal("raw template should be available:", raw.Version, err)
+	}
+
+	// the change of the referenced configuration is notified with a new rendition
+	ch, cancelFn, err := s.RetrieveOrWait(configapi.DefaultTenant, &configapi.AcquireConfigurationReq{
+		Requested: []configapi.RequestedConfigurationKey{{Group: "app", Key: "url", Version: cfg.Version}},
+		Selectors: sel,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancelFn()
+	save("common", "db", "v2", "db2", false).Flush()
+	result := waitNotification(t, ch, time.Second)
+	if len(result) != 1 || string(result[0].Configuration.Value) != "jdbc://db2.dc1/dc1-orders" {
+		t.Fatal("the new rendition should be notified:", result)
+	}
+	if !s.hasUpdate(cfg.Version, result[0].Configuration) || s.hasUpdate(result[0].Configuration.Version, result[0].Configuration) {
+		t.Fatal("renditions of the same template version should be compared by the digests")
+	}
+
+	// the previous rendition is kept if the reference is deleted
+	round := newNotifyRound()
+	s.getOrCreateStore(&configapi.Configuration{Selectors: sel}).DeleteConfiguration(&configapi.Configuration{Group: "common", Key: "db"}, round)
+	s.renderTemplates(round)
+	if cfg, err := s.GetConfigurationViaPlainRequest(configapi.DefaultTenant, "app", "url", "dc=dc1", ""); err != nil || string(cfg.Value) != "jdbc://db2.dc1/dc1-orders" {
+		t.Fatal("previous rendition should be kept:", err)
+	}
+	if len(s.renderErrors) != 1 {
+		t.Fatal("the rendering error should be recorded:", s.renderErrors)
+	}
+}
+
+func TestServer_RenderTemplates_Secret(t *testing.T) {
+	resolver := &staticSecretResolver{}
+	resolver.value.Store("s1")
+	s := newServer(PreparedDataPump{}, DefaultVersionComparator{})
+	s.secrets = newSecretCache(resolver, 50*time.Millisecond)
+	sel := configapi.Selectors{Data: map[string]string{"dc": "dc1"}}
+	round := newNotifyRound()
+	s.saveConfiguration(&configapi.Configuration{Group: "app", Key: "pass", Version: "v1", Value: []byte("${secret:db}"), Selectors: sel, Template: true}, round)
+	s.renderTemplates(round)
+
+	// the templates are rendered again once the secrets are loaded
+	render := func() string {
+		s.renderTemplates(newNotifyRound())
+		cfg, err := s.GetConfigurationViaPlainRequest(configapi.DefaultTenant, "app", "pass", "dc=dc1", "")
+		if err != nil {
+			return ""
+		}
+		return string(cfg.Value)
+	}
+	// secrets are loaded in background
+	deadline := time.Now().Add(time.Second)
+	for render() != "db:s1" {
+		if time.Now().After(deadline) {
+			t.Fatal("secret should be rendered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the version of the rendition does not derive from the secret
+	cfg, _ := s.GetConfigurationViaPlainRequest(configapi.DefaultTenant, "app", "pass", "dc=dc1", "")
+	if cfg.Version != configapi.RenderedVersion("v1", []string{"${secret:db}=1"}) {
+		t.Fatal("unexpected version:", cfg.Version)
+	}
+	// rotated secret is rendered after refreshed
+	resolver.value.Store("s2")
+	deadline = time.Now().Add(time.Second)
+	for render() != "db:s2" {
+		if time.Now().After(deadline) {
+			t.Fatal("rotated secret should be rendered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServer_RenderTemplates_Dependencies(t *testing.T) {
+	s := newServer(PreparedDataPump{}, DefaultVersionComparator{})
+	s.selectorHierarchy = []string{"dc", "app"}
+	dcSel := configapi.Selectors{Data: map[string]string{"dc": "dc1"}}
+	appSel := configapi.Selectors{Data: map[string]string{"dc": "dc1", "app": "x"}}
+	save := func(group, key, version, value string, sel configapi.Selectors, template bool) {
+		round := newNotifyRound()
+		s.saveConfiguration(&configapi.Configuration{Group: group, Key: key, Version: version, Value: []byte(value), Selectors: sel, Template: template}, round)
+		s.renderTemplates(round)
+		round.Flush()
+	}
+	get := func() *configapi.Configuration {
+		// the rendition as stored, which is replaced once rendered again
+		cfg := s.stores.Get(configapi.DefaultTenant, "app=x,dc=dc1", "").GetConfiguration("app", "url")
+		if cfg == nil {
+			t.Fatal("template should be rendered")
+		}
+		return cfg
+	}
+	save("common", "db", "v1", "db1", dcSel, false)
+	save("app", "url", "v1", "jdbc://${common/db}", appSel, true)
+	rendition := get()
+
+	// only the changes of the referenced configurations render the template again
+	round := newNotifyRound()
+	s.saveConfiguration(&configapi.Configuration{Group: "common", Key: "other", Version: "v1", Selectors: dcSel}, round)
+	s.saveConfiguration(&configapi.Configuration{Group: "common", Key: "db", Version: "v1", Selectors: configapi.Selectors{Data: map[string]string{"dc": "dc2"}}}, round)
+	if affected := s.affectedTemplates(round.takeChanges(), nil); len(affected) != 0 {
+		t.Fatal("unrelated changes should not render the template again:", affected)
+	}
+	s.applySchedules(time.Now().Unix())
+	if cfg := get(); cfg != rendition {
+		t.Fatal("the rendition should not change")
+	}
+
+	// the reference of a more specific selector combination which does not exist yet is tracked
+	save("common", "db", "v1", "db-x", appSel, false)
+	cfg := get()
+	if string(cfg.Value) != "jdbc://db-x" || cfg.Version == rendition.Version {
+		t.Fatal("the more specific reference should be rendered:", string(cfg.Value), cfg.Version)
+	}
+
+	// the index is cleaned up once the template is deleted
+	round = newNotifyRound()
+	s.getOrCreateStore(&configapi.Configuration{Selectors: appSel}).DeleteConfiguration(&configapi.Configuration{Group: "app", Key: "url"}, round)
+	s.renderTemplates(round)
+	if len(s.dependents) != 0 || len(s.dependencies) != 0 || len(s.templateStores) != 0 {
+		t.Fatal("the template should be untracked:", s.dependents, s.templateStores)
+	}
+}
+
+func TestConfigureServer_PublishTemplate(t *testing.T) {
+	opt := ConfigureOptions{}
+	opt.WriteApi.DataWriter = &recordDataWriter{}
+	s := NewConfigureServer(opt)
+
+	publish := func(version, value string) int {
+		data, err := cbor.Marshal(&configapi.PublishReq{
+			Configuration: configapi.RawConfiguration{Group: "app", Key: "url", Version: version, Value: []byte(value), Template: true},
+			Selectors:     configapi.Selectors{Data: map[string]string{"dc": "dc1"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/configure", bytes.NewReader(data))
+		req.Header.Set("Accept", "application/cbor")
+		req.Header.Set("Content-Type", "application/cbor")
+		w := httptest.NewRecorder()
+		s.writeServer.writeMux.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := publish("v1", "jdbc://${common/db}.${selector.dc}"); code != http.StatusOK {
+		t.Fatal("valid template should be published:", code)
+	}
+	get := func(mux http.Handler, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", "application/cbor")
+		req.Header.Set("X-Configuration-Sel", "dc=dc1")
+		req.Header.Set("X-Configuration-Raw", "true")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	// the template as published is only served by the admin api
+	round := newNotifyRound()
+	s.server.saveConfiguration(&configapi.Configuration{Group: "app", Key: "url", Version: "v1", Value: []byte("jdbc://${common/db}"),
+		Selectors: configapi.Selectors{Data: map[string]string{"dc": "dc1"}}, Template: true}, round)
+	s.server.renderTemplates(round)
+	if w := get(s.readMux, "/configure/app/url"); w.Code != http.StatusNotFound {
+		t.Fatal("unrendered template should not be visible to the read api:", w.Code)
+	}
+	w := get(s.writeServer.writeMux, "/admin/groups/app/keys/url")
+	res := new(configapi.GetConfigurationRes)
+	if w.Code != http.StatusOK || cbor.Unmarshal(w.Body.Bytes(), res) != nil || res.Configuration.Version != "v1" || !res.Configuration.Template {
+		t.Fatal("template should be served by the admin api:", w.Code, res)
+	}
+	for _, c := range []struct{ version, value string }{
+		{"v2", "${selector.zone}"},
+		{"v2", "${secret:db}"},
+		{"v2", "${app/url}"},
+		{"v2", "${unclosed"},
+		{"v2#1", "plain"},
+	} {
+		if code := publish(c.version, c.value); code != http.StatusBadRequest {
+			t.Fatal("invalid template should be rejected:", c, code)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package configserver
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+type tenantContextKey struct{}
+
+// tenantCredential is the tenant and the permission of a token
+type tenantCredential struct {
+	Tenant string
+	Write  bool
+}
+
+// tenantRegistry holds the declared tenants. All requests belong to DefaultTenant if no tenant is declared.
+type tenantRegistry struct {
+	tokens map[string]tenantCredential
+	quotas map[string]configapi.TenantQuota
+}
+
+func newTenantRegistry(tenants []configapi.Tenant) (tenantRegistry, error) {
+	if err := configapi.ValidateTenants(tenants); err != nil {
+		return tenantRegistry{}, err
+	}
+	r := tenantRegistry{
+		tokens: map[string]tenantCredential{},
+		quotas: map[string]configapi.TenantQuota{},
+	}
+	for _, t := range tenants {
+		for _, token := range t.ReadTokens {
+			r.tokens[token] = tenantCredential{Tenant: t.Name}
+		}
+		for _, token := range t.WriteTokens {
+			r.tokens[token] = tenantCredential{Tenant: t.Name, Write: true}
+		}
+		r.quotas[t.Name] = t.Quota
+	}
+	return r, nil
+}
+
+func (t tenantRegistry) Enabled() bool {
+	return len(t.quotas) > 0
+}
+
+func (t tenantRegistry) Quota(tenant string) configapi.TenantQuota {
+	return t.quotas[tenant]
+}
+
+// authenticate resolves the tenant of the request by the bearer token in the Authorization header.
+// It responds 401 if the token is missing or unknown, and 403 if a read token is used for the write api.
+func (c *ConfigureServer) authenticate(write bool) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !c.tenants.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			credential, ok := c.tenants.tokens[strings.TrimSpace(token)]
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if write && !credential.Write {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, credential.Tenant)))
+		})
+	}
+}
+
+// tenantOf returns the tenant resolved by authenticate, DefaultTenant if no tenant is declared
+func tenantOf(r *http.Request) string {
+	if v, ok := r.Context().Value(tenantContextKey{}).(string); ok {
+		return v
+	}
+	return configapi.DefaultTenant
+}
+
+// maxValueSize returns the max value size of the tenant bounded by the server wide limit
+func (c *ConfigureServer) maxValueSize(tenant string) int {
+	maxValueSize := c.opt.WriteApi.MaxValueSize
+	if maxValueSize <= 0 {
+		maxValueSize = defaultMaxValueSize
+	}
+	if quota := c.tenants.Quota(tenant).MaxValueSize; quota > 0 {
+		maxValueSize = min(maxValueSize, quota)
+	}
+	return maxValueSize
+}
+
+// checkConfigurationQuota checks the configuration count of the tenant after saving the configurations.
+// The count is from the in-memory data, so the quota may be exceeded slightly by concurrent publishing.
+func (c *ConfigureServer) checkConfigurationQuota(tenant string, cfgs []configapi.Configuration) bool {
+	maxCfgs := c.tenants.Quota(tenant).MaxConfigurations
+	if maxCfgs <= 0 {
+		return true
+	}
+	count, newCount := c.server.CountConfigurations(tenant, cfgs)
+	return newCount == 0 || count+newCount <= maxCfgs
+}
+
+// listenerCounter counts the concurrent long polling requests per tenant
+type listenerCounter struct {
+	lock   sync.Mutex
+	counts map[string]int
+}
+
+// Acquire returns false if the count of the tenant reaches max, 0 = unlimited
+func (l *listenerCounter) Acquire(tenant string, max int) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if max > 0 && l.counts[tenant] >= max {
+		return false
+	}
+	if l.counts == nil {
+		l.counts = map[string]int{}
+	}
+	l.counts[tenant]++
+	return true
+}
+
+func (l *listenerCounter) Release(tenant string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.counts[tenant]--; l.counts[tenant] <= 0 {
+		delete(l.counts, tenant)
+	}
+}
+
+// CountConfigurations returns the configuration count of the tenant and how many of cfgs are new
+func (s *server) CountConfigurations(tenant string, cfgs []configapi.Configuration) (count, newCount int) {
+	s.stores.ForEach(tenant, func(selectorsKey, optSelectorsKey string, store *selectorsStore) {
+		count += store.ConfigurationCount()
+	})
+	for i := range cfgs {
+		cfg := &cfgs[i]
+		store := s.stores.GetExact(tenant, configapi.SelectorsHelperCacheValue(&cfg.Selectors), configapi.SelectorsHelperCacheValue(&cfg.OptionalSelectors))
+		if store == nil || !store.HasConfiguration(cfg.Group, cfg.Key) {
+			newCount++
+		}
+	}
+	return count, newCount
+}
+
+// ConfigurationCount returns the count of the configurations including the ones not visible yet
+func (s *selectorsStore) ConfigurationCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	snapshot := s.snapshot.Load()
+	count := snapshot.count
+	for key := range s.schedules {
+		if snapshot.get(key) == nil {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *selectorsStore) HasConfiguration(group, key string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cfgKey := s.cfgKey(group, key)
+	if s.snapshot.Load().get(cfgKey) != nil {
+		return true
+	}
+	_, ok := s.schedules[cfgKey]
+	return ok
+}
@@ -0,0 +1,184 @@
+package configserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+func newTenantTestServer(t *testing.T) (*ConfigureServer, *channelDataPump, *recordDataWriter) {
+	pump := &channelDataPump{ch: make(chan configapi.Event)}
+	dw := &recordDataWriter{}
+	opt := ConfigureOptions{
+		DataPump: pump,
+		Tenants: []configapi.Tenant{
+			{Name: "bu1", ReadTokens: []string{"bu1-read"}, WriteTokens: []string{"bu1-write"},
+				Quota: configapi.TenantQuota{MaxConfigurations: 2, MaxValueSize: 8, MaxListeners: 1}},
+			{Name: "bu2", ReadTokens: []string{"bu2-read"}},
+		},
+	}
+	opt.WriteApi.DataWriter = dw
+	s := NewConfigureServer(opt)
+	if err := s.server.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	return s, pump, dw
+}
+
+func TestConfigureServer_TenantAuthentication(t *testing.T) {
+	s, _, _ := newTenantTestServer(t)
+	defer s.server.Shutdown()
+
+	check := func(mux http.Handler, method, path, token string, expected int) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Accept", "application/cbor")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != expected {
+			t.Fatal("unexpected status code:", method, path, token, w.Code)
+		}
+	}
+	check(s.readMux, http.MethodPost, "/retrieving", "", http.StatusUnauthorized)
+	check(s.readMux, http.MethodPost, "/retrieving", "unknown", http.StatusUnauthorized)
+	check(s.writeServer.writeMux, http.MethodGet, "/admin/stats", "bu1-read", http.StatusForbidden)
+	check(s.writeServer.writeMux, http.MethodGet, "/admin/stats", "bu1-write", http.StatusOK)
+	// readiness is not protected
+	check(s.readMux, http.MethodGet, "/ready", "", http.StatusOK)
+}
+
+func TestConfigureServer_TenantIsolation(t *testing.T) {
+	s, pump, _ := newTenantTestServer(t)
+	defer s.server.Shutdown()
+
+	sel := configapi.Selectors{Data: map[string]string{"dc": "dc1"}}
+	pump.ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Tenant: "bu1", Group: "g", Key: "k", Version: "v1", Selectors: sel}}
+	time.Sleep(800 * time.Millisecond)
+
+	get := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/configure/g/k", nil)
+		req.Header.Set("Accept", "application/cbor")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-Configuration-Sel", "dc=dc1")
+		w := httptest.NewRecorder()
+		s.readMux.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := get("bu1-read"); code != http.StatusOK {
+		t.Fatal("configuration should be visible to its tenant:", code)
+	}
+	if code := get("bu2-read"); code != http.StatusNotFound {
+		t.Fatal("configuration should not be visible to other tenants:", code)
+	}
+	if _, cfgCount, _ := s.server.Stats("bu2"); cfgCount != 0 {
+		t.Fatal("no configuration expected for the other tenant:", cfgCount)
+	}
+}
+
+func TestConfigureServer_TenantQuota(t *testing.T) {
+	s, pump, dw := newTenantTestServer(t)
+	defer s.server.Shutdown()
+
+	sel := configapi.Selectors{Data: map[string]string{"dc": "dc1"}}
+	pump.ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Tenant: "bu1", Group: "g", Key: "k1", Version: "v1", Selectors: sel}}
+	pump.ch <- configapi.Event{Created: true, Configuration: &configapi.Configuration{Tenant: "bu1", Group: "g", Key: "k2", Version: "v1", Selectors: sel}}
+	time.Sleep(800 * time.Millisecond)
+
+	publish := func(key, value string) int {
+		data, err := cbor.Marshal(&configapi.PublishReq{
+			Configuration: configapi.RawConfiguration{Group: "g", Key: key, Version: "v2", Value: []byte(value)},
+			Selectors:     sel,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/configure", bytes.NewReader(data))
+		req.Header.Set("Accept", "application/cbor")
+		req.Header.Set("Content-Type", "application/cbor")
+		req.Header.Set("Authorization", "Bearer bu1-write")
+		w := httptest.NewRecorder()
+		s.writeServer.writeMux.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := publish("k1", "value"); code != http.StatusOK {
+		t.Fatal("updating existing configuration should be allowed:", code)
+	}
+	if dw.saved[0].Tenant != "bu1" {
+		t.Fatal("configuration should be saved with the tenant:", dw.saved[0].Tenant)
+	}
+	if code := publish("k3", "value"); code != http.StatusForbidden {
+		t.Fatal("configuration count quota should be enforced:", code)
+	}
+	if code := publish("k2", "value too large"); code != http.StatusBadRequest {
+		t.Fatal("value size quota should be enforced:", code)
+	}
+
+	// listener quota
+	data, err := cbor.Marshal(&configapi.AcquireConfigurationReq{
+		Requested: []configapi.RequestedConfigurationKey{{Group: "g", Key: "k1", Version: "v1"}},
+		Selectors: sel,
+		WaitTime:  5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.listeners.Acquire("bu1", 1) {
+		t.Fatal("first listener should be allowed")
+	}
+	req := httptest.NewRequest(http.MethodPost, "/retrieving", bytes.NewReader(data))
+	req.Header.Set("Accept", "application/cbor")
+	req.Header.Set("Authorization", "Bearer bu1-read")
+	w := httptest.NewRecorder()
+	s.readMux.ServeHTTP(w, req)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatal("listener quota should be enforced:", w.Code)
+	}
+	s.listeners.Release("bu1")
+}
+
+func TestConfigureServer_TenantValueSchema(t *testing.T) {
+	opt := ConfigureOptions{
+		Tenants: []configapi.Tenant{
+			{Name: "bu1", WriteTokens: []string{"bu1-write"}},
+			{Name: "bu2", WriteTokens: []string{"bu2-write"}},
+		},
+	}
+	opt.WriteApi.DataWriter = &recordDataWriter{}
+	opt.WriteApi.ValueSchemas = []configapi.ValueSchema{{Tenant: "bu1", Group: "g", Key: "k", Schema: []byte(`{"type":"object"}`)}}
+	s := NewConfigureServer(opt)
+	if err := s.RegisterValueSchema("bu3", "g", "k", `{"type":"object"}`); err == nil {
+		t.Fatal("schema of unknown tenant should be rejected")
+	}
+
+	publish := func(token string) int {
+		data, err := cbor.Marshal(&configapi.PublishReq{
+			Configuration: configapi.RawConfiguration{Group: "g", Key: "k", Version: "v1", Value: []byte("not json")},
+			Selectors:     configapi.Selectors{Data: map[string]string{"dc": "dc1"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/configure", bytes.NewReader(data))
+		req.Header.Set("Accept", "application/cbor")
+		req.Header.Set("Content-Type", "application/cbor")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.writeServer.writeMux.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := publish("bu1-write"); code != http.StatusBadRequest {
+		t.Fatal("schema should be checked for its tenant:", code)
+	}
+	if code := publish("bu2-write"); code != http.StatusOK {
+		t.Fatal("schema should not apply to other tenants:", code)
+	}
+}
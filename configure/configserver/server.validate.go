@@ -0,0 +1,160 @@
+package configserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+const (
+	defaultMaxValueSize = 1024 * 1024
+)
+
+// valueSchemaRegistry holds the JSON schemas of configuration values per [tenant, group, key]
+type valueSchemaRegistry struct {
+	lock    sync.RWMutex
+	schemas map[string]*jsonschema.Schema
+}
+
+func (v *valueSchemaRegistry) cfgKey(tenant, group, key string) string {
+	return tenant + "||" + group + "||" + key
+}
+
+func (v *valueSchemaRegistry) Register(tenant, group, key string, schema string) error {
+	url := "nekoq://schema/" + tenant + "/" + group + "/" + key + ".json"
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(url, strings.NewReader(schema)); err != nil {
+		return err
+	}
+	s, err := compiler.Compile(url)
+	if err != nil {
+		return err
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.schemas == nil {
+		v.schemas = map[string]*jsonschema.Schema{}
+	}
+	v.schemas[v.cfgKey(tenant, group, key)] = s
+	return nil
+}
+
+func (v *valueSchemaRegistry) Unregister(tenant, group, key string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	delete(v.schemas, v.cfgKey(tenant, group, key))
+}
+
+func (v *valueSchemaRegistry) Get(tenant, group, key string) *jsonschema.Schema {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	return v.schemas[v.cfgKey(tenant, group, key)]
+}
+
+// RegisterValueSchema registers the JSON schema that the value of the configuration [group, key] of the tenant must
+// satisfy while publishing. The tenant is configapi.DefaultTenant if no tenant is declared.
+func (c *ConfigureServer) RegisterValueSchema(tenant, group, key string, schema string) error {
+	if c.tenants.Enabled() {
+		if _, ok := c.tenants.quotas[tenant]; !ok {
+			return fmt.Errorf("schema of unknown tenant: %s", tenant)
+		}
+	}
+	return c.valueSchemas.Register(tenant, group, key, schema)
+}
+
+func (c *ConfigureServer) UnregisterValueSchema(tenant, group, key string) {
+	c.valueSchemas.Unregister(tenant, group, key)
+}
+
+func (c *ConfigureServer) validatePublishReq(tenant string, req *configapi.PublishReq) []configapi.FieldError {
+	cfg := &req.Configuration
+	errs := configapi.ValidateIdentification(cfg.Group, cfg.Key, cfg.Version, &req.Selectors, &req.OptionalSelectors)
+
+	maxValueSize := c.maxValueSize(tenant)
+	if len(cfg.Value) > maxValueSize {
+		errs = append(errs, configapi.FieldError{Field: "value", Message: fmt.Sprintf("exceeds max size %d", maxValueSize)})
+		return errs
+	}
+
+	errs = append(errs, c.validateTemplate(req)...)
+
+	// the value of the template is only known after rendered, so it is not validated against the schema
+	if schema := c.valueSchemas.Get(tenant, cfg.Group, cfg.Key); schema != nil && !cfg.Template {
+		decoder := json.NewDecoder(bytes.NewReader(cfg.Value))
+		decoder.UseNumber()
+		var v any
+		if err := decoder.Decode(&v); err != nil {
+			errs = append(errs, configapi.FieldError{Field: "value", Message: "invalid json: " + err.Error()})
+		} else if decoder.More() {
+			errs = append(errs, configapi.FieldError{Field: "value", Message: "invalid json: unexpected data after top-level value"})
+		} else if err := schema.Validate(v); err != nil {
+			errs = append(errs, schemaFieldErrors(err)...)
+		}
+	}
+	return errs
+}
+
+// validateVersion checks the format of the version if the comparator is a VersionValidator.
+func validateVersion(cfg *configapi.Configuration, versionValidator configapi.VersionValidator, field string) []configapi.FieldError {
+	if versionValidator == nil {
+		return nil
+	}
+	if err := versionValidator.ValidateVersion(cfg.Version); err != nil {
+		return []configapi.FieldError{{Field: field, Message: err.Error()}}
+	}
+	return nil
+}
+
+// validateSchedule checks the effective time and the expiry of the configuration converted from the request.
+// ExpiryVersion is only checked if the comparator is a VersionValidator.
+func validateSchedule(req *configapi.PublishReq, cfg *configapi.Configuration, versionValidator configapi.VersionValidator) []configapi.FieldError {
+	var errs []configapi.FieldError
+	if req.EffectiveTime < 0 {
+		errs = append(errs, configapi.FieldError{Field: "effective_time", Message: "should not be negative"})
+	}
+	if req.ExpiryTime == 0 {
+		if req.ExpiryVersion != "" {
+			errs = append(errs, configapi.FieldError{Field: "expiry_version", Message: "expiry_time is required"})
+		}
+		return errs
+	}
+	if req.ExpiryTime <= cfg.Timestamp {
+		return append(errs, configapi.FieldError{Field: "expiry_time", Message: "should be later than the effective time"})
+	}
+	if versionValidator == nil {
+		return errs
+	}
+	if err := versionValidator.ValidateVersion(cfg.ExpiryVersion); err != nil {
+		errs = append(errs, configapi.FieldError{Field: "expiry_version", Message: err.Error()})
+	} else if versionValidator.Compare(cfg.ExpiryVersion, cfg.Version) <= 0 {
+		errs = append(errs, configapi.FieldError{Field: "expiry_version", Message: "should be greater than version"})
+	}
+	return errs
+}
+
+func schemaFieldErrors(err error) []configapi.FieldError {
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return []configapi.FieldError{{Field: "value", Message: err.Error()}}
+	}
+	// collect the leaf errors which are the actual causes
+	var errs []configapi.FieldError
+	var collect func(e *jsonschema.ValidationError)
+	collect = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			errs = append(errs, configapi.FieldError{Field: "value" + e.InstanceLocation, Message: e.Message})
+			return
+		}
+		for _, cause := range e.Causes {
+			collect(cause)
+		}
+	}
+	collect(ve)
+	return errs
+}
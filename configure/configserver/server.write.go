@@ -22,10 +22,18 @@ func (w *writeServer) Stop() error {
 	return nil
 }
 
-func (w *writeServer) SaveConfiguration(cfg *configapi.Configuration) error {
-	return w.DataWriter.SaveConfiguration(*cfg)
+func (w *writeServer) SaveConfiguration(cfg *configapi.Configuration, opt configapi.SaveOptions) error {
+	return w.DataWriter.SaveConfiguration(*cfg, opt)
 }
 
-func (w *writeServer) DeleteConfiguration(group, key, sel, optSel string) (bool, error) {
-	return w.DataWriter.DeleteConfiguration(group, key, sel, optSel)
+func (w *writeServer) SaveConfigurations(cfgs []configapi.Configuration, opt configapi.SaveOptions) error {
+	return w.DataWriter.SaveConfigurations(cfgs, opt)
+}
+
+func (w *writeServer) DeleteConfiguration(tenant, group, key, sel, optSel string, audit configapi.AuditInfo) (bool, error) {
+	return w.DataWriter.DeleteConfiguration(tenant, group, key, sel, optSel, audit)
+}
+
+func (w *writeServer) QueryAuditRecords(query configapi.AuditQuery) ([]configapi.AuditRecord, error) {
+	return w.DataWriter.QueryAuditRecords(query)
 }
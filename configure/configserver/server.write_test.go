@@ -0,0 +1,288 @@
+package configserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+type recordDataWriter struct {
+	saved   []configapi.Configuration
+	records []configapi.AuditRecord
+}
+
+func (r *recordDataWriter) Startup() error {
+	return nil
+}
+
+func (r *recordDataWriter) Stop() error {
+	return nil
+}
+
+func (r *recordDataWriter) SaveConfiguration(cfg configapi.Configuration, opt configapi.SaveOptions) error {
+	if err := r.checkVersion(&cfg, opt); err != nil {
+		return err
+	}
+	audit := opt.Audit
+	r.saved = append(r.saved, cfg)
+	r.records = append(r.records, configapi.AuditRecord{
+		Id:          int64(len(r.records) + 1),
+		Operation:   configapi.AuditOperationPublish,
+		Group:       cfg.Group,
+		Key:         cfg.Key,
+		Operator:    audit.Operator,
+		SourceIP:    audit.SourceIP,
+		RequestId:   audit.RequestId,
+		NewVersion:  cfg.Version,
+		Description: audit.Description,
+	})
+	return nil
+}
+
+// checkVersion simulates the version check of the database against the latest saved configuration of the same key
+func (r *recordDataWriter) checkVersion(cfg *configapi.Configuration, opt configapi.SaveOptions) error {
+	var existing *configapi.Configuration
+	for i := range r.saved {
+		if v := &r.saved[i]; v.Group == cfg.Group && v.Key == cfg.Key {
+			existing = v
+		}
+	}
+	if existing == nil || opt.VersionValidator == nil {
+		return nil
+	}
+	if generator, ok := opt.VersionValidator.(configapi.VersionGenerator); ok {
+		configapi.GenerateVersions(generator, cfg, existing.LatestVersion())
+	}
+	if opt.VersionValidator.Compare(cfg.Version, existing.LatestVersion()) <= 0 {
+		return configapi.ErrVersionNotIncreased
+	}
+	return nil
+}
+
+func (r *recordDataWriter) SaveConfigurations(cfgs []configapi.Configuration, opt configapi.SaveOptions) error {
+	// check all before saving to simulate the transaction
+	for _, cfg := range cfgs {
+		if err := r.checkVersion(&cfg, opt); err != nil {
+			return err
+		}
+	}
+	for _, cfg := range cfgs {
+		if err := r.SaveConfiguration(cfg, opt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *recordDataWriter) DeleteConfiguration(tenant, group, key, sel, optSel string, audit configapi.AuditInfo) (bool, error) {
+	return false, nil
+}
+
+func (r *recordDataWriter) QueryAuditRecords(query configapi.AuditQuery) ([]configapi.AuditRecord, error) {
+	var result []configapi.AuditRecord
+	for _, v := range r.records {
+		if query.Group != "" && v.Group != query.Group {
+			continue
+		}
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+func TestConfigureServer_PublishWithAudit(t *testing.T) {
+	dw := &recordDataWriter{}
+	opt := ConfigureOptions{}
+	opt.WriteApi.DataWriter = dw
+	s := NewConfigureServer(opt)
+
+	data, err := cbor.Marshal(&configapi.PublishReq{
+		Configuration: configapi.RawConfiguration{
+			Group:   "group1",
+			Key:     "key1",
+			Version: "v1",
+			Value:   []byte("value1"),
+		},
+		Selectors:   configapi.Selectors{Data: map[string]string{"dc": "dc1"}},
+		Operator:    "admin",
+		Description: "initial",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/configure", bytes.NewReader(data))
+	req.Header.Set("Accept", "application/cbor")
+	req.Header.Set("Content-Type", "application/cbor")
+	req.Header.Set("X-Real-IP", "10.0.0.1")
+	req.Header.Set("X-Request-Id", "req-1")
+	w := httptest.NewRecorder()
+	s.writeServer.writeMux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal("unexpected status code:", w.Code)
+	}
+	if len(dw.saved) != 1 || !dw.saved[0].ValidateSignature() {
+		t.Fatal("configuration should be saved with signature")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/audit?group=group1&limit=10", nil)
+	req.Header.Set("Accept", "application/cbor")
+	w = httptest.NewRecorder()
+	s.writeServer.writeMux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal("unexpected status code:", w.Code)
+	}
+	res := new(configapi.AuditQueryRes)
+	if err := cbor.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Records) != 1 {
+		t.Fatal("1 audit record expected")
+	}
+	r := res.Records[0]
+	if r.Operator != "admin" || r.SourceIP != "10.0.0.1" || r.RequestId != "req-1" || r.NewVersion != "v1" || r.Description != "initial" {
+		t.Fatal("unexpected audit record:", r)
+	}
+}
+
+func TestConfigureServer_AuditSourceIP(t *testing.T) {
+	c := &ConfigureServer{}
+	for remoteAddr, expected := range map[string]string{
+		"192.0.2.1:1234":    "192.0.2.1",
+		"[2001:db8::1]:443": "2001:db8::1",
+		"10.0.0.1":          "10.0.0.1",
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/configure", nil)
+		req.RemoteAddr = remoteAddr
+		if ip := c.auditInfo(req, "", "").SourceIP; ip != expected {
+			t.Fatal("unexpected source ip:", remoteAddr, ip)
+		}
+	}
+}
+
+func TestConfigureServer_PublishVersionNotIncreased(t *testing.T) {
+	dw := &recordDataWriter{}
+	opt := ConfigureOptions{VersionComparatorName: VersionComparatorSemver}
+	opt.WriteApi.DataWriter = dw
+	s := NewConfigureServer(opt)
+
+	publish := func(version string) int {
+		data, err := cbor.Marshal(&configapi.PublishReq{
+			Configuration: configapi.RawConfiguration{
+				Group:   "group1",
+				Key:     "key1",
+				Version: version,
+				Value:   []byte("value-" + version),
+			},
+			Selectors: configapi.Selectors{Data: map[string]string{"dc": "dc1"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/configure", bytes.NewReader(data))
+		req.Header.Set("Accept", "application/cbor")
+		req.Header.Set("Content-Type", "application/cbor")
+		w := httptest.NewRecorder()
+		s.writeServer.writeMux.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := publish("v9.0.0"); code != http.StatusOK {
+		t.Fatal("unexpected status code:", code)
+	}
+	if code := publish("v10.0.0"); code != http.StatusOK {
+		t.Fatal("unexpected status code:", code)
+	}
+	if code := publish("v10.0.0"); code != http.StatusConflict {
+		t.Fatal("unexpected status code:", code)
+	}
+	if code := publish("latest"); code != http.StatusBadRequest {
+		t.Fatal("unexpected status code:", code)
+	}
+}
+
+func TestConfigureServer_PublishValidation(t *testing.T) {
+	dw := &recordDataWriter{}
+	opt := ConfigureOptions{}
+	opt.WriteApi.DataWriter = dw
+	opt.WriteApi.MaxValueSize = 64
+	s := NewConfigureServer(opt)
+	if err := s.RegisterValueSchema(configapi.DefaultTenant, "group1", "key1", `{"type":"object","properties":{"port":{"type":"integer","minimum":1}},"required":["port"]}`); err != nil {
+		t.Fatal(err)
+	}
+
+	publish := func(group, key string, value string, selectors map[string]string) (int, *configapi.PublishRes) {
+		data, err := cbor.Marshal(&configapi.PublishReq{
+			Configuration: configapi.RawConfiguration{
+				Group:   group,
+				Key:     key,
+				Version: "v1",
+				Value:   []byte(value),
+			},
+			Selectors: configapi.Selectors{Data: selectors},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/configure", bytes.NewReader(data))
+		req.Header.Set("Accept", "application/cbor")
+		req.Header.Set("Content-Type", "application/cbor")
+		w := httptest.NewRecorder()
+		s.writeServer.writeMux.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			return w.Code, nil
+		}
+		res := new(configapi.PublishRes)
+		if err := cbor.Unmarshal(w.Body.Bytes(), res); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, res
+	}
+
+	sel := map[string]string{"dc": "dc1"}
+	if code, _ := publish("group1", "key1", `{"port":8080}`, sel); code != http.StatusOK {
+		t.Fatal("unexpected status code:", code)
+	}
+	if code, _ := publish("group2", "key2", `not json`, sel); code != http.StatusOK {
+		t.Fatal("value without schema should not be validated, status code:", code)
+	}
+	if _, res := publish("group 1", "key1", `{"port":8080}`, map[string]string{"dc": "dc/1"}); res == nil || len(res.Errors) != 2 {
+		t.Fatal("invalid group and selectors expected:", res)
+	}
+	if _, res := publish("group1", "key1", `{"port":0}`, sel); res == nil || len(res.Errors) != 1 || res.Errors[0].Field != "value/port" {
+		t.Fatal("schema validation failure expected:", res)
+	}
+	if _, res := publish("group1", "key1", `{"port":`, sel); res == nil || len(res.Errors) != 1 || res.Errors[0].Field != "value" {
+		t.Fatal("invalid json expected:", res)
+	}
+	if _, res := publish("group2", "key2", strings.Repeat("x", 65), sel); res == nil || len(res.Errors) != 1 || res.Errors[0].Field != "value" {
+		t.Fatal("value size exceeded expected:", res)
+	}
+}
+
+func TestConfigureServer_PublishInvalidBody(t *testing.T) {
+	opt := ConfigureOptions{}
+	opt.WriteApi.DataWriter = &recordDataWriter{}
+	s := NewConfigureServer(opt)
+
+	for _, path := range []string{"/configure", "/configure/batch"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("not cbor"))
+		req.Header.Set("Accept", "application/cbor")
+		req.Header.Set("Content-Type", "application/cbor")
+		w := httptest.NewRecorder()
+		s.writeServer.writeMux.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatal("unexpected status code:", path, w.Code)
+		}
+		res := new(configapi.PublishRes)
+		if err := cbor.Unmarshal(w.Body.Bytes(), res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Success || len(res.Errors) != 1 || res.Errors[0].Field != "body" || res.Errors[0].Message == "" {
+			t.Fatal("decode error expected:", path, res)
+		}
+	}
+}
@@ -1,10 +1,299 @@
 package configserver
 
-import "strings"
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+const (
+	VersionComparatorString    = "string"
+	VersionComparatorSemver    = "semver"
+	VersionComparatorNumeric   = "numeric"
+	VersionComparatorTimestamp = "timestamp"
+)
+
+// NewVersionComparator creates the built-in VersionComparator by name. Empty name means the default one.
+func NewVersionComparator(name string) (configapi.VersionComparator, error) {
+	switch name {
+	case "", VersionComparatorString:
+		return DefaultVersionComparator{}, nil
+	case VersionComparatorSemver:
+		return SemverVersionComparator{}, nil
+	case VersionComparatorNumeric:
+		return NumericVersionComparator{}, nil
+	case VersionComparatorTimestamp:
+		return TimestampVersionComparator{}, nil
+	default:
+		return nil, errors.New("unknown version comparator:" + name)
+	}
+}
+
+// DefaultVersionComparator compares versions literally via strings.Compare
 type DefaultVersionComparator struct {
 }
 
 func (d DefaultVersionComparator) HasUpdate(requestVersion, dataVersion string) bool {
 	return strings.Compare(requestVersion, dataVersion) < 0
 }
+
+func (d DefaultVersionComparator) Compare(v1, v2 string) int {
+	return strings.Compare(v1, v2)
+}
+
+func (d DefaultVersionComparator) ValidateVersion(version string) error {
+	if version == "" {
+		return fmt.Errorf("%w: empty version", configapi.ErrInvalidVersion)
+	}
+	return nil
+}
+
+// SemverVersionComparator compares versions following semantic versioning 2.0.0 with optional 'v' prefix, e.g. v1.2.3-rc.1+build.5
+// Invalid versions are less than any valid version and compared literally among themselves.
+type SemverVersionComparator struct {
+}
+
+type semver struct {
+	major, minor, patch uint64
+	prerelease          []string
+}
+
+func parseSemver(v string) (*semver, bool) {
+	v = strings.TrimPrefix(v, "v")
+	// build metadata is ignored in precedence
+	if idx := strings.IndexByte(v, '+'); idx >= 0 {
+		if !validSemverIdentifiers(v[idx+1:], false) {
+			return nil, false
+		}
+		v = v[:idx]
+	}
+	var prerelease string
+	if idx := strings.IndexByte(v, '-'); idx >= 0 {
+		prerelease = v[idx+1:]
+		if !validSemverIdentifiers(prerelease, true) {
+			return nil, false
+		}
+		v = v[:idx]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	var nums [3]uint64
+	for i, p := range parts {
+		if !isSemverNumber(p) {
+			return nil, false
+		}
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		nums[i] = n
+	}
+	s := &semver{major: nums[0], minor: nums[1], patch: nums[2]}
+	if prerelease != "" {
+		s.prerelease = strings.Split(prerelease, ".")
+	}
+	return s, true
+}
+
+func isSemverNumber(s string) bool {
+	if s == "" || (len(s) > 1 && s[0] == '0') {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func validSemverIdentifiers(s string, checkLeadingZero bool) bool {
+	if s == "" {
+		return false
+	}
+	for _, ident := range strings.Split(s, ".") {
+		if ident == "" {
+			return false
+		}
+		numeric := true
+		for _, c := range ident {
+			switch {
+			case c >= '0' && c <= '9':
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '-':
+				numeric = false
+			default:
+				return false
+			}
+		}
+		if checkLeadingZero && numeric && len(ident) > 1 && ident[0] == '0' {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *semver) compare(o *semver) int {
+	if r := cmp.Compare(s.major, o.major); r != 0 {
+		return r
+	}
+	if r := cmp.Compare(s.minor, o.minor); r != 0 {
+		return r
+	}
+	if r := cmp.Compare(s.patch, o.patch); r != 0 {
+		return r
+	}
+	// a version without prerelease has higher precedence
+	if len(s.prerelease) == 0 || len(o.prerelease) == 0 {
+		return cmp.Compare(len(o.prerelease), len(s.prerelease))
+	}
+	for i := 0; i < len(s.prerelease) && i < len(o.prerelease); i++ {
+		a, b := s.prerelease[i], o.prerelease[i]
+		an, aerr := strconv.ParseUint(a, 10, 64)
+		bn, berr := strconv.ParseUint(b, 10, 64)
+		var r int
+		switch {
+		case aerr == nil && berr == nil:
+			r = cmp.Compare(an, bn)
+		case aerr == nil:
+			r = -1 // numeric identifiers have lower precedence
+		case berr == nil:
+			r = 1
+		default:
+			r = strings.Compare(a, b)
+		}
+		if r != 0 {
+			return r
+		}
+	}
+	return cmp.Compare(len(s.prerelease), len(o.prerelease))
+}
+
+func (s SemverVersionComparator) HasUpdate(requestVersion, dataVersion string) bool {
+	return s.Compare(requestVersion, dataVersion) < 0
+}
+
+func (s SemverVersionComparator) Compare(v1, v2 string) int {
+	s1, ok1 := parseSemver(v1)
+	s2, ok2 := parseSemver(v2)
+	switch {
+	case ok1 && ok2:
+		return s1.compare(s2)
+	case ok1:
+		return 1
+	case ok2:
+		return -1
+	default:
+		return strings.Compare(v1, v2)
+	}
+}
+
+func (s SemverVersionComparator) ValidateVersion(version string) error {
+	if _, ok := parseSemver(version); !ok {
+		return fmt.Errorf("%w: not a semantic version: %s", configapi.ErrInvalidVersion, version)
+	}
+	return nil
+}
+
+// NumericVersionComparator compares versions as non-negative integers, e.g. 9 < 10
+// Invalid versions(including empty version) are less than any valid version and compared literally among themselves.
+type NumericVersionComparator struct {
+}
+
+func parseNumericVersion(v string) (int64, bool) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+func (n NumericVersionComparator) HasUpdate(requestVersion, dataVersion string) bool {
+	return n.Compare(requestVersion, dataVersion) < 0
+}
+
+func (n NumericVersionComparator) Compare(v1, v2 string) int {
+	n1, ok1 := parseNumericVersion(v1)
+	n2, ok2 := parseNumericVersion(v2)
+	switch {
+	case ok1 && ok2:
+		return cmp.Compare(n1, n2)
+	case ok1:
+		return 1
+	case ok2:
+		return -1
+	default:
+		return strings.Compare(v1, v2)
+	}
+}
+
+func (n NumericVersionComparator) ValidateVersion(version string) error {
+	if _, ok := parseNumericVersion(version); !ok {
+		return fmt.Errorf("%w: not a non-negative integer: %s", configapi.ErrInvalidVersion, version)
+	}
+	return nil
+}
+
+// TimestampVersionComparator generates the version from Configuration.Timestamp(the effective time) while publishing,
+// so the version in the publish request is ignored. Versions are formatted as <timestamp>[.<sequence>] where the
+// sequence distinguishes the configurations effective in the same second. Publishing a configuration effective earlier
+// than the current(or scheduled) one is rejected since its version is not increased.
+type TimestampVersionComparator struct {
+}
+
+type timestampVersion struct {
+	timestamp, sequence int64
+}
+
+func parseTimestampVersion(v string) (timestampVersion, bool) {
+	tsPart, seqPart, hasSeq := strings.Cut(v, ".")
+	ts, ok := parseNumericVersion(tsPart)
+	if !ok {
+		return timestampVersion{}, false
+	}
+	var seq int64
+	if hasSeq {
+		if seq, ok = parseNumericVersion(seqPart); !ok || seq == 0 {
+			return timestampVersion{}, false
+		}
+	}
+	return timestampVersion{timestamp: ts, sequence: seq}, true
+}
+
+func (t TimestampVersionComparator) HasUpdate(requestVersion, dataVersion string) bool {
+	return t.Compare(requestVersion, dataVersion) < 0
+}
+
+func (t TimestampVersionComparator) Compare(v1, v2 string) int {
+	t1, ok1 := parseTimestampVersion(v1)
+	t2, ok2 := parseTimestampVersion(v2)
+	switch {
+	case ok1 && ok2:
+		return cmp.Or(cmp.Compare(t1.timestamp, t2.timestamp), cmp.Compare(t1.sequence, t2.sequence))
+	case ok1:
+		return 1
+	case ok2:
+		return -1
+	default:
+		return strings.Compare(v1, v2)
+	}
+}
+
+func (t TimestampVersionComparator) ValidateVersion(version string) error {
+	if _, ok := parseTimestampVersion(version); !ok {
+		return fmt.Errorf("%w: not a timestamp version: %s", configapi.ErrInvalidVersion, version)
+	}
+	return nil
+}
+
+func (t TimestampVersionComparator) GenerateVersion(cfg *configapi.Configuration, current string) string {
+	if cur, ok := parseTimestampVersion(current); ok && cur.timestamp == cfg.Timestamp {
+		return fmt.Sprintf("%d.%d", cfg.Timestamp, cur.sequence+1)
+	}
+	return strconv.FormatInt(cfg.Timestamp, 10)
+}
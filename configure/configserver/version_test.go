@@ -0,0 +1,102 @@
+package configserver
+
+import (
+	"testing"
+
+	"github.com/meidoworks/nekoq-component/configure/configapi"
+)
+
+func TestSemverVersionComparator(t *testing.T) {
+	c := SemverVersionComparator{}
+	ordered := []string{
+		"",
+		"v1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"v1.0.1+build.1",
+		"v9.0.0",
+		"v10.0.0",
+	}
+	for i := 0; i < len(ordered)-1; i++ {
+		if !c.HasUpdate(ordered[i], ordered[i+1]) {
+			t.Fatal("expected update from", ordered[i], "to", ordered[i+1])
+		}
+		if c.HasUpdate(ordered[i+1], ordered[i]) {
+			t.Fatal("unexpected update from", ordered[i+1], "to", ordered[i])
+		}
+	}
+	if c.Compare("1.0.0+build.1", "1.0.0+build.2") != 0 {
+		t.Fatal("build metadata should be ignored")
+	}
+	for _, v := range []string{"", "1.0", "01.0.0", "1.0.0-", "1.0.0-01", "v1.x.0"} {
+		if c.ValidateVersion(v) == nil {
+			t.Fatal("invalid version expected:", v)
+		}
+	}
+}
+
+func TestNumericVersionComparator(t *testing.T) {
+	c := NumericVersionComparator{}
+	if !c.HasUpdate("9", "10") {
+		t.Fatal("10 should be newer than 9")
+	}
+	if !c.HasUpdate("", "1") {
+		t.Fatal("any valid version should be newer than empty version")
+	}
+	if c.HasUpdate("10", "10") {
+		t.Fatal("same version should not have update")
+	}
+	if c.ValidateVersion("-1") == nil || c.ValidateVersion("v1") == nil {
+		t.Fatal("invalid version expected")
+	}
+}
+
+func TestTimestampVersionComparator(t *testing.T) {
+	vc, err := NewVersionComparator(VersionComparatorTimestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generator, ok := vc.(configapi.VersionGenerator)
+	if !ok {
+		t.Fatal("timestamp comparator should generate versions")
+	}
+	validator, ok := vc.(configapi.VersionValidator)
+	if !ok {
+		t.Fatal("timestamp comparator should validate versions")
+	}
+	v := generator.GenerateVersion(&configapi.Configuration{Timestamp: 1700000000}, "")
+	if v != "1700000000" {
+		t.Fatal("version should be generated from the timestamp:", v)
+	}
+	// published within the same second
+	v2 := generator.GenerateVersion(&configapi.Configuration{Timestamp: 1700000000}, v)
+	if v2 != "1700000000.1" || !vc.HasUpdate(v, v2) {
+		t.Fatal("sequence should be increased within the same second:", v, v2)
+	}
+	if v3 := generator.GenerateVersion(&configapi.Configuration{Timestamp: 1700000000}, v2); v3 != "1700000000.2" {
+		t.Fatal("unexpected version:", v3)
+	}
+	// effective earlier than the current one
+	if v4 := generator.GenerateVersion(&configapi.Configuration{Timestamp: 1}, v2); validator.Compare(v4, v2) >= 0 {
+		t.Fatal("earlier timestamp should be older:", v4)
+	}
+	if !vc.HasUpdate("1699999999.9", v) || !vc.HasUpdate("9", "10") {
+		t.Fatal("versions should be ordered by the timestamp")
+	}
+	for _, v := range []string{"", "v1", "1.0", "1.x", "-1"} {
+		if validator.ValidateVersion(v) == nil {
+			t.Fatal("invalid version expected:", v)
+		}
+	}
+	if _, err := NewVersionComparator("unknown"); err == nil {
+		t.Fatal("unknown comparator should fail")
+	}
+	if err := NewConfigureServer(ConfigureOptions{VersionComparatorName: "unknown"}).Startup(); err == nil {
+		t.Fatal("unknown comparator should fail the startup")
+	}
+}
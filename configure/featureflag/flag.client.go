@@ -0,0 +1,50 @@
+package featureflag
+
+import (
+	"github.com/meidoworks/nekoq-component/configure/configclient"
+)
+
+// FeatureFlags evaluates the flags of a configuration which is updated live via the long polling of the client
+type FeatureFlags struct {
+	container *configclient.ConfigContainer[*FlagSet]
+	ctx       Context
+}
+
+// New registers the json configuration [group, key] as the FlagSet to the client.
+// It should be called before the client starts. The evaluation context is created from the options of the client.
+func New(client *configclient.Client, group, key string) (*FeatureFlags, error) {
+	container, err := configclient.NewClientAdv[*FlagSet](client).RegisterJsonContainer(group, key)
+	if err != nil {
+		return nil, err
+	}
+	return &FeatureFlags{
+		container: container,
+		ctx:       NewContext(client.Options()),
+	}, nil
+}
+
+// Container returns the underlying container, e.g. to subscribe changes via OnChange or OnError
+func (f *FeatureFlags) Container() *configclient.ConfigContainer[*FlagSet] {
+	return f.container
+}
+
+// Context returns the evaluation context
+func (f *FeatureFlags) Context() Context {
+	return f.ctx
+}
+
+// Enabled evaluates the flag using the host of the context as the bucketing key, so the percentage rollout is per
+// instance
+func (f *FeatureFlags) Enabled(name string) bool {
+	return f.Evaluate(name, f.ctx["host"]).Enabled
+}
+
+// EnabledFor evaluates the flag with the bucketing key supplied by the caller, e.g. user id
+func (f *FeatureFlags) EnabledFor(name, key string) bool {
+	return f.Evaluate(name, key).Enabled
+}
+
+// Evaluate evaluates the flag with the bucketing key and returns the reason
+func (f *FeatureFlags) Evaluate(name, key string) Result {
+	return f.container.Get().Evaluate(name, f.ctx, key)
+}
@@ -0,0 +1,163 @@
+package featureflag
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+
+	"github.com/meidoworks/nekoq-component/configure/configclient"
+)
+
+var (
+	ErrInvalidFlag = errors.New("invalid feature flag")
+)
+
+// bucketCount is the number of rollout buckets, supporting the precision of 0.01%
+const bucketCount = 10000
+
+// FlagSet is the json value schema of the feature flag configuration
+//
+// Example:
+//
+//	{
+//	  "flags": {
+//	    "new_checkout": {"enabled": true, "rollout": 25, "allow": {"host": ["host1"], "app": ["app1"]}},
+//	    "dark_mode": {"enabled": true}
+//	  }
+//	}
+type FlagSet struct {
+	Flags map[string]*Flag `json:"flags"`
+}
+
+// Flag defines the evaluation of a feature flag in order:
+//  1. Disabled flag is always off
+//  2. Deny lists turn the flag off if any context attribute matches
+//  3. Allow lists turn the flag on if any context attribute matches
+//  4. Rollout turns the flag on for the percentage of the buckets by the key
+type Flag struct {
+	Enabled bool `json:"enabled"`
+	// Rollout is the percentage in [0, 100] with the precision of 0.01, nil = 100
+	Rollout *float64 `json:"rollout,omitempty"`
+	// Allow and Deny are lists of values by context attributes, e.g. {"host": ["host1"], "app": ["app1"]}
+	Allow map[string][]string `json:"allow,omitempty"`
+	Deny  map[string][]string `json:"deny,omitempty"`
+	// Salt is used for bucketing instead of the flag name, changing it reshuffles the buckets
+	Salt string `json:"salt,omitempty"`
+}
+
+// Validate checks the flags. It is called by ClientAdv before applying updates, so invalid flags are rejected and the
+// last applied flags are kept.
+func (f *FlagSet) Validate() error {
+	var errs []error
+	for name, flag := range f.Flags {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, fmt.Errorf("%w: empty name", ErrInvalidFlag))
+			continue
+		}
+		if flag == nil {
+			errs = append(errs, fmt.Errorf("%w: %s is null", ErrInvalidFlag, name))
+			continue
+		}
+		if flag.Rollout != nil && (*flag.Rollout < 0 || *flag.Rollout > 100) {
+			errs = append(errs, fmt.Errorf("%w: %s rollout should be in [0, 100]", ErrInvalidFlag, name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Context contains the attributes for evaluation, e.g. env, dc, ns, app, cluster, host, beta
+type Context map[string]string
+
+// NewContext creates the evaluation context from the selectors and the optional selectors of the client options
+func NewContext(opt configclient.ClientOptions) Context {
+	ctx := Context{}
+	sel := opt.ToSelectors()
+	for k, v := range sel.Data {
+		ctx[k] = v
+	}
+	optSel := opt.ToOptSelectors()
+	for k, v := range optSel.Data {
+		ctx[k] = v
+	}
+	return ctx
+}
+
+type Reason string
+
+const (
+	ReasonNotFound Reason = "not_found"
+	ReasonDisabled Reason = "disabled"
+	ReasonDenied   Reason = "denied"
+	ReasonAllowed  Reason = "allowed"
+	ReasonRollout  Reason = "rollout"
+	// ReasonNoKey means the rollout is partial but no key is provided for bucketing
+	ReasonNoKey Reason = "no_key"
+)
+
+type Result struct {
+	Enabled bool
+	Reason  Reason
+}
+
+// Evaluate evaluates the flag with the context and the bucketing key
+func (f *Flag) Evaluate(name string, ctx Context, key string) Result {
+	if !f.Enabled {
+		return Result{Enabled: false, Reason: ReasonDisabled}
+	}
+	if matchList(f.Deny, ctx) {
+		return Result{Enabled: false, Reason: ReasonDenied}
+	}
+	if matchList(f.Allow, ctx) {
+		return Result{Enabled: true, Reason: ReasonAllowed}
+	}
+	if f.Rollout == nil || *f.Rollout >= 100 {
+		return Result{Enabled: true, Reason: ReasonRollout}
+	}
+	if *f.Rollout <= 0 {
+		return Result{Enabled: false, Reason: ReasonRollout}
+	}
+	if key == "" {
+		return Result{Enabled: false, Reason: ReasonNoKey}
+	}
+	salt := f.Salt
+	if salt == "" {
+		salt = name
+	}
+	threshold := int(math.Round(*f.Rollout * bucketCount / 100))
+	return Result{Enabled: Bucket(salt, key) < threshold, Reason: ReasonRollout}
+}
+
+func matchList(lists map[string][]string, ctx Context) bool {
+	for attr, values := range lists {
+		v, ok := ctx[attr]
+		if !ok {
+			continue
+		}
+		for _, value := range values {
+			if value == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Bucket returns the deterministic bucket in [0, 10000) of the key
+func Bucket(salt, key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(salt))
+	_, _ = h.Write([]byte{':'})
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % bucketCount)
+}
+
+// Evaluate evaluates the flag by name, the flag not found is off
+func (f *FlagSet) Evaluate(name string, ctx Context, key string) Result {
+	flag, ok := f.Flags[name]
+	if !ok || flag == nil {
+		return Result{Enabled: false, Reason: ReasonNotFound}
+	}
+	return flag.Evaluate(name, ctx, key)
+}
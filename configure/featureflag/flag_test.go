@@ -0,0 +1,116 @@
+package featureflag
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/meidoworks/nekoq-component/configure/configclient"
+)
+
+func parseFlagSet(t *testing.T, data string) *FlagSet {
+	f := new(FlagSet)
+	if err := json.Unmarshal([]byte(data), f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestFlagSet_Evaluate(t *testing.T) {
+	f := parseFlagSet(t, `{
+  "flags": {
+    "on": {"enabled": true},
+    "off": {"enabled": false, "allow": {"host": ["host1"]}},
+    "allow_only": {"enabled": true, "rollout": 0, "allow": {"host": ["host1"], "app": ["app2"]}},
+    "deny": {"enabled": true, "deny": {"env": ["prod"]}},
+    "half": {"enabled": true, "rollout": 50}
+  }
+}`)
+	ctx := NewContext(configclient.ClientOptions{SelectorEnvironment: "prod", SelectorApp: "app1", SelectorHostName: "host1"})
+	if ctx["env"] != "prod" || ctx["app"] != "app1" || ctx["host"] != "host1" {
+		t.Fatal("unexpected context:", ctx)
+	}
+
+	cases := []struct {
+		name   string
+		ctx    Context
+		result Result
+	}{
+		{"on", ctx, Result{true, ReasonRollout}},
+		{"off", ctx, Result{false, ReasonDisabled}},
+		{"missing", ctx, Result{false, ReasonNotFound}},
+		{"allow_only", ctx, Result{true, ReasonAllowed}},
+		{"allow_only", Context{"app": "app2"}, Result{true, ReasonAllowed}},
+		{"allow_only", Context{"host": "host2"}, Result{false, ReasonRollout}},
+		{"deny", ctx, Result{false, ReasonDenied}},
+		{"deny", Context{"env": "test"}, Result{true, ReasonRollout}},
+		{"half", ctx, Result{false, ReasonNoKey}},
+	}
+	for _, c := range cases {
+		if r := f.Evaluate(c.name, c.ctx, ""); r != c.result {
+			t.Fatal("unexpected result of", c.name, c.ctx, r)
+		}
+	}
+}
+
+func TestFlag_RolloutBucketing(t *testing.T) {
+	rollout := 30.0
+	flag := &Flag{Enabled: true, Rollout: &rollout}
+	enabled := 0
+	const total = 10000
+	for i := 0; i < total; i++ {
+		key := "user" + strconv.Itoa(i)
+		r1 := flag.Evaluate("flag1", nil, key)
+		if r2 := flag.Evaluate("flag1", nil, key); r1 != r2 {
+			t.Fatal("bucketing should be deterministic")
+		}
+		if r1.Enabled {
+			enabled++
+		}
+	}
+	if enabled < total*25/100 || enabled > total*35/100 {
+		t.Fatal("unexpected rollout ratio:", enabled)
+	}
+
+	// increasing the rollout keeps the enabled keys enabled
+	more := 60.0
+	flag2 := &Flag{Enabled: true, Rollout: &more}
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if flag.Evaluate("flag1", nil, key).Enabled && !flag2.Evaluate("flag1", nil, key).Enabled {
+			t.Fatal("enabled key should stay enabled while increasing rollout:", key)
+		}
+	}
+	if Bucket("flag1", "key") == Bucket("flag2", "key") && Bucket("flag1", "key2") == Bucket("flag2", "key2") {
+		t.Fatal("buckets should differ by salt")
+	}
+}
+
+func TestFlagSet_Validate(t *testing.T) {
+	f := new(FlagSet)
+	if err := json.Unmarshal([]byte(`{"flags": {"a": {"enabled": true, "rollout": 101}, "b": null}}`), f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Validate(); !errors.Is(err, ErrInvalidFlag) {
+		t.Fatal("invalid flags should fail:", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	client := configclient.NewClient([]string{"http://127.0.0.1:1"}, configclient.ClientOptions{SelectorApp: "app1", SelectorHostName: "host1"})
+	flags, err := New(client, "group1", "flags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Context()["app"] != "app1" {
+		t.Fatal("context should come from client options")
+	}
+	// flags are off before any configuration is retrieved
+	if flags.Enabled("any") || flags.Evaluate("any", "key").Reason != ReasonNotFound {
+		t.Fatal("flag should be off before loaded")
+	}
+}
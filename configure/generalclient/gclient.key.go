@@ -3,6 +3,7 @@ package generalclient
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 )
@@ -48,6 +49,16 @@ func (g *GeneralClient) GetKeyByName(name string) (GetKeyResult, error) {
 	return r, nil
 }
 
+// ResolveSecret returns the standard base64 encoded key of the name, which implements configapi.SecretResolver for
+// the configuration templates
+func (g *GeneralClient) ResolveSecret(name string) (string, error) {
+	r, err := g.GetKeyByName(name)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(r.Key), nil
+}
+
 func (g *GeneralClient) GetKeyById(id string) (GetKeyResult, error) {
 	req := SecretRequest{
 		Method: "GET",